@@ -0,0 +1,112 @@
+// datacc 在存储引擎上启动HTTP服务
+//
+// 用法: datacc [-path dir] [-bind addr] [-compression none|snappy|deflate] [-memory-limit bytes] [-bit-group key=k0,k1,...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hooone/datacc/http"
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/engine"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+func main() {
+	c := http.NewConfig()
+	path := flag.String("path", "data", "data directory")
+	flag.StringVar(&c.BindAddress, "bind", c.BindAddress, "HTTP bind address")
+	flag.Int64Var(&c.MaxBodySize, "max-body-size", c.MaxBodySize, "max request body size in bytes")
+	flag.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "write and query timeout")
+	flag.IntVar(&c.ChunkSize, "chunk-size", c.ChunkSize, "rows per chunk in query responses")
+	compression := flag.String("compression", "none", "TSM block compression: none, snappy or deflate")
+	memoryLimit := flag.Uint64("memory-limit", 0, "cache memory limit in bytes, 0 for unlimited")
+	memorySnapshot := flag.Uint64("memory-snapshot-size", 0, "cache memory above which a snapshot is triggered early, 0 to disable")
+	var groups bitGroups
+	flag.Var(&groups, "bit-group", "store boolean keys as bits of one key: key=k0,k1,...,k7, 0 for unused bits; repeatable")
+	flag.Parse()
+
+	e := engine.NewEngine(*path)
+	comp, err := lsm.ParseBlockCompression(*compression)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	e.Compactor.Compression = comp
+	e.BitGroups = groups
+	if *memoryLimit > 0 || *memorySnapshot > 0 {
+		e.MemoryBudget = cache.NewMemoryBudget(*memoryLimit, *memorySnapshot)
+		e.MemoryBudget.OnSnapshot = func(string, *cache.Cache) {
+			if err := e.WriteSnapshot(); err != nil {
+				fmt.Fprintf(os.Stderr, "write snapshot fail: %v\n", err)
+			}
+		}
+	}
+	if err := e.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "open engine fail: %v\n", err)
+		os.Exit(1)
+	}
+	s := http.NewService(c, e)
+	if err := s.Open(); err != nil {
+		e.Close()
+		fmt.Fprintf(os.Stderr, "open http service fail: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("listening on %s, data in %s\n", s.Addr(), *path)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	s.Close()
+	if err := e.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "close engine fail: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// -bit-group参数，每个为key=k0,k1,...,k7，第i个源key对应第i位
+type bitGroups []*cache.BitGroup
+
+func (g *bitGroups) String() string {
+	keys := make([]string, len(*g))
+	for i, bg := range *g {
+		keys[i] = strconv.FormatUint(uint64(bg.Key()), 10)
+	}
+	return strings.Join(keys, ",")
+}
+
+func (g *bitGroups) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return fmt.Errorf("invalid bit group %q, expected key=k0,k1,...", s)
+	}
+	key, err := strconv.ParseUint(s[:i], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid bit group key %q", s[:i])
+	}
+	var sources [8]uint32
+	parts := strings.Split(s[i+1:], ",")
+	if len(parts) > len(sources) {
+		return fmt.Errorf("too many keys in bit group %q", s)
+	}
+	for j, p := range parts {
+		k, err := strconv.ParseUint(strings.TrimSpace(p), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid key %q in bit group %q", p, s)
+		}
+		sources[j] = uint32(k)
+	}
+	bg, err := cache.NewBitGroup(uint32(key), sources)
+	if err != nil {
+		return err
+	}
+	*g = append(*g, bg)
+	return nil
+}
@@ -0,0 +1,39 @@
+// tsmrebuild 从v2格式TSM文件的数据块重建Index区和文件尾，用于修复末尾被截断或Index损坏的文件
+//
+// 用法: tsmrebuild file.tsm [file.tsm...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hooone/datacc/store/lsm"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tsmrebuild file.tsm [file.tsm...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		res, err := lsm.RebuildIndex(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: rebuild fail: %v\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: keys %d, blocks %d, data size %d, truncated %d bytes\n",
+			path, res.Keys, res.Blocks, res.DataSize, res.Truncated)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,107 @@
+package bloom
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cespare/xxhash"
+)
+
+// 布隆过滤器。Contains返回false时数据一定不存在，返回true时数据可能存在
+type Filter struct {
+	// 位数组
+	b []byte
+	// 位数组的长度(bit)
+	m uint64
+	// 哈希函数的个数
+	k uint64
+}
+
+// 新建过滤器，m为位数，会向上取整为8的倍数，k为哈希函数个数
+func NewFilter(m uint64, k uint64) *Filter {
+	m = (m + 7) / 8 * 8
+	if m == 0 {
+		m = 8
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &Filter{
+		b: make([]byte, m/8),
+		m: m,
+		k: k,
+	}
+}
+
+// 位数组为空，无法恢复过滤器
+var ErrEmptyFilter = fmt.Errorf("empty bloom filter")
+
+// 由已有的位数组创建过滤器，用于读取持久化的过滤器
+func NewFilterBuffer(b []byte, k uint64) (*Filter, error) {
+	if len(b) == 0 {
+		return nil, ErrEmptyFilter
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &Filter{
+		b: b,
+		m: uint64(len(b)) * 8,
+		k: k,
+	}, nil
+}
+
+// 根据元素个数n和期望的误判率p估算位数和哈希函数个数
+func Estimate(n uint64, p float64) (m uint64, k uint64) {
+	if n == 0 {
+		n = 1
+	}
+	m = uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k = uint64(math.Ceil(math.Ln2 * float64(m) / float64(n)))
+	if k == 0 {
+		k = 1
+	}
+	return m, k
+}
+
+// 添加元素
+func (f *Filter) Insert(v []byte) {
+	h1, h2 := hash(v)
+	for i := uint64(0); i < f.k; i++ {
+		loc := f.location(h1, h2, i)
+		f.b[loc>>3] |= 1 << (loc & 7)
+	}
+}
+
+// 元素是否可能存在
+func (f *Filter) Contains(v []byte) bool {
+	h1, h2 := hash(v)
+	for i := uint64(0); i < f.k; i++ {
+		loc := f.location(h1, h2, i)
+		if f.b[loc>>3]&(1<<(loc&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 位数组
+func (f *Filter) Bytes() []byte {
+	return f.b
+}
+
+// 哈希函数个数
+func (f *Filter) K() uint64 {
+	return f.k
+}
+
+// 双重哈希方式计算第i个位置
+func (f *Filter) location(h1, h2 uint32, i uint64) uint64 {
+	return (uint64(h1) + i*uint64(h2)) % f.m
+}
+
+// 把64位哈希值拆分为两个32位哈希值
+func hash(v []byte) (uint32, uint32) {
+	h := xxhash.Sum64(v)
+	return uint32(h), uint32(h >> 32)
+}
@@ -0,0 +1,50 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// 过滤器测试
+func TestFilter_Contains(t *testing.T) {
+	m, k := Estimate(1000, 0.01)
+	f := NewFilter(m, k)
+	var buf [4]byte
+	for i := uint32(0); i < 1000; i++ {
+		binary.LittleEndian.PutUint32(buf[:], i*2)
+		f.Insert(buf[:])
+	}
+
+	// 已添加的元素一定存在
+	for i := uint32(0); i < 1000; i++ {
+		binary.LittleEndian.PutUint32(buf[:], i*2)
+		if !f.Contains(buf[:]) {
+			t.Fatalf("expected contains %d", i*2)
+		}
+	}
+
+	// 误判率接近期望值
+	fp := 0
+	for i := uint32(0); i < 1000; i++ {
+		binary.LittleEndian.PutUint32(buf[:], i*2+1)
+		if f.Contains(buf[:]) {
+			fp++
+		}
+	}
+	if fp > 30 {
+		t.Fatalf("false positive too high: %d", fp)
+	}
+
+	// 从位数组恢复
+	g, err := NewFilterBuffer(f.Bytes(), f.K())
+	if err != nil {
+		t.Fatalf("restore filter fail: %v", err)
+	}
+	binary.LittleEndian.PutUint32(buf[:], 10)
+	if !g.Contains(buf[:]) {
+		t.Fatalf("expected restored filter contains 10")
+	}
+	if _, err := NewFilterBuffer(nil, 1); err != ErrEmptyFilter {
+		t.Fatalf("expected empty filter error, got %v", err)
+	}
+}
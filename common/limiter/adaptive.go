@@ -0,0 +1,94 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 自适应限流器。WAL刷盘延时升高时按比例降低限流速度，给WAL让出硬盘带宽
+type AdaptiveRate struct {
+	bucket *TokenBucket
+
+	mu sync.Mutex
+	// 正常情况下的限流速度
+	baseRate int
+	// 降速后的最低速度
+	minRate int
+	// 刷盘延时的阈值，超过后开始降速
+	threshold time.Duration
+	// 刷盘延时的滑动平均值
+	latency time.Duration
+}
+
+// 滑动平均中新样本的权重
+const latencyWeight = 0.2
+
+func NewAdaptiveRate(rate, burst, minRate int, threshold time.Duration) *AdaptiveRate {
+	if minRate > rate {
+		minRate = rate
+	}
+	return &AdaptiveRate{
+		bucket:    NewTokenBucket(rate, burst),
+		baseRate:  rate,
+		minRate:   minRate,
+		threshold: threshold,
+	}
+}
+
+func (a *AdaptiveRate) WaitN(ctx context.Context, n int) error {
+	return a.bucket.WaitN(ctx, n)
+}
+
+func (a *AdaptiveRate) Burst() int {
+	return a.bucket.Burst()
+}
+
+// 当前生效的限流速度
+func (a *AdaptiveRate) Limit() int {
+	return a.bucket.Limit()
+}
+
+// 运行时修改正常情况下的限流速度
+func (a *AdaptiveRate) SetLimit(rate int) {
+	a.mu.Lock()
+	a.baseRate = rate
+	if a.minRate > rate {
+		a.minRate = rate
+	}
+	a.mu.Unlock()
+	a.adjust()
+}
+
+// 平均刷盘延时
+func (a *AdaptiveRate) Latency() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.latency
+}
+
+// Observe 记录一次WAL刷盘的耗时，并据此调整限流速度
+func (a *AdaptiveRate) Observe(d time.Duration) {
+	a.mu.Lock()
+	if a.latency == 0 {
+		a.latency = d
+	} else {
+		a.latency = time.Duration(float64(a.latency)*(1-latencyWeight) + float64(d)*latencyWeight)
+	}
+	a.mu.Unlock()
+	a.adjust()
+}
+
+// 延时超过阈值时，速度按 阈值/延时 的比例降低，但不低于minRate
+func (a *AdaptiveRate) adjust() {
+	a.mu.Lock()
+	rate := a.baseRate
+	if a.threshold > 0 && a.latency > a.threshold && rate > 0 {
+		rate = int(float64(rate) * float64(a.threshold) / float64(a.latency))
+		if rate < a.minRate {
+			rate = a.minRate
+		}
+	}
+	a.mu.Unlock()
+	a.bucket.SetLimit(rate)
+}
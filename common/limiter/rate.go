@@ -0,0 +1,108 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 令牌桶限流器，实现Rate接口。
+// 桶中的令牌按rate每秒的速度恢复，最多存burst个，每写入n个字节消耗n个令牌
+type TokenBucket struct {
+	mu sync.Mutex
+	// 每秒恢复的令牌数，<=0时不限流
+	rate int
+	// 桶的容量
+	burst int
+	// 当前令牌数，可以为负，代表已被预约的令牌
+	tokens float64
+	// 最近一次计算令牌的时间
+	last time.Time
+}
+
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 等待直到n个令牌可用，或ctx结束
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	if n > b.burst {
+		b.mu.Unlock()
+		return fmt.Errorf("rate: wait(n=%d) exceeds limiter's burst %d", n, b.burst)
+	}
+
+	// 预约令牌，计算需要等待的时间
+	now := time.Now()
+	b.advance(now)
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	// 等待令牌恢复
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// 取消等待，归还预约的令牌
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// 桶的容量，也是单次允许写入的最大数量
+func (b *TokenBucket) Burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.burst
+}
+
+// 当前每秒恢复的令牌数
+func (b *TokenBucket) Limit() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// 运行时修改每秒恢复的令牌数，<=0为不限流
+func (b *TokenBucket) SetLimit(rate int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// 先按原速度结算令牌
+	b.advance(time.Now())
+	b.rate = rate
+}
+
+// 按经过的时间恢复令牌
+func (b *TokenBucket) advance(now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.last = now
+	if elapsed <= 0 || b.rate <= 0 {
+		return
+	}
+	b.tokens += elapsed.Seconds() * float64(b.rate)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+}
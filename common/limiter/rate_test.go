@@ -0,0 +1,73 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 令牌桶限流测试
+func TestTokenBucket_WaitN(t *testing.T) {
+	b := NewTokenBucket(1000, 100)
+
+	// 初始令牌可直接使用
+	start := time.Now()
+	if err := b.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("wait fail: %v", err)
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatalf("unexpected wait for initial burst")
+	}
+
+	// 令牌耗尽后需要等待恢复
+	start = time.Now()
+	if err := b.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("wait fail: %v", err)
+	}
+	if time.Since(start) < 80*time.Millisecond {
+		t.Fatalf("expected wait for tokens, got %v", time.Since(start))
+	}
+
+	// 超过容量
+	if err := b.WaitN(context.Background(), 101); err == nil {
+		t.Fatalf("expected burst exceeded")
+	}
+
+	// 等待可以被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	b.SetLimit(1)
+	if err := b.WaitN(ctx, 100); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// 不限流
+	b.SetLimit(0)
+	if err := b.WaitN(context.Background(), 100); err != nil {
+		t.Fatalf("wait fail: %v", err)
+	}
+}
+
+// 自适应限流测试
+func TestAdaptiveRate_Observe(t *testing.T) {
+	a := NewAdaptiveRate(1000, 100, 100, 10*time.Millisecond)
+
+	a.Observe(5 * time.Millisecond)
+	if a.Limit() != 1000 {
+		t.Fatalf("rate error: except 1000, actual %d", a.Limit())
+	}
+
+	// 延时超过阈值后降速
+	a.Observe(45 * time.Millisecond)
+	if a.Latency() != 13*time.Millisecond || a.Limit() >= 1000 {
+		t.Fatalf("rate not reduced: latency %v, rate %d", a.Latency(), a.Limit())
+	}
+
+	// 不低于最低速度
+	for i := 0; i < 20; i++ {
+		a.Observe(time.Second)
+	}
+	if a.Limit() != 100 {
+		t.Fatalf("rate error: except 100, actual %d", a.Limit())
+	}
+}
@@ -0,0 +1,45 @@
+package http
+
+import "time"
+
+const (
+	// 默认监听地址
+	DefaultBindAddress = ":8086"
+	// 请求体的默认大小上限
+	DefaultMaxBodySize = 25 * 1024 * 1024
+	// 读取请求的默认超时
+	DefaultReadTimeout = 30 * time.Second
+	// 每次向连接写出数据的默认超时
+	DefaultWriteTimeout = 60 * time.Second
+	// 处理请求的默认超时
+	DefaultRequestTimeout = 30 * time.Second
+	// 查询结果每块的默认行数
+	DefaultChunkSize = 10000
+)
+
+// 服务配置
+type Config struct {
+	BindAddress string
+	// 请求体的大小上限，超过时返回413
+	MaxBodySize int64
+	// 读取整个请求的超时，为0时不限制
+	ReadTimeout time.Duration
+	// 每次向连接写出数据的超时，为0时不限制。不限制整个响应的时间，流式查询只要每块数据能按时写出就不会中断
+	WriteTimeout time.Duration
+	// 写入和查询的处理超时，为0时不限制。写入在开始前超时返回503且数据没有写入，开始写入后等待写入完成；
+	// 查询只限制发送第一块结果之前的时间，开始输出后只在客户端断开时停止
+	RequestTimeout time.Duration
+	// 查询结果分块写出，每块的行数，可由chunk_size参数覆盖
+	ChunkSize int
+}
+
+func NewConfig() Config {
+	return Config{
+		BindAddress:    DefaultBindAddress,
+		MaxBodySize:    DefaultMaxBodySize,
+		ReadTimeout:    DefaultReadTimeout,
+		WriteTimeout:   DefaultWriteTimeout,
+		RequestTimeout: DefaultRequestTimeout,
+		ChunkSize:      DefaultChunkSize,
+	}
+}
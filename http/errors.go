@@ -0,0 +1,44 @@
+package http
+
+import "fmt"
+
+var (
+	// 请求体超过大小上限
+	ErrBodyTooLarge = fmt.Errorf("request body too large")
+
+	// 请求处理超时
+	ErrRequestTimeout = fmt.Errorf("request timeout")
+
+	// 查询请求缺少q参数
+	ErrMissingQuery = fmt.Errorf("missing required parameter \"q\"")
+)
+
+// 无法解析的key
+func ErrInvalidKey(key string) error {
+	return fmt.Errorf("invalid key: %q", key)
+}
+
+// 值超出0~255的范围
+func ErrInvalidValue(key string, value int64) error {
+	return fmt.Errorf("invalid value for key %s: %d", key, value)
+}
+
+// 不支持的时间精度
+func ErrInvalidPrecision(precision string) error {
+	return fmt.Errorf("invalid precision: %q", precision)
+}
+
+// 不支持的请求方法
+func ErrMethodNotAllowed(method string) error {
+	return fmt.Errorf("method not allowed: %s", method)
+}
+
+// 不支持的查询结果格式
+func ErrInvalidFormat(format string) error {
+	return fmt.Errorf("invalid format: %q", format)
+}
+
+// 无效的分块行数
+func ErrInvalidChunkSize(size string) error {
+	return fmt.Errorf("invalid chunk size: %q", size)
+}
@@ -0,0 +1,298 @@
+// http 提供写入、查询和状态接口的HTTP服务
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hooone/datacc/query"
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/engine"
+)
+
+// 时间精度对应的纳秒数
+var precisions = map[string]int64{
+	"":   1,
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+}
+
+// Handler 处理HTTP请求:
+//
+//	POST /write       写入数据，请求体为{"key": [[time, value], ...], ...}，成功返回204
+//	GET|POST /query   执行查询语句q，返回{"series": [...]}。结果分块写出，每块chunk_size行，
+//	                  按format参数(json、csv、ndjson)或Accept头选择格式
+//	GET /ping         返回204
+//	GET /debug/vars   WAL、Cache和TSM文件的状态统计
+//
+// 错误以{"error": "..."}返回
+type Handler struct {
+	Config Config
+	Engine *engine.Engine
+
+	// 当前时间，用于查询中的now()。为nil时使用time.Now
+	Now func() time.Time
+
+	mux *http.ServeMux
+}
+
+func NewHandler(c Config, e *engine.Engine) *Handler {
+	h := &Handler{Config: c, Engine: e, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/write", h.allow(h.serveWrite, http.MethodPost))
+	h.mux.HandleFunc("/query", h.allow(h.serveQuery, http.MethodGet, http.MethodPost))
+	h.mux.HandleFunc("/ping", h.allow(h.servePing, http.MethodGet, http.MethodHead))
+	h.mux.HandleFunc("/debug/vars", h.allow(h.serveVars, http.MethodGet))
+	h.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	})
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// 只接受指定的请求方法，否则返回405
+func (h *Handler) allow(fn http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				fn(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed(r.Method).Error())
+	}
+}
+
+// 处理结果: 状态码和JSON响应体，body为nil时没有响应体
+type response struct {
+	code int
+	body interface{}
+}
+
+// 按RequestTimeout设置写入请求的处理期限
+func (h *Handler) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if h.Config.RequestTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), h.Config.RequestTimeout)
+}
+
+// 读取请求体，超过MaxBodySize时返回ErrBodyTooLarge
+func (h *Handler) readBody(r *http.Request) ([]byte, error) {
+	limit := h.Config.MaxBodySize
+	if limit <= 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+	if r.ContentLength > limit {
+		return nil, ErrBodyTooLarge
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return b, nil
+}
+
+// 读取请求体出错时的响应
+func bodyError(err error) response {
+	if err == ErrBodyTooLarge {
+		return errorResponse(http.StatusRequestEntityTooLarge, err)
+	}
+	return errorResponse(http.StatusBadRequest, err)
+}
+
+func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request) {
+	scale, ok := precisions[r.URL.Query().Get("precision")]
+	if !ok {
+		writeResponse(w, errorResponse(http.StatusBadRequest, ErrInvalidPrecision(r.URL.Query().Get("precision"))))
+		return
+	}
+	b, err := h.readBody(r)
+	if err != nil {
+		writeResponse(w, bodyError(err))
+		return
+	}
+	values, err := parsePoints(b, scale)
+	if err != nil {
+		writeResponse(w, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	// 超时前还没有开始写入时返回503，此时数据没有写入；开始写入后等待写入完成
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	if len(values) > 0 {
+		if err := h.Engine.WritePointsContext(ctx, values); err == context.DeadlineExceeded {
+			writeResponse(w, errorResponse(http.StatusServiceUnavailable, ErrRequestTimeout))
+			return
+		} else if err != nil {
+			writeResponse(w, errorResponse(http.StatusInternalServerError, err))
+			return
+		}
+	}
+	writeResponse(w, response{code: http.StatusNoContent})
+}
+
+// 解析写入的数据，时间乘以scale转为纳秒
+func parsePoints(b []byte, scale int64) (map[uint32][]coder.Value, error) {
+	var body map[string][][2]int64
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+	values := make(map[uint32][]coder.Value, len(body))
+	for k, points := range body {
+		key, err := strconv.ParseUint(k, 10, 32)
+		if err != nil {
+			return nil, ErrInvalidKey(k)
+		}
+		vs := make([]coder.Value, 0, len(points))
+		for _, p := range points {
+			if p[1] < 0 || p[1] > 255 {
+				return nil, ErrInvalidValue(k, p[1])
+			}
+			vs = append(vs, coder.NewValue(p[0]*scale, byte(p[1])))
+		}
+		if len(vs) > 0 {
+			values[uint32(key)] = vs
+		}
+	}
+	return values, nil
+}
+
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
+	params, err := h.queryParams(r)
+	if err != nil {
+		writeResponse(w, bodyError(err))
+		return
+	}
+	q := params.Get("q")
+	if q == "" {
+		writeResponse(w, errorResponse(http.StatusBadRequest, ErrMissingQuery))
+		return
+	}
+	format, err := queryFormat(params, r.Header.Get("Accept"))
+	if err != nil {
+		writeResponse(w, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+	chunkSize := h.Config.ChunkSize
+	if s := params.Get("chunk_size"); s != "" {
+		if chunkSize, err = strconv.Atoi(s); err != nil || chunkSize <= 0 {
+			writeResponse(w, errorResponse(http.StatusBadRequest, ErrInvalidChunkSize(s)))
+			return
+		}
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	stmt, err := query.ParseStatement(q)
+	if err != nil {
+		writeResponse(w, errorResponse(http.StatusBadRequest, err))
+		return
+	}
+
+	// 查询在请求的goroutine中边读边写，客户端断开或超时后游标停止读取。
+	// RequestTimeout只限制第一块结果之前的时间，发送响应头后不再超时
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	cw := newChunkWriter(w, format, chunkSize)
+	var timedOut int32
+	if h.Config.RequestTimeout > 0 {
+		timer := time.AfterFunc(h.Config.RequestTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		defer timer.Stop()
+		cw.onSend = func() { timer.Stop() }
+	}
+	e := &query.Executor{Cache: h.Engine.Cache, FileStore: h.Engine.FileStore, Now: h.Now}
+	err = e.ExecuteStream(ctx, stmt, cw)
+	// 查询语句的错误返回400，读取数据等其他错误返回500
+	code := http.StatusInternalServerError
+	switch {
+	case err == nil:
+	case atomic.LoadInt32(&timedOut) == 1 || ctx.Err() == context.DeadlineExceeded:
+		err, code = ErrRequestTimeout, http.StatusServiceUnavailable
+	case query.IsQueryError(err):
+		code = http.StatusBadRequest
+	}
+	cw.Close(err, code)
+}
+
+// 查询参数: GET时为URL参数；POST表单时合并URL和表单参数；其他POST时请求体为q参数，URL中已有q时忽略请求体
+func (h *Handler) queryParams(r *http.Request) (url.Values, error) {
+	params := r.URL.Query()
+	if r.Method == http.MethodGet {
+		return params, nil
+	}
+	b, err := h.readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range form {
+			params[k] = v
+		}
+		return params, nil
+	}
+	if params.Get("q") == "" {
+		params.Set("q", string(b))
+	}
+	return params, nil
+}
+
+func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) serveVars(w http.ResponseWriter, r *http.Request) {
+	stats := h.Engine.Statistics()
+	writeResponse(w, response{code: http.StatusOK, body: map[string]interface{}{
+		"wal":       stats.WAL,
+		"cache":     stats.Cache,
+		"filestore": stats.FileStore,
+	}})
+}
+
+func errorResponse(code int, err error) response {
+	return response{code: code, body: map[string]string{"error": err.Error()}}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeResponse(w, response{code: code, body: map[string]string{"error": msg}})
+}
+
+func writeResponse(w http.ResponseWriter, resp response) {
+	if resp.body == nil {
+		w.WriteHeader(resp.code)
+		return
+	}
+	b, err := json.Marshal(resp.body)
+	if err != nil {
+		resp.code = http.StatusInternalServerError
+		b, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.code)
+	w.Write(append(b, '\n'))
+}
@@ -0,0 +1,235 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooone/datacc/store/engine"
+)
+
+func newTestHandler(t *testing.T) (*Handler, func()) {
+	dir, err := ioutil.TempDir("", "http-")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	e := engine.NewEngine(dir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("open engine fail: %v", err)
+	}
+	c := NewConfig()
+	c.MaxBodySize = 1024
+	return NewHandler(c, e), func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func serve(h http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	return serveContext(context.Background(), h, method, target, contentType, body)
+}
+
+func serveContext(ctx context.Context, h http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// 已超过期限的请求上下文
+func expiredContext() context.Context {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Unix(0, 0))
+	cancel()
+	return ctx
+}
+
+// 错误响应为JSON
+func checkError(t *testing.T, name string, w *httptest.ResponseRecorder, code int) {
+	if w.Code != code {
+		t.Fatalf("%s: except status %d, actual %d: %s", name, code, w.Code, w.Body.String())
+	}
+	var body struct{ Error string }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Fatalf("%s: invalid error body %q: %v", name, w.Body.String(), err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s: content type error: %s", name, ct)
+	}
+}
+
+func TestHandler_WriteQuery(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	w := serve(h, "POST", "/write?precision=s", "application/json", `{"12": [[1, 10], [2, 20], [3, 30]], "13": [[1, 5]]}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("write error: %d %s", w.Code, w.Body.String())
+	}
+
+	var res struct {
+		Series []struct {
+			Name    string
+			Columns []string
+			Values  [][]float64
+		}
+	}
+	q := url.QueryEscape("SELECT mean(value), max(value) FROM 12")
+	w = serve(h, "GET", "/query?q="+q, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("query error: %d %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode query result fail: %v", err)
+	}
+	if len(res.Series) != 1 || res.Series[0].Name != "12" || len(res.Series[0].Values) != 1 {
+		t.Fatalf("query result error: %s", w.Body.String())
+	}
+	if row := res.Series[0].Values[0]; row[1] != 20 || row[2] != 30 {
+		t.Fatalf("query values error: %v", row)
+	}
+
+	// POST表单和请求体
+	for _, tt := range []struct{ contentType, body string }{
+		{"application/x-www-form-urlencoded", "q=" + url.QueryEscape("SELECT count(value) FROM 13")},
+		{"text/plain", "SELECT count(value) FROM 13"},
+	} {
+		w = serve(h, "POST", "/query", tt.contentType, tt.body)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"values":[[0,1]]`) {
+			t.Fatalf("post query error: %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	w = serve(h, "GET", "/debug/vars", "", "")
+	var vars map[string]map[string]int64
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("decode vars fail: %v", err)
+	}
+	if vars["wal"]["WriteOK"] != 1 || vars["cache"]["WriteOK"] != 1 {
+		t.Fatalf("vars error: %s", w.Body.String())
+	}
+
+	if w = serve(h, "GET", "/ping", "", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("ping error: %d", w.Code)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	checkError(t, "write method", serve(h, "GET", "/write", "", ""), http.StatusMethodNotAllowed)
+	checkError(t, "not found", serve(h, "GET", "/unknown", "", ""), http.StatusNotFound)
+	checkError(t, "bad json", serve(h, "POST", "/write", "", `{"12": [1, 2]}`), http.StatusBadRequest)
+	checkError(t, "bad key", serve(h, "POST", "/write", "", `{"a": [[1, 2]]}`), http.StatusBadRequest)
+	checkError(t, "bad value", serve(h, "POST", "/write", "", `{"1": [[1, 256]]}`), http.StatusBadRequest)
+	checkError(t, "bad precision", serve(h, "POST", "/write?precision=h", "", `{}`), http.StatusBadRequest)
+	checkError(t, "too large", serve(h, "POST", "/write", "", `{"1": [`+strings.Repeat("[1, 2],", 200)+`[1, 2]]}`), http.StatusRequestEntityTooLarge)
+	checkError(t, "missing query", serve(h, "GET", "/query", "", ""), http.StatusBadRequest)
+	checkError(t, "bad query", serve(h, "GET", "/query?q=SELECT", "", ""), http.StatusBadRequest)
+	checkError(t, "invalid field", serve(h, "GET", "/query?q=SELECT+foo+FROM+12", "", ""), http.StatusBadRequest)
+
+	// 读取数据出错时返回500
+	if w := serve(h, "POST", "/write", "", `{"12": [[1, 10], [2, 20]]}`); w.Code != http.StatusNoContent {
+		t.Fatalf("write error: %d %s", w.Code, w.Body.String())
+	}
+	if err := h.Engine.WriteSnapshot(); err != nil {
+		t.Fatalf("write snapshot fail: %v", err)
+	}
+	f, err := os.OpenFile(h.Engine.FileStore.Files()[0].Path(), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("open tsm file fail: %v", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 8), 8); err != nil {
+		t.Fatalf("corrupt tsm file fail: %v", err)
+	}
+	f.Close()
+	checkError(t, "read error", serve(h, "GET", "/query?q=SELECT+value+FROM+12", "", ""), http.StatusInternalServerError)
+}
+
+// 超时前没有开始写入时返回503，数据没有写入
+func TestHandler_WriteTimeout(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	checkError(t, "write timeout", serveContext(expiredContext(), h, "POST", "/write", "", `{"12": [[1, 10]]}`), http.StatusServiceUnavailable)
+	if n := h.Engine.Cache.Size(); n != 0 {
+		t.Fatalf("timed out write should not be stored: %d", n)
+	}
+}
+
+func TestHandler_QueryFormats(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	if w := serve(h, "POST", "/write?precision=s", "", `{"12": [[1, 10], [2, 20]], "13": [[1, 5]]}`); w.Code != http.StatusNoContent {
+		t.Fatalf("write error: %d %s", w.Code, w.Body.String())
+	}
+	q := "/query?chunk_size=1&q=" + url.QueryEscape("SELECT value FROM 12, 13")
+	tests := []struct {
+		target, accept string
+		contentType    string
+		except         string
+	}{
+		{q, "", "application/json",
+			`{"series":[{"name":"12","columns":["time","value"],"values":[[1000000000,10],[2000000000,20]]},` +
+				`{"name":"13","columns":["time","value"],"values":[[1000000000,5]]}]}` + "\n"},
+		{q, "text/csv;q=0.9, */*", "text/csv",
+			"name,time,value\n12,1000000000,10\n12,2000000000,20\n13,1000000000,5\n"},
+		{q + "&format=ndjson", "text/csv", "application/x-ndjson",
+			`{"name":"12","time":1000000000,"value":10}` + "\n" + `{"name":"12","time":2000000000,"value":20}` + "\n" +
+				`{"name":"13","time":1000000000,"value":5}` + "\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != tt.except {
+			t.Fatalf("%s: except %q, actual %d %q", tt.target, tt.except, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Fatalf("%s: content type error: %s", tt.target, ct)
+		}
+		if !w.Flushed {
+			t.Fatalf("%s: response not flushed", tt.target)
+		}
+	}
+
+	checkError(t, "bad format", serve(h, "GET", q+"&format=xml", "", ""), http.StatusBadRequest)
+	checkError(t, "bad chunk size", serve(h, "GET", "/query?chunk_size=0&q=SELECT+value+FROM+12", "", ""), http.StatusBadRequest)
+
+	// 还没有输出结果时超时返回503
+	checkError(t, "timeout", serveContext(expiredContext(), h, "GET", q, "", ""), http.StatusServiceUnavailable)
+
+	// 开始输出后不再受RequestTimeout限制
+	h.Config.RequestTimeout = 50 * time.Millisecond
+	w := &slowRecorder{ResponseRecorder: httptest.NewRecorder(), delay: 30 * time.Millisecond}
+	h.ServeHTTP(w, httptest.NewRequest("GET", q+"&format=csv", nil))
+	if except := tests[1].except; w.Code != http.StatusOK || w.Body.String() != except {
+		t.Fatalf("slow client: except %q, actual %d %q", except, w.Code, w.Body.String())
+	}
+	if e := w.Result().Trailer.Get(errorTrailer); e != "" {
+		t.Fatalf("slow client: unexpected error trailer: %s", e)
+	}
+}
+
+// 每次写出都较慢的客户端
+type slowRecorder struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (w *slowRecorder) Write(b []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseRecorder.Write(b)
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hooone/datacc/store/engine"
+)
+
+// Service 在存储引擎上提供HTTP服务
+type Service struct {
+	Config  Config
+	Handler *Handler
+
+	ln     net.Listener
+	server *http.Server
+}
+
+func NewService(c Config, e *engine.Engine) *Service {
+	return &Service{Config: c, Handler: NewHandler(c, e)}
+}
+
+// Open 开始监听并在后台处理请求
+func (s *Service) Open() error {
+	ln, err := net.Listen("tcp", s.Config.BindAddress)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+	// 写超时按每次写出设置，不使用http.Server的WriteTimeout，否则长时间的流式查询会被中断
+	s.server = &http.Server{
+		Handler:     s.Handler,
+		ReadTimeout: s.Config.ReadTimeout,
+	}
+	if s.Config.WriteTimeout > 0 {
+		ln = &deadlineListener{Listener: ln, timeout: s.Config.WriteTimeout}
+	}
+	go s.server.Serve(ln)
+	return nil
+}
+
+// Close 停止监听并关闭所有连接
+func (s *Service) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+// 实际监听的地址
+func (s *Service) Addr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// 接受的连接每次写出前都重新设置写超时
+type deadlineListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l *deadlineListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &deadlineConn{Conn: c, timeout: l.timeout}, nil
+}
+
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
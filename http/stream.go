@@ -0,0 +1,312 @@
+package http
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 查询结果的输出格式
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// 各格式的Content-Type
+var formatTypes = map[string]string{
+	FormatJSON:   "application/json",
+	FormatCSV:    "text/csv",
+	FormatNDJSON: "application/x-ndjson",
+}
+
+// 已发送部分结果后出错时，错误信息放在这个trailer中
+const errorTrailer = "X-Query-Error"
+
+// 按format参数或Accept头选择输出格式，都没有指定时为JSON
+func queryFormat(params url.Values, accept string) (string, error) {
+	if f := params.Get("format"); f != "" {
+		if _, ok := formatTypes[f]; !ok {
+			return "", ErrInvalidFormat(f)
+		}
+		return f, nil
+	}
+	for _, t := range strings.Split(accept, ",") {
+		if i := strings.IndexByte(t, ';'); i >= 0 {
+			t = t[:i]
+		}
+		switch strings.ToLower(strings.TrimSpace(t)) {
+		case "application/json":
+			return FormatJSON, nil
+		case "text/csv":
+			return FormatCSV, nil
+		case "application/x-ndjson", "application/ndjson":
+			return FormatNDJSON, nil
+		}
+	}
+	return FormatJSON, nil
+}
+
+// 按格式编码查询结果
+type encoder interface {
+	beginSeries(name string, columns []string) error
+	writeRow(row []interface{}) error
+	// 结束输出，err不为nil时在结果末尾附加错误
+	end(err error) error
+}
+
+// chunkWriter 把查询结果编码后分块写出，每chunkSize行刷新一次。
+// 第一次刷新前还没有发送响应头，出错时改为返回错误响应
+type chunkWriter struct {
+	w         http.ResponseWriter
+	format    string
+	enc       encoder
+	buf       bytes.Buffer
+	chunkSize int
+	rows      int
+	sent      bool
+	// 第一次发送响应头时调用
+	onSend func()
+}
+
+func newChunkWriter(w http.ResponseWriter, format string, chunkSize int) *chunkWriter {
+	cw := &chunkWriter{w: w, format: format, chunkSize: chunkSize}
+	switch format {
+	case FormatCSV:
+		cw.enc = &csvEncoder{w: csv.NewWriter(&cw.buf)}
+	case FormatNDJSON:
+		cw.enc = &ndjsonEncoder{w: &cw.buf}
+	default:
+		cw.enc = &jsonEncoder{w: &cw.buf}
+	}
+	return cw
+}
+
+func (cw *chunkWriter) BeginSeries(name string, columns []string) error {
+	return cw.enc.beginSeries(name, columns)
+}
+
+func (cw *chunkWriter) WriteRow(row []interface{}) error {
+	if err := cw.enc.writeRow(row); err != nil {
+		return err
+	}
+	if cw.rows++; cw.rows >= cw.chunkSize {
+		return cw.flush()
+	}
+	return nil
+}
+
+// 写出缓存的数据，第一次写出时发送响应头
+func (cw *chunkWriter) flush() error {
+	cw.rows = 0
+	if !cw.sent {
+		h := cw.w.Header()
+		h.Set("Content-Type", formatTypes[cw.format])
+		h.Set("Trailer", errorTrailer)
+		cw.w.WriteHeader(http.StatusOK)
+		cw.sent = true
+		if cw.onSend != nil {
+			cw.onSend()
+		}
+	}
+	_, err := cw.w.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return err
+}
+
+// Close 结束输出。还没有发送响应头时以code返回错误响应，否则把错误附加在结果末尾和trailer中
+func (cw *chunkWriter) Close(err error, code int) {
+	if err != nil && !cw.sent {
+		writeResponse(cw.w, errorResponse(code, err))
+		return
+	}
+	if cw.enc.end(err) == nil {
+		cw.flush()
+	}
+	if err != nil {
+		cw.w.Header().Set(errorTrailer, err.Error())
+	}
+}
+
+// 输出{"series": [{"name": ..., "columns": [...], "values": [...]}, ...]}，出错时附加"error"
+type jsonEncoder struct {
+	w       *bytes.Buffer
+	started bool
+	// 已输出的组数和当前组的行数
+	series, rows int
+}
+
+func (e *jsonEncoder) start() {
+	if !e.started {
+		e.w.WriteString(`{"series":[`)
+		e.started = true
+	}
+}
+
+func (e *jsonEncoder) beginSeries(name string, columns []string) error {
+	n, err := json.Marshal(name)
+	if err != nil {
+		return err
+	}
+	c, err := json.Marshal(columns)
+	if err != nil {
+		return err
+	}
+	e.start()
+	if e.series > 0 {
+		e.w.WriteString("]},")
+	}
+	e.series, e.rows = e.series+1, 0
+	fmt.Fprintf(e.w, `{"name":%s,"columns":%s,"values":[`, n, c)
+	return nil
+}
+
+func (e *jsonEncoder) writeRow(row []interface{}) error {
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if e.rows > 0 {
+		e.w.WriteByte(',')
+	}
+	e.rows++
+	e.w.Write(b)
+	return nil
+}
+
+func (e *jsonEncoder) end(err error) error {
+	e.start()
+	if e.series > 0 {
+		e.w.WriteString("]}")
+	}
+	e.w.WriteByte(']')
+	if err != nil {
+		b, _ := json.Marshal(err.Error())
+		e.w.WriteString(`,"error":`)
+		e.w.Write(b)
+	}
+	e.w.WriteString("}\n")
+	return nil
+}
+
+// 每行输出一个对象{"name": ..., 列名: 值, ...}，出错时最后一行为{"error": ...}
+type ndjsonEncoder struct {
+	w       *bytes.Buffer
+	name    []byte
+	columns [][]byte
+}
+
+func (e *ndjsonEncoder) beginSeries(name string, columns []string) error {
+	var err error
+	if e.name, err = json.Marshal(name); err != nil {
+		return err
+	}
+	e.columns = make([][]byte, len(columns))
+	for i, c := range columns {
+		if e.columns[i], err = json.Marshal(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) writeRow(row []interface{}) error {
+	values := make([][]byte, len(row))
+	for i, v := range row {
+		var err error
+		if values[i], err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	e.w.WriteString(`{"name":`)
+	e.w.Write(e.name)
+	for i, v := range values {
+		e.w.WriteByte(',')
+		e.w.Write(e.columns[i])
+		e.w.WriteByte(':')
+		e.w.Write(v)
+	}
+	e.w.WriteString("}\n")
+	return nil
+}
+
+func (e *ndjsonEncoder) end(err error) error {
+	if err != nil {
+		b, _ := json.Marshal(map[string]string{"error": err.Error()})
+		e.w.Write(append(b, '\n'))
+	}
+	return nil
+}
+
+// 第一列为组名，列名变化时重新输出表头。错误只放在trailer中
+type csvEncoder struct {
+	w       *csv.Writer
+	name    string
+	columns []string
+	record  []string
+}
+
+func (e *csvEncoder) beginSeries(name string, columns []string) error {
+	e.name = name
+	if e.record != nil && equalStrings(e.columns, columns) {
+		return nil
+	}
+	e.columns = columns
+	e.record = append([]string{"name"}, columns...)
+	if err := e.w.Write(e.record); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) writeRow(row []interface{}) error {
+	e.record = append(e.record[:0], e.name)
+	for _, v := range row {
+		e.record = append(e.record, formatValue(v))
+	}
+	if err := e.w.Write(e.record); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) end(err error) error {
+	return nil
+}
+
+// CSV中的值，nil为空
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
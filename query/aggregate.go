@@ -0,0 +1,339 @@
+package query
+
+import (
+	"math"
+
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+// 聚合结果中的一个点
+type Point struct {
+	// 窗口起点
+	Time  int64
+	Value float64
+	// 窗口内没有数据且未被填充
+	Nil bool
+}
+
+// 聚合参数
+type AggregateOptions struct {
+	// 聚合函数名: count、min、max、mean、sum、first、last、spread、stddev
+	Func string
+	// 时间窗口
+	Window Window
+	// 空窗口的填充方式
+	Fill      FillMode
+	FillValue float64
+}
+
+// 流式聚合器，按时间顺序逐个输入一个窗口内的数据
+type Aggregator interface {
+	Add(v coder.Value)
+	// 聚合结果，窗口内没有数据时返回false
+	Result() (float64, bool)
+	Reset()
+}
+
+// 可以直接合并block统计值的聚合器
+type statsAggregator interface {
+	AddStats(e *lsm.IndexEntry)
+}
+
+// 按名称新建聚合器
+func NewAggregator(name string) (Aggregator, error) {
+	switch name {
+	case "count":
+		return &countAggregator{}, nil
+	case "min":
+		return &minAggregator{}, nil
+	case "max":
+		return &maxAggregator{}, nil
+	case "mean":
+		return &meanAggregator{}, nil
+	case "sum":
+		return &sumAggregator{}, nil
+	case "first":
+		return &firstAggregator{}, nil
+	case "last":
+		return &lastAggregator{}, nil
+	case "spread":
+		return &spreadAggregator{}, nil
+	case "stddev":
+		return &stddevAggregator{}, nil
+	}
+	return nil, ErrUnknownFunction(name)
+}
+
+// Aggregate 读取升序游标中的所有数据，按时间窗口聚合并填充空窗口。
+// count、min、max、mean、sum、first、last和spread对不与其他数据重叠、在一个窗口内的block直接使用Index统计值
+func Aggregate(cur *Cursor, opt AggregateOptions) ([]Point, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	agg, err := NewAggregator(opt.Func)
+	if err != nil {
+		return nil, err
+	}
+
+	// 时间范围无界时以数据所在的窗口为界
+	min, max := cur.opt.Min, cur.opt.Max
+	w := opt.Window
+	if opt.Fill != FillNone && min != math.MinInt64 && max != math.MaxInt64 {
+		if n := w.Count(min, max); n > MaxWindows {
+			return nil, ErrTooManyWindows(n, MaxWindows)
+		}
+	}
+
+	var points []Point
+	// 输出从next开始到end之前的空窗口
+	next := int64(math.MinInt64)
+	if min != math.MinInt64 {
+		next = w.Start(min)
+	}
+	emptyTo := func(end int64) error {
+		if opt.Fill == FillNone || next == math.MinInt64 {
+			return nil
+		}
+		for ; next < end; next = w.Next(next) {
+			if len(points) >= MaxWindows {
+				return ErrTooManyWindows(int64(len(points))+1, MaxWindows)
+			}
+			points = append(points, Point{Time: windowTime(w, next, min), Nil: true})
+		}
+		return nil
+	}
+
+	// 当前窗口
+	start, end := int64(0), int64(0)
+	active := false
+	flush := func() {
+		if !active {
+			return
+		}
+		v, ok := agg.Result()
+		points = append(points, Point{Time: windowTime(w, start, min), Value: v, Nil: !ok})
+		agg.Reset()
+		next = end
+		active = false
+	}
+
+	// 进入t所在的窗口
+	enter := func(t int64) error {
+		if active && t < end {
+			return nil
+		}
+		flush()
+		start = w.Start(t)
+		end = w.Next(start)
+		if err := emptyTo(start); err != nil {
+			return err
+		}
+		active = true
+		return nil
+	}
+
+	// 在一个窗口内的block使用统计值
+	var statsErr error
+	if sa, ok := agg.(statsAggregator); ok {
+		cur.SetStatsHandler(func(e *lsm.IndexEntry) bool {
+			if w.Start(e.MinTime) != w.Start(e.MaxTime) {
+				return false
+			}
+			if statsErr = enter(e.MinTime); statsErr == nil {
+				sa.AddStats(e)
+			}
+			return true
+		})
+	}
+
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if statsErr != nil {
+			return nil, statsErr
+		}
+		if len(values) == 0 {
+			break
+		}
+		for _, v := range values {
+			if err := enter(v.UnixNano); err != nil {
+				return nil, err
+			}
+			agg.Add(v)
+		}
+	}
+	flush()
+
+	// 补齐最后的空窗口
+	if max != math.MaxInt64 {
+		if err := emptyTo(w.Next(w.Start(max))); err != nil {
+			return nil, err
+		}
+	}
+	return fill(points, opt.Fill, opt.FillValue), nil
+}
+
+// 窗口的输出时间。不分窗口时为查询的起始时间
+func windowTime(w Window, start, min int64) int64 {
+	if !w.grouped() {
+		return min
+	}
+	return start
+}
+
+type countAggregator struct {
+	n int64
+}
+
+func (a *countAggregator) Add(v coder.Value)          { a.n++ }
+func (a *countAggregator) AddStats(e *lsm.IndexEntry) { a.n += int64(e.Count) }
+func (a *countAggregator) Result() (float64, bool) {
+	return float64(a.n), true
+}
+func (a *countAggregator) Reset() { a.n = 0 }
+
+type minAggregator struct {
+	v  byte
+	ok bool
+}
+
+func (a *minAggregator) Add(v coder.Value) {
+	if !a.ok || v.Value < a.v {
+		a.v, a.ok = v.Value, true
+	}
+}
+func (a *minAggregator) AddStats(e *lsm.IndexEntry) {
+	a.Add(coder.NewValue(e.MinTime, e.MinValue))
+}
+func (a *minAggregator) Result() (float64, bool) { return float64(a.v), a.ok }
+func (a *minAggregator) Reset()                  { *a = minAggregator{} }
+
+type maxAggregator struct {
+	v  byte
+	ok bool
+}
+
+func (a *maxAggregator) Add(v coder.Value) {
+	if !a.ok || v.Value > a.v {
+		a.v, a.ok = v.Value, true
+	}
+}
+func (a *maxAggregator) AddStats(e *lsm.IndexEntry) {
+	a.Add(coder.NewValue(e.MinTime, e.MaxValue))
+}
+func (a *maxAggregator) Result() (float64, bool) { return float64(a.v), a.ok }
+func (a *maxAggregator) Reset()                  { *a = maxAggregator{} }
+
+type sumAggregator struct {
+	sum uint64
+	n   int64
+}
+
+func (a *sumAggregator) Add(v coder.Value) {
+	a.sum += uint64(v.Value)
+	a.n++
+}
+func (a *sumAggregator) AddStats(e *lsm.IndexEntry) {
+	a.sum += e.Sum
+	a.n += int64(e.Count)
+}
+func (a *sumAggregator) Result() (float64, bool) { return float64(a.sum), a.n > 0 }
+func (a *sumAggregator) Reset()                  { *a = sumAggregator{} }
+
+type meanAggregator struct {
+	sum uint64
+	n   int64
+}
+
+func (a *meanAggregator) Add(v coder.Value) {
+	a.sum += uint64(v.Value)
+	a.n++
+}
+func (a *meanAggregator) AddStats(e *lsm.IndexEntry) {
+	a.sum += e.Sum
+	a.n += int64(e.Count)
+}
+func (a *meanAggregator) Result() (float64, bool) {
+	if a.n == 0 {
+		return 0, false
+	}
+	return float64(a.sum) / float64(a.n), true
+}
+func (a *meanAggregator) Reset() { *a = meanAggregator{} }
+
+type firstAggregator struct {
+	v  byte
+	ok bool
+}
+
+func (a *firstAggregator) Add(v coder.Value) {
+	if !a.ok {
+		a.v, a.ok = v.Value, true
+	}
+}
+func (a *firstAggregator) AddStats(e *lsm.IndexEntry) {
+	a.Add(coder.NewValue(e.MinTime, e.FirstValue))
+}
+func (a *firstAggregator) Result() (float64, bool) { return float64(a.v), a.ok }
+func (a *firstAggregator) Reset()                  { *a = firstAggregator{} }
+
+type lastAggregator struct {
+	v  byte
+	ok bool
+}
+
+func (a *lastAggregator) Add(v coder.Value) { a.v, a.ok = v.Value, true }
+func (a *lastAggregator) AddStats(e *lsm.IndexEntry) {
+	a.Add(coder.NewValue(e.MaxTime, e.LastValue))
+}
+func (a *lastAggregator) Result() (float64, bool) { return float64(a.v), a.ok }
+func (a *lastAggregator) Reset()                  { *a = lastAggregator{} }
+
+type spreadAggregator struct {
+	min, max byte
+	ok       bool
+}
+
+func (a *spreadAggregator) Add(v coder.Value) {
+	if !a.ok {
+		a.min, a.max, a.ok = v.Value, v.Value, true
+		return
+	}
+	if v.Value < a.min {
+		a.min = v.Value
+	}
+	if v.Value > a.max {
+		a.max = v.Value
+	}
+}
+func (a *spreadAggregator) AddStats(e *lsm.IndexEntry) {
+	a.Add(coder.NewValue(e.MinTime, e.MinValue))
+	a.Add(coder.NewValue(e.MaxTime, e.MaxValue))
+}
+func (a *spreadAggregator) Result() (float64, bool) { return float64(a.max) - float64(a.min), a.ok }
+func (a *spreadAggregator) Reset()                  { *a = spreadAggregator{} }
+
+// 样本标准差，使用Welford算法累计，少于两个点时为空
+type stddevAggregator struct {
+	n    int64
+	mean float64
+	m2   float64
+}
+
+func (a *stddevAggregator) Add(v coder.Value) {
+	a.n++
+	x := float64(v.Value)
+	d := x - a.mean
+	a.mean += d / float64(a.n)
+	a.m2 += d * (x - a.mean)
+}
+func (a *stddevAggregator) Result() (float64, bool) {
+	if a.n < 2 {
+		return 0, false
+	}
+	return math.Sqrt(a.m2 / float64(a.n-1)), true
+}
+func (a *stddevAggregator) Reset() { *a = stddevAggregator{} }
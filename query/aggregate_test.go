@@ -0,0 +1,153 @@
+package query
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+func newTestCache(t *testing.T, key uint32, ts []int64, values []byte) *cache.Cache {
+	c := cache.NewCache(0)
+	if err := c.Write(key, ts, values); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	return c
+}
+
+// 检查聚合结果，nil表示空值
+func checkPoints(t *testing.T, name string, actual []Point, times []int64, except []interface{}) {
+	if len(actual) != len(except) {
+		t.Fatalf("%s: points count error: except %d, actual %d: %v", name, len(except), len(actual), actual)
+	}
+	for i, e := range except {
+		p := actual[i]
+		if p.Time != times[i] {
+			t.Fatalf("%s: time error. index %d: except %d, actual %d", name, i, times[i], p.Time)
+		}
+		if e == nil {
+			if !p.Nil {
+				t.Fatalf("%s: expected nil at index %d, actual %v", name, i, p.Value)
+			}
+			continue
+		}
+		if p.Nil || math.Abs(p.Value-e.(float64)) > 1e-9 {
+			t.Fatalf("%s: value error. index %d: except %v, actual %+v", name, i, e, p)
+		}
+	}
+}
+
+// 各聚合函数和填充方式
+func TestAggregate(t *testing.T) {
+	c := newTestCache(t, 1, []int64{1, 2, 3, 25, 47}, []byte{1, 2, 6, 10, 4})
+	times := []int64{0, 10, 20, 30, 40, 50}
+
+	tests := []struct {
+		fn     string
+		fill   FillMode
+		except []interface{}
+	}{
+		{"count", FillNull, []interface{}{3.0, nil, 1.0, nil, 1.0, nil}},
+		{"min", FillNull, []interface{}{1.0, nil, 10.0, nil, 4.0, nil}},
+		{"max", FillNull, []interface{}{6.0, nil, 10.0, nil, 4.0, nil}},
+		{"sum", FillNull, []interface{}{9.0, nil, 10.0, nil, 4.0, nil}},
+		{"first", FillNull, []interface{}{1.0, nil, 10.0, nil, 4.0, nil}},
+		{"last", FillNull, []interface{}{6.0, nil, 10.0, nil, 4.0, nil}},
+		{"spread", FillNull, []interface{}{5.0, nil, 0.0, nil, 0.0, nil}},
+		{"stddev", FillNull, []interface{}{math.Sqrt(7), nil, nil, nil, nil, nil}},
+		{"mean", FillPrevious, []interface{}{3.0, 3.0, 10.0, 10.0, 4.0, 4.0}},
+		{"mean", FillLinear, []interface{}{3.0, 6.5, 10.0, 7.0, 4.0, nil}},
+		{"mean", FillConstant, []interface{}{3.0, -1.0, 10.0, -1.0, 4.0, -1.0}},
+	}
+	for _, tt := range tests {
+		cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 59, Ascending: true, BatchSize: 2})
+		points, err := Aggregate(cur, AggregateOptions{Func: tt.fn, Window: Window{Interval: 10}, Fill: tt.fill, FillValue: -1})
+		if err != nil {
+			t.Fatalf("aggregate %s fail: %v", tt.fn, err)
+		}
+		checkPoints(t, tt.fn+" fill "+tt.fill.String(), points, times, tt.except)
+	}
+
+	// 不填充时只输出有数据的窗口
+	cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 59, Ascending: true})
+	points, err := Aggregate(cur, AggregateOptions{Func: "mean", Window: Window{Interval: 10}})
+	if err != nil {
+		t.Fatalf("aggregate fail: %v", err)
+	}
+	checkPoints(t, "fill none", points, []int64{0, 20, 40}, []interface{}{3.0, 10.0, 4.0})
+
+	// 窗口偏移
+	cur = NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 59, Ascending: true})
+	points, err = Aggregate(cur, AggregateOptions{Func: "count", Window: Window{Interval: 20, Offset: 5}})
+	if err != nil {
+		t.Fatalf("aggregate fail: %v", err)
+	}
+	checkPoints(t, "offset", points, []int64{-15, 25, 45}, []interface{}{3.0, 1.0, 1.0})
+
+	// 不分窗口
+	cur = NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 59, Ascending: true})
+	points, err = Aggregate(cur, AggregateOptions{Func: "sum", Fill: FillNull})
+	if err != nil {
+		t.Fatalf("aggregate fail: %v", err)
+	}
+	checkPoints(t, "no window", points, []int64{0}, []interface{}{23.0})
+
+	if _, err := Aggregate(cur, AggregateOptions{Func: "median"}); err == nil {
+		t.Fatalf("expected unknown function error")
+	}
+}
+
+// 不与其他数据重叠的block使用统计值，被新文件覆盖的block解码后合并
+func TestAggregate_Stats(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+	ts, values := make([]int64, 10), make([]byte, 10)
+	for i := range ts {
+		ts[i], values[i] = int64(i), byte(i+1)
+	}
+	s.writeFile(t, 1, ts, values)
+	for i := range ts {
+		ts[i] += 20
+	}
+	s.writeFile(t, 1, ts, values)
+	s.writeFile(t, 1, []int64{25}, []byte{100})
+	c := newTestCache(t, 1, []int64{40}, []byte{7})
+
+	// 只有第一个文件的block没有重叠
+	opt := CursorOptions{Key: 1, Min: 0, Max: 49, Ascending: true}
+	cur := NewCursor(c, s.fs, opt)
+	var entries []int64
+	cur.SetStatsHandler(func(e *lsm.IndexEntry) bool {
+		entries = append(entries, e.MinTime)
+		return true
+	})
+	if all := readCursor(t, cur); len(all) != 11 {
+		t.Fatalf("values read error: %d", len(all))
+	}
+	if len(entries) != 1 || entries[0] != 0 {
+		t.Fatalf("stats blocks error: %v", entries)
+	}
+
+	times := []int64{0, 10, 20, 30, 40}
+	tests := []struct {
+		fn     string
+		except []interface{}
+	}{
+		{"count", []interface{}{10.0, nil, 10.0, nil, 1.0}},
+		{"sum", []interface{}{55.0, nil, 149.0, nil, 7.0}},
+		{"mean", []interface{}{5.5, nil, 14.9, nil, 7.0}},
+		{"min", []interface{}{1.0, nil, 1.0, nil, 7.0}},
+		{"max", []interface{}{10.0, nil, 100.0, nil, 7.0}},
+		{"first", []interface{}{1.0, nil, 1.0, nil, 7.0}},
+		{"last", []interface{}{10.0, nil, 10.0, nil, 7.0}},
+		{"spread", []interface{}{9.0, nil, 99.0, nil, 0.0}},
+	}
+	for _, tt := range tests {
+		points, err := Aggregate(NewCursor(c, s.fs, opt), AggregateOptions{Func: tt.fn, Window: Window{Interval: 10}, Fill: FillNull})
+		if err != nil {
+			t.Fatalf("%s: aggregate fail: %v", tt.fn, err)
+		}
+		checkPoints(t, tt.fn, points, times, tt.except)
+	}
+}
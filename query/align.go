@@ -0,0 +1,205 @@
+package query
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 对齐到时间网格时的取值方式
+type AlignMethod int
+
+const (
+	// 使用网格时间之前最近的值
+	AlignPrevious AlignMethod = iota
+	// 使用前后两个点线性插值，最后一个点之后使用其值
+	AlignLinear
+)
+
+// 对齐参数
+type AlignOptions struct {
+	// 时间网格，不分窗口时使用所有key的时间戳的并集
+	Grid   Window
+	Method AlignMethod
+}
+
+// 宽表中的一列
+type Column struct {
+	Name   string
+	Values []float64
+	// 为false时该行没有值
+	Valid []bool
+}
+
+// Table 多个key对齐后的宽表
+type Table struct {
+	Times   []int64
+	Columns []*Column
+}
+
+// Align 把多个升序游标的数据对齐到同一个时间网格上，每个游标一列，列名为key
+func Align(curs []*Cursor, opt AlignOptions) (*Table, error) {
+	series := make([]coder.Values, len(curs))
+	min, max := int64(math.MaxInt64), int64(math.MinInt64)
+	for i, cur := range curs {
+		if !cur.opt.Ascending {
+			return nil, ErrDescendingCursor
+		}
+		for {
+			values, err := cur.Next()
+			if err != nil {
+				return nil, err
+			}
+			if len(values) == 0 {
+				break
+			}
+			series[i] = append(series[i], values...)
+		}
+		// 网格范围: 查询范围，无界时以数据为界
+		lo, hi := cur.opt.Min, cur.opt.Max
+		if s := series[i]; len(s) > 0 {
+			if lo == math.MinInt64 {
+				lo = s[0].UnixNano
+			}
+			if hi == math.MaxInt64 {
+				hi = s[len(s)-1].UnixNano
+			}
+		}
+		if lo < min {
+			min = lo
+		}
+		if hi > max {
+			max = hi
+		}
+	}
+
+	t := &Table{}
+	if opt.Grid.grouped() {
+		if min > max {
+			return t, nil
+		}
+		if n := opt.Grid.Count(min, max); n > MaxWindows {
+			return nil, ErrTooManyWindows(n, MaxWindows)
+		}
+		g := opt.Grid.Start(min)
+		if g < min {
+			g = opt.Grid.Next(g)
+		}
+		for ; g <= max; g = opt.Grid.Next(g) {
+			t.Times = append(t.Times, g)
+			if g == math.MaxInt64 {
+				break
+			}
+		}
+	} else {
+		t.Times = unionTimes(series)
+	}
+
+	for i, s := range series {
+		col := &Column{Name: strconv.FormatUint(uint64(curs[i].opt.Key), 10)}
+		col.Values, col.Valid = sampleSeries(s, t.Times, opt.Method)
+		t.Columns = append(t.Columns, col)
+	}
+	return t, nil
+}
+
+// 所有序列时间戳的并集
+func unionTimes(series []coder.Values) []int64 {
+	var times []int64
+	for _, s := range series {
+		for _, v := range s {
+			times = append(times, v.UnixNano)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	out := times[:0]
+	for i, t := range times {
+		if i == 0 || t != times[i-1] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// 在升序的times上对序列取值
+func sampleSeries(s coder.Values, times []int64, method AlignMethod) ([]float64, []bool) {
+	values := make([]float64, len(times))
+	valid := make([]bool, len(times))
+	j := 0
+	for i, t := range times {
+		// s[j-1]为时间不晚于t的最后一个点
+		for j < len(s) && s[j].UnixNano <= t {
+			j++
+		}
+		if j == 0 {
+			continue
+		}
+		prev := s[j-1]
+		values[i], valid[i] = float64(prev.Value), true
+		if method == AlignLinear && prev.UnixNano != t && j < len(s) {
+			next := s[j]
+			ratio := float64(t-prev.UnixNano) / float64(next.UnixNano-prev.UnixNano)
+			values[i] = float64(prev.Value) + (float64(next.Value)-float64(prev.Value))*ratio
+		}
+	}
+	return values, valid
+}
+
+// 按名称查找列
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Eval 对每一行计算表达式，结果作为新列追加，列名为表达式
+func (t *Table) Eval(e Expr) (*Column, error) {
+	cols := make(map[string]*Column)
+	for _, name := range ExprColumns(e) {
+		c := t.Column(name)
+		if c == nil {
+			return nil, ErrUnknownColumn(name)
+		}
+		cols[name] = c
+	}
+
+	col := &Column{Name: e.String(), Values: make([]float64, len(t.Times)), Valid: make([]bool, len(t.Times))}
+	for i := range t.Times {
+		col.Values[i], col.Valid[i] = EvalExpr(e, func(name string) (float64, bool) {
+			c := cols[name]
+			return c.Values[i], c.Valid[i]
+		})
+	}
+	t.Columns = append(t.Columns, col)
+	return col, nil
+}
+
+// Where 只保留表达式为真的行
+func (t *Table) Where(e Expr) (*Table, error) {
+	cond, err := t.Eval(e)
+	if err != nil {
+		return nil, err
+	}
+	t.Columns = t.Columns[:len(t.Columns)-1]
+
+	out := &Table{}
+	for _, c := range t.Columns {
+		out.Columns = append(out.Columns, &Column{Name: c.Name})
+	}
+	for i, time := range t.Times {
+		if !cond.Valid[i] || cond.Values[i] == 0 {
+			continue
+		}
+		out.Times = append(out.Times, time)
+		for j, c := range t.Columns {
+			out.Columns[j].Values = append(out.Columns[j].Values, c.Values[i])
+			out.Columns[j].Valid = append(out.Columns[j].Valid, c.Valid[i])
+		}
+	}
+	return out, nil
+}
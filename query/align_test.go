@@ -0,0 +1,74 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hooone/datacc/store/cache"
+)
+
+func TestAlign(t *testing.T) {
+	c := cache.NewCache(0)
+	if err := c.Write(12, []int64{0, 10, 20, 30}, []byte{10, 20, 30, 40}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if err := c.Write(13, []int64{5, 25}, []byte{1, 3}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	cursors := func() []*Cursor {
+		return []*Cursor{
+			NewCursor(c, nil, CursorOptions{Key: 12, Min: 0, Max: 30, Ascending: true}),
+			NewCursor(c, nil, CursorOptions{Key: 13, Min: 0, Max: 30, Ascending: true}),
+		}
+	}
+
+	// 时间戳的并集，取之前最近的值
+	table, err := Align(cursors(), AlignOptions{})
+	if err != nil {
+		t.Fatalf("align fail: %v", err)
+	}
+	if except := []int64{0, 5, 10, 20, 25, 30}; !reflect.DeepEqual(table.Times, except) {
+		t.Fatalf("times error: %v", table.Times)
+	}
+	if except := []bool{false, true, true, true, true, true}; !reflect.DeepEqual(table.Column("13").Valid, except) {
+		t.Fatalf("valid error: %v", table.Column("13").Valid)
+	}
+	if except := []float64{0, 1, 1, 1, 3, 3}; !reflect.DeepEqual(table.Column("13").Values, except) {
+		t.Fatalf("values error: %v", table.Column("13").Values)
+	}
+
+	// 固定网格，线性插值
+	table, err = Align(cursors(), AlignOptions{Grid: Window{Interval: 10}, Method: AlignLinear})
+	if err != nil {
+		t.Fatalf("align fail: %v", err)
+	}
+	if except := []float64{0, 1.5, 2.5, 3}; !reflect.DeepEqual(table.Column("13").Values, except) {
+		t.Fatalf("linear values error: %v", table.Column("13").Values)
+	}
+
+	// 表达式和过滤
+	e, err := ParseExpr("$12 - $13")
+	if err != nil {
+		t.Fatalf("parse fail: %v", err)
+	}
+	col, err := table.Eval(e)
+	if err != nil {
+		t.Fatalf("eval fail: %v", err)
+	}
+	if except := []float64{0, 18.5, 27.5, 37}; !reflect.DeepEqual(col.Values, except) || col.Valid[0] {
+		t.Fatalf("eval values error: %v %v", col.Values, col.Valid)
+	}
+	e, _ = ParseExpr("$13 >= 2")
+	filtered, err := table.Where(e)
+	if err != nil {
+		t.Fatalf("where fail: %v", err)
+	}
+	if except := []int64{20, 30}; !reflect.DeepEqual(filtered.Times, except) || len(filtered.Columns) != 3 {
+		t.Fatalf("where error: %v", filtered.Times)
+	}
+
+	e, _ = ParseExpr("$14 + 1")
+	if _, err := table.Eval(e); err == nil {
+		t.Fatalf("expected unknown column error")
+	}
+}
@@ -0,0 +1,342 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 表达式
+type Expr interface {
+	String() string
+	expr()
+}
+
+// 二元运算
+type BinaryExpr struct {
+	Op  Token
+	LHS Expr
+	RHS Expr
+}
+
+// 一元运算: -x、NOT x
+type UnaryExpr struct {
+	Op Token
+	X  Expr
+}
+
+// 括号
+type ParenExpr struct {
+	Expr Expr
+}
+
+// 数字。Raw为原始文本，作为纳秒时间戳时按整数解析，避免float64的精度损失
+type NumberLiteral struct {
+	Val float64
+	Raw string
+}
+
+// 列引用，$12引用key为12的列
+type ColumnRef struct {
+	Name string
+}
+
+// 变量引用: value为数据的值，time为时间戳
+type VarRef struct {
+	Name string
+}
+
+// 函数调用: mean(value)、now()
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+// 时间长度: 1h
+type DurationLiteral struct {
+	Val time.Duration
+}
+
+// 字符串，用于RFC3339格式的时间
+type StringLiteral struct {
+	Val string
+}
+
+func (*BinaryExpr) expr()      {}
+func (*UnaryExpr) expr()       {}
+func (*ParenExpr) expr()       {}
+func (*NumberLiteral) expr()   {}
+func (*ColumnRef) expr()       {}
+func (*VarRef) expr()          {}
+func (*Call) expr()            {}
+func (*DurationLiteral) expr() {}
+func (*StringLiteral) expr()   {}
+
+func (e *BinaryExpr) String() string {
+	return e.LHS.String() + " " + e.Op.String() + " " + e.RHS.String()
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == NOT {
+		return "NOT " + e.X.String()
+	}
+	return e.Op.String() + e.X.String()
+}
+
+func (e *ParenExpr) String() string { return "(" + e.Expr.String() + ")" }
+
+func (e *NumberLiteral) String() string {
+	if e.Raw != "" {
+		return e.Raw
+	}
+	return strconv.FormatFloat(e.Val, 'f', -1, 64)
+}
+
+func (e *ColumnRef) String() string { return "$" + e.Name }
+
+func (e *VarRef) String() string { return e.Name }
+
+func (e *Call) String() string {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e *DurationLiteral) String() string { return formatDuration(e.Val) }
+
+func (e *StringLiteral) String() string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(e.Val) + "'"
+}
+
+// 语句
+type Statement interface {
+	String() string
+	stmt()
+}
+
+// 查询的输出字段
+type Field struct {
+	Expr  Expr
+	Alias string
+}
+
+// 输出列名: 别名、函数名、变量名或表达式原文
+func (f *Field) Name() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	switch e := f.Expr.(type) {
+	case *Call:
+		return e.Name
+	case *VarRef:
+		return e.Name
+	}
+	return f.Expr.String()
+}
+
+func (f *Field) String() string {
+	if f.Alias != "" {
+		return f.Expr.String() + " AS " + f.Alias
+	}
+	return f.Expr.String()
+}
+
+// SELECT语句
+type SelectStatement struct {
+	Fields []*Field
+	// FROM中的key
+	Sources []uint32
+	// WHERE条件，可为nil
+	Condition Expr
+	// GROUP BY time(Interval, Offset)，Interval为0时不分窗口
+	Interval time.Duration
+	Offset   time.Duration
+	// GROUP BY calendar(day|week|month)
+	Calendar Calendar
+	// GROUP BY shift('06:00', '14:00', '22:00')
+	Shifts []time.Duration
+	// TZ('Asia/Shanghai')，日历和班次窗口的时区
+	Location *time.Location
+	// FILL(...)
+	Fill      FillMode
+	FillValue float64
+	// 每个key最多输出的行数，0为不限
+	Limit int
+}
+
+// EXPLAIN语句，只输出读取计划，不读取数据
+type ExplainStatement struct {
+	Statement *SelectStatement
+}
+
+// 按GROUP BY和TZ划分的时间窗口
+func (s *SelectStatement) Window() Window {
+	return Window{
+		Interval: int64(s.Interval),
+		Offset:   int64(s.Offset),
+		Calendar: s.Calendar,
+		Shifts:   s.Shifts,
+		Location: s.Location,
+	}
+}
+
+func (*SelectStatement) stmt()  {}
+func (*ExplainStatement) stmt() {}
+
+func (s *SelectStatement) String() string {
+	var buf strings.Builder
+	buf.WriteString("SELECT ")
+	for i, f := range s.Fields {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(f.String())
+	}
+	buf.WriteString(" FROM ")
+	for i, key := range s.Sources {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(strconv.FormatUint(uint64(key), 10))
+	}
+	if s.Condition != nil {
+		buf.WriteString(" WHERE " + s.Condition.String())
+	}
+	switch {
+	case len(s.Shifts) > 0:
+		shifts := make([]string, len(s.Shifts))
+		for i, shift := range s.Shifts {
+			shifts[i] = "'" + formatClock(shift) + "'"
+		}
+		buf.WriteString(" GROUP BY shift(" + strings.Join(shifts, ", ") + ")")
+	case s.Calendar != CalendarNone:
+		buf.WriteString(" GROUP BY calendar(" + s.Calendar.String() + ")")
+	case s.Interval > 0:
+		buf.WriteString(" GROUP BY time(" + formatDuration(s.Interval))
+		if s.Offset != 0 {
+			buf.WriteString(", " + formatDuration(s.Offset))
+		}
+		buf.WriteString(")")
+	}
+	switch s.Fill {
+	case FillNone:
+	case FillConstant:
+		buf.WriteString(" FILL(" + strconv.FormatFloat(s.FillValue, 'f', -1, 64) + ")")
+	default:
+		buf.WriteString(" FILL(" + s.Fill.String() + ")")
+	}
+	if s.Limit > 0 {
+		buf.WriteString(" LIMIT " + strconv.Itoa(s.Limit))
+	}
+	if s.Location != nil {
+		buf.WriteString(" TZ('" + s.Location.String() + "')")
+	}
+	return buf.String()
+}
+
+func (s *ExplainStatement) String() string { return "EXPLAIN " + s.Statement.String() }
+
+// 时间长度的单位，从大到小
+var durationUnits = []struct {
+	unit string
+	d    time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+	{"u", time.Microsecond},
+	{"ns", time.Nanosecond},
+}
+
+// 用能整除的最大单位输出时间长度
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	for _, u := range durationUnits {
+		if d%u.d == 0 {
+			return strconv.FormatInt(int64(d/u.d), 10) + u.unit
+		}
+	}
+	return strconv.FormatInt(int64(d), 10) + "ns"
+}
+
+// 距零点的时长输出为15:04或15:04:05
+func formatClock(d time.Duration) string {
+	t := time.Unix(0, int64(d)).UTC()
+	if t.Second() != 0 || t.Nanosecond() != 0 {
+		return t.Format("15:04:05.999999999")
+	}
+	return t.Format("15:04")
+}
+
+// 解析15:04或15:04:05格式的时刻，返回距零点的时长
+func parseClock(s string) (time.Duration, bool) {
+	for _, layout := range []string{"15:04", "15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)), true
+		}
+	}
+	return 0, false
+}
+
+// 解析带单位的时间长度: 10s、1h、2d
+func parseDuration(lit string) (time.Duration, bool) {
+	i := 0
+	for i < len(lit) && isDigit(rune(lit[i])) {
+		i++
+	}
+	n, err := strconv.ParseInt(lit[:i], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := lit[i:]
+	if unit == "us" {
+		unit = "u"
+	}
+	for _, u := range durationUnits {
+		if u.unit == unit {
+			return time.Duration(n) * u.d, true
+		}
+	}
+	return 0, false
+}
+
+// 表达式中引用的所有列名，按出现顺序去重
+func ExprColumns(e Expr) []string {
+	var names []string
+	seen := make(map[string]bool)
+	walkExpr(e, func(e Expr) {
+		if ref, ok := e.(*ColumnRef); ok && !seen[ref.Name] {
+			seen[ref.Name] = true
+			names = append(names, ref.Name)
+		}
+	})
+	return names
+}
+
+// 深度优先遍历表达式
+func walkExpr(e Expr, fn func(Expr)) {
+	if e == nil {
+		return
+	}
+	fn(e)
+	switch e := e.(type) {
+	case *BinaryExpr:
+		walkExpr(e.LHS, fn)
+		walkExpr(e.RHS, fn)
+	case *UnaryExpr:
+		walkExpr(e.X, fn)
+	case *ParenExpr:
+		walkExpr(e.Expr, fn)
+	case *Call:
+		for _, arg := range e.Args {
+			walkExpr(arg, fn)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package query
+
+// 一个窗口内每一位为1的点数
+type BitWindow struct {
+	// 窗口起点
+	Time int64
+	// 第i位为1的点数
+	Ones [8]int64
+	// 总点数
+	Total int64
+}
+
+// BitCounts 统计每个窗口内每一位为1的点数，只输出有数据的窗口
+func BitCounts(cur *Cursor, w Window) ([]BitWindow, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	if cur.opt.ExtractBit {
+		return nil, ErrExtractBit
+	}
+
+	var out []BitWindow
+	var end int64
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return out, nil
+		}
+		for _, v := range values {
+			if len(out) == 0 || v.UnixNano >= end {
+				start := w.Start(v.UnixNano)
+				end = w.Next(start)
+				out = append(out, BitWindow{Time: windowTime(w, start, cur.opt.Min)})
+			}
+			bw := &out[len(out)-1]
+			for i := uint(0); i < 8; i++ {
+				bw.Ones[i] += int64((v.Value >> i) & 1)
+			}
+			bw.Total++
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractBit(t *testing.T) {
+	c := newTestCache(t, 100, []int64{10, 20, 30, 40}, []byte{0x01, 0x0B, 0x0A, 0x08})
+	bitCursor := func(bit uint8) *Cursor {
+		return NewCursor(c, nil, CursorOptions{Key: 100, Min: 0, Max: 49, Ascending: true, ExtractBit: true, Bit: bit})
+	}
+
+	changes, err := StateChanges(bitCursor(1))
+	if err != nil {
+		t.Fatalf("state changes fail: %v", err)
+	}
+	if except := []StateChange{{20, 0, 1}, {40, 1, 0}}; !reflect.DeepEqual(changes, except) {
+		t.Fatalf("state changes error: except %v, actual %v", except, changes)
+	}
+
+	windows, err := TimeInState(bitCursor(0), Window{})
+	if err != nil {
+		t.Fatalf("time in state fail: %v", err)
+	}
+	if except := []WindowStates{{Time: 0, States: []StateDuration{{0, 20}, {1, 20}}}}; !reflect.DeepEqual(windows, except) {
+		t.Fatalf("time in state error: except %v, actual %v", except, windows)
+	}
+
+	counts, err := BitCounts(NewCursor(c, nil, CursorOptions{Key: 100, Min: 0, Max: 49, Ascending: true}), Window{Interval: 100})
+	if err != nil {
+		t.Fatalf("bit counts fail: %v", err)
+	}
+	if except := []BitWindow{{Time: 0, Ones: [8]int64{2, 2, 0, 3}, Total: 4}}; !reflect.DeepEqual(counts, except) {
+		t.Fatalf("bit counts error: except %v, actual %v", except, counts)
+	}
+}
@@ -0,0 +1,82 @@
+package query
+
+import "time"
+
+// 计数器函数参数
+type CounterOptions struct {
+	// 函数名: difference、non_negative_difference、derivative、rate
+	Func string
+	// derivative和rate的时间单位，默认为1秒
+	Unit time.Duration
+	// 按8位计数器处理回绕: 值变小时视为从255回绕到0
+	Wrap bool
+	// 回绕模式下，回绕后的增量超过该值时视为计数器复位，增量为复位后的值。为0时不判断复位
+	ResetThreshold int64
+}
+
+// Counter 计算相邻两点的差值或变化率，结果输出在后一个点的时间上
+func Counter(cur *Cursor, opt CounterOptions) ([]Point, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	var rate, nonNegative bool
+	switch opt.Func {
+	case "difference":
+	case "non_negative_difference":
+		nonNegative = true
+	case "derivative":
+		rate = true
+	case "rate":
+		rate, nonNegative = true, true
+	default:
+		return nil, ErrUnknownFunction(opt.Func)
+	}
+	unit := opt.Unit
+	if unit <= 0 {
+		unit = time.Second
+	}
+
+	var points []Point
+	var prev byte
+	var prevTime int64
+	first := true
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return points, nil
+		}
+		for _, v := range values {
+			if first {
+				prev, prevTime, first = v.Value, v.UnixNano, false
+				continue
+			}
+			d := counterDelta(prev, v.Value, opt.Wrap, opt.ResetThreshold)
+			value := float64(d)
+			if rate {
+				value /= float64(v.UnixNano-prevTime) / float64(unit)
+			}
+			if !nonNegative || d >= 0 {
+				points = append(points, Point{Time: v.UnixNano, Value: value})
+			}
+			prev, prevTime = v.Value, v.UnixNano
+		}
+	}
+}
+
+// 相邻两个计数值的增量
+func counterDelta(prev, cur byte, wrap bool, resetThreshold int64) int64 {
+	d := int64(cur) - int64(prev)
+	if !wrap || d >= 0 {
+		return d
+	}
+	// 从255回绕到0
+	d += 256
+	if resetThreshold > 0 && d > resetThreshold {
+		// 增量过大，视为计数器从0重新开始
+		return int64(cur)
+	}
+	return d
+}
@@ -0,0 +1,36 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	s := int64(time.Second)
+	c := newTestCache(t, 1, []int64{0, s, 2 * s, 4 * s, 5 * s}, []byte{250, 254, 3, 1, 10})
+	times := []int64{s, 2 * s, 4 * s, 5 * s}
+
+	tests := []struct {
+		opt    CounterOptions
+		times  []int64
+		except []interface{}
+	}{
+		{CounterOptions{Func: "difference"}, times, []interface{}{4.0, -251.0, -2.0, 9.0}},
+		{CounterOptions{Func: "non_negative_difference"}, []int64{s, 5 * s}, []interface{}{4.0, 9.0}},
+		// 254->3回绕，增量5；3->1增量254
+		{CounterOptions{Func: "difference", Wrap: true}, times, []interface{}{4.0, 5.0, 254.0, 9.0}},
+		// 增量超过阈值视为复位
+		{CounterOptions{Func: "difference", Wrap: true, ResetThreshold: 100}, times, []interface{}{4.0, 5.0, 1.0, 9.0}},
+		{CounterOptions{Func: "derivative", Wrap: true, ResetThreshold: 100}, times, []interface{}{4.0, 5.0, 0.5, 9.0}},
+		{CounterOptions{Func: "derivative", Unit: time.Minute}, times, []interface{}{240.0, -15060.0, -60.0, 540.0}},
+		{CounterOptions{Func: "rate"}, []int64{s, 5 * s}, []interface{}{4.0, 9.0}},
+	}
+	for _, tt := range tests {
+		cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 10 * s, Ascending: true})
+		points, err := Counter(cur, tt.opt)
+		if err != nil {
+			t.Fatalf("%s fail: %v", tt.opt.Func, err)
+		}
+		checkPoints(t, tt.opt.Func, points, tt.times, tt.except)
+	}
+}
@@ -0,0 +1,375 @@
+package query
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+// 默认每批返回的数据量
+const DefaultBatchSize = 1000
+
+// 游标参数
+type CursorOptions struct {
+	// 读取的key和时间范围[Min, Max]
+	Key      uint32
+	Min, Max int64
+	// 按时间升序或降序返回
+	Ascending bool
+	// 每批返回的数据量
+	BatchSize int
+	// 只取值的第Bit位(0~7)，作为0/1的布尔序列返回
+	ExtractBit bool
+	Bit        uint8
+	// 只返回满足条件的数据，条件中用value引用数据的值。为nil时不过滤
+	Condition Expr
+	// 取消后Next停止读取并返回ctx.Err()。为nil时不检查
+	Context context.Context
+}
+
+// Cursor 合并Cache和TSM文件中一个key在时间范围内的数据，分批按时间顺序返回。
+// 相同时间戳的数据以较新的为准: Cache优先于文件，新文件优先于旧文件
+type Cursor struct {
+	opt CursorOptions
+
+	// 尚未读取的block，升序时按MinTime升序排列，降序时按MaxTime降序排列
+	blocks []cursorBlock
+	// 已读取但尚未合并返回的数据
+	runs []cursorRun
+	// 已合并、等待返回的数据，按游标方向排列
+	out coder.Values
+
+	// 不展开的RLE数据段的处理函数
+	runHandler func(r *Run) bool
+	// 直接使用Index统计值的block的处理函数
+	statsHandler func(e *lsm.IndexEntry) bool
+
+	// 引用的TSM文件，关闭时释放
+	readers []*lsm.TSMReader
+
+	err error
+}
+
+// Run 不展开的RLE编码数据段，第i个点的值为Value+i*Delta
+type Run struct {
+	MinTime, MaxTime int64
+	Count            int
+	Value, Delta     byte
+
+	// 编码后的时间戳
+	times []byte
+}
+
+// 第i个点的值
+func (r *Run) At(i int) byte {
+	return r.Value + byte(i)*r.Delta
+}
+
+// 解码时间戳
+func (r *Run) Times() ([]int64, error) {
+	return coder.DecodeTimestamps(make([]int64, 0, r.Count), r.times)
+}
+
+type cursorBlock struct {
+	loc  lsm.BlockLocation
+	prio int
+}
+
+// 一段按时间升序排列的数据及其新旧程度，prio越大越新
+type cursorRun struct {
+	values coder.Values
+	prio   int
+}
+
+// 新建游标。c和fs均可为nil
+func NewCursor(c *cache.Cache, fs *lsm.FileStore, opt CursorOptions) *Cursor {
+	var locs []lsm.BlockLocation
+	if fs != nil {
+		locs = fs.Locations(opt.Key, opt.Min, opt.Max)
+		defer lsm.ReleaseLocations(locs)
+	}
+	var values coder.Values
+	if c != nil {
+		values = filterRange(c.Values(opt.Key), opt.Min, opt.Max)
+	}
+	return newCursor(locs, values, opt)
+}
+
+// 由按文件从旧到新排列的block和Cache中时间范围内的数据新建游标。游标引用block所在的文件直到关闭
+func newCursor(locs []lsm.BlockLocation, cached coder.Values, opt CursorOptions) *Cursor {
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = DefaultBatchSize
+	}
+	cur := &Cursor{opt: opt}
+
+	// 文件中的block，按文件从旧到新确定优先级
+	prio := 0
+	var last *lsm.TSMReader
+	for _, loc := range locs {
+		if loc.Reader != last {
+			prio++
+			last = loc.Reader
+			last.Ref()
+			cur.readers = append(cur.readers, last)
+		}
+		cur.blocks = append(cur.blocks, cursorBlock{loc: loc, prio: prio})
+	}
+	if opt.Ascending {
+		sort.SliceStable(cur.blocks, func(i, j int) bool {
+			return cur.blocks[i].loc.Entry.MinTime < cur.blocks[j].loc.Entry.MinTime
+		})
+	} else {
+		sort.SliceStable(cur.blocks, func(i, j int) bool {
+			return cur.blocks[i].loc.Entry.MaxTime > cur.blocks[j].loc.Entry.MaxTime
+		})
+	}
+
+	// Cache中的数据(含快照)最新
+	if len(cached) > 0 {
+		cur.runs = append(cur.runs, cursorRun{values: cached, prio: prio + 1})
+	}
+	return cur
+}
+
+// Next 返回下一批数据，数据读完时返回空
+func (c *Cursor) Next() (coder.Values, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		if c.opt.Context != nil {
+			if c.err = c.opt.Context.Err(); c.err != nil {
+				return nil, c.err
+			}
+		}
+		if len(c.blocks) == 0 && len(c.runs) == 0 {
+			return nil, nil
+		}
+		if c.err = c.fill(); c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	n := c.opt.BatchSize
+	if n > len(c.out) {
+		n = len(c.out)
+	}
+	values := c.out[:n:n]
+	c.out = c.out[n:]
+	return values, nil
+}
+
+// SetRunHandler 设置后，升序游标遇到不与其他数据重叠、值为RLE编码的block时不再展开，直接交给fn处理。
+// fn返回false时照常解码。fn在之前的数据都已由Next返回后调用
+func (c *Cursor) SetRunHandler(fn func(r *Run) bool) {
+	c.runHandler = fn
+}
+
+// SetStatsHandler 设置后，升序游标遇到不与其他文件或Cache数据重叠、完全在时间范围内且有统计值的block时，
+// 不读取数据，直接把Index项交给fn处理。fn返回false时照常读取。fn在之前的数据都已由Next返回后调用
+func (c *Cursor) SetStatsHandler(fn func(e *lsm.IndexEntry) bool) {
+	c.statsHandler = fn
+}
+
+// 关闭游标，释放引用的文件
+func (c *Cursor) Close() error {
+	c.blocks, c.runs, c.out = nil, nil, nil
+	var err error
+	for _, r := range c.readers {
+		if e := r.Unref(); e != nil && err == nil {
+			err = e
+		}
+	}
+	c.readers = nil
+	return err
+}
+
+// 读取下一段时间内的所有block并合并。
+// 升序时以第一个block的MaxTime为边界，MinTime不超过边界的block都已读取后，边界之前的数据是完整的
+func (c *Cursor) fill() error {
+	if c.fillStats() {
+		return nil
+	}
+	if ok, err := c.fillRun(); ok || err != nil {
+		return err
+	}
+
+	var bound int64
+	if c.opt.Ascending {
+		bound = c.opt.Max
+		if len(c.blocks) > 0 {
+			bound = c.blocks[0].loc.Entry.MaxTime
+		}
+		for len(c.blocks) > 0 && c.blocks[0].loc.Entry.MinTime <= bound {
+			if err := c.readBlock(c.blocks[0]); err != nil {
+				return err
+			}
+			c.blocks = c.blocks[1:]
+		}
+	} else {
+		bound = c.opt.Min
+		if len(c.blocks) > 0 {
+			bound = c.blocks[0].loc.Entry.MinTime
+		}
+		for len(c.blocks) > 0 && c.blocks[0].loc.Entry.MaxTime >= bound {
+			if err := c.readBlock(c.blocks[0]); err != nil {
+				return err
+			}
+			c.blocks = c.blocks[1:]
+		}
+	}
+
+	// 按优先级从旧到新取出边界内的数据，去重时保留最后一个即最新的数据
+	sort.SliceStable(c.runs, func(i, j int) bool { return c.runs[i].prio < c.runs[j].prio })
+	var merged coder.Values
+	runs := c.runs[:0]
+	for _, r := range c.runs {
+		var take coder.Values
+		if c.opt.Ascending {
+			i := sort.Search(len(r.values), func(i int) bool { return r.values[i].UnixNano > bound })
+			take, r.values = r.values[:i], r.values[i:]
+		} else {
+			i := sort.Search(len(r.values), func(i int) bool { return r.values[i].UnixNano >= bound })
+			r.values, take = r.values[:i], r.values[i:]
+		}
+		merged = append(merged, take...)
+		if len(r.values) > 0 {
+			runs = append(runs, r)
+		}
+	}
+	c.runs = runs
+
+	merged = merged.Deduplicate()
+	if !c.opt.Ascending {
+		for i, j := 0, len(merged)-1; i < j; i, j = i+1, j-1 {
+			merged[i], merged[j] = merged[j], merged[i]
+		}
+	}
+	c.out = c.filter(c.extractValues(merged))
+	return nil
+}
+
+// 取出值的指定位
+func (c *Cursor) extract(v byte) byte {
+	if !c.opt.ExtractBit {
+		return v
+	}
+	return (v >> c.opt.Bit) & 1
+}
+
+func (c *Cursor) extractValues(values coder.Values) coder.Values {
+	if c.opt.ExtractBit {
+		for i := range values {
+			values[i].Value = c.extract(values[i].Value)
+		}
+	}
+	return values
+}
+
+// 过滤不满足条件的数据
+func (c *Cursor) filter(values coder.Values) coder.Values {
+	if c.opt.Condition == nil {
+		return values
+	}
+	out := values[:0]
+	for _, v := range values {
+		ok, _ := EvalExpr(c.opt.Condition, func(name string) (float64, bool) {
+			return float64(v.Value), name == "value"
+		})
+		if ok != 0 {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// 升序且没有过滤条件时，第一个block完全在时间范围内并且不与其他block和Cache数据重叠
+func (c *Cursor) isolated() bool {
+	if !c.opt.Ascending || c.opt.Condition != nil || len(c.blocks) == 0 {
+		return false
+	}
+	e := &c.blocks[0].loc.Entry
+	if e.MinTime < c.opt.Min || e.MaxTime > c.opt.Max {
+		return false
+	}
+	if len(c.blocks) > 1 && c.blocks[1].loc.Entry.MinTime <= e.MaxTime {
+		return false
+	}
+	for _, r := range c.runs {
+		if r.values[0].UnixNano <= e.MaxTime {
+			return false
+		}
+	}
+	return true
+}
+
+// 第一个block可以直接使用统计值时交给statsHandler。返回true表示已处理
+func (c *Cursor) fillStats() bool {
+	if c.statsHandler == nil || c.opt.ExtractBit || !c.isolated() {
+		return false
+	}
+	b := c.blocks[0]
+	if !b.loc.Reader.HasStats() || b.loc.Entry.Count == 0 || !c.statsHandler(&b.loc.Entry) {
+		return false
+	}
+	c.blocks = c.blocks[1:]
+	return true
+}
+
+// 第一个block不与其他数据重叠时，单独处理该block。返回true表示已处理
+func (c *Cursor) fillRun() (bool, error) {
+	if c.runHandler == nil || !c.isolated() {
+		return false, nil
+	}
+	b := c.blocks[0]
+	e := &b.loc.Entry
+	data, err := b.loc.Reader.ReadBlock(c.opt.Key, e)
+	if err != nil {
+		return false, err
+	}
+	c.blocks = c.blocks[1:]
+	tb, vb, err := lsm.UnpackBlock(data)
+	if err != nil {
+		return false, err
+	}
+	// 取位时只有值不变的数据段仍为等差
+	if first, delta, count, ok := coder.ByteRun(vb); ok && (!c.opt.ExtractBit || delta == 0) {
+		r := &Run{MinTime: e.MinTime, MaxTime: e.MaxTime, Count: count, Value: c.extract(first), Delta: delta, times: tb}
+		if c.runHandler(r) {
+			return true, nil
+		}
+	}
+
+	// 不重叠的block不需要合并
+	values, err := lsm.DecodeByteBlock(data, nil)
+	if err != nil {
+		return false, err
+	}
+	c.out = c.filter(c.extractValues(values))
+	return true, nil
+}
+
+// 读取并解码一个block，只保留时间范围内的数据
+func (c *Cursor) readBlock(b cursorBlock) error {
+	values, err := b.loc.Reader.ReadValues(c.opt.Key, &b.loc.Entry, nil)
+	if err != nil {
+		return err
+	}
+	if values = filterRange(values, c.opt.Min, c.opt.Max); len(values) > 0 {
+		c.runs = append(c.runs, cursorRun{values: values, prio: b.prio})
+	}
+	return nil
+}
+
+// 截取升序数据中时间范围[min, max]内的部分
+func filterRange(values coder.Values, min, max int64) coder.Values {
+	i := sort.Search(len(values), func(i int) bool { return values[i].UnixNano >= min })
+	j := sort.Search(len(values), func(i int) bool { return values[i].UnixNano > max })
+	if i >= j {
+		return nil
+	}
+	return values[i:j]
+}
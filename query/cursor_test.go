@@ -0,0 +1,169 @@
+package query
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+// 测试用的存储: 目录下的FileStore和写文件用的Compactor
+type testStore struct {
+	dir       string
+	fs        *lsm.FileStore
+	compactor *lsm.Compactor
+}
+
+func newTestStore(t *testing.T) *testStore {
+	dir, err := ioutil.TempDir("", "query-")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	fs := lsm.NewFileStore(dir)
+	if err := fs.Open(); err != nil {
+		t.Fatalf("open file store fail: %v", err)
+	}
+	compactor := lsm.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Open()
+	return &testStore{dir: dir, fs: fs, compactor: compactor}
+}
+
+// 把数据写成一个新的TSM文件
+func (s *testStore) writeFile(t *testing.T, key uint32, ts []int64, values []byte) {
+	c := cache.NewCache(0)
+	if err := c.Write(key, ts, values); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	files, err := s.compactor.WriteSnapshot(c)
+	if err != nil {
+		t.Fatalf("write snapshot fail: %v", err)
+	}
+	if err := s.fs.Replace(nil, files); err != nil {
+		t.Fatalf("replace fail: %v", err)
+	}
+}
+
+func (s *testStore) Close() {
+	s.compactor.Close()
+	s.fs.Close()
+	os.RemoveAll(s.dir)
+}
+
+// 读取游标中的所有数据
+func readCursor(t *testing.T, cur *Cursor) coder.Values {
+	var all coder.Values
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			t.Fatalf("cursor next fail: %v", err)
+		}
+		if len(values) == 0 {
+			return all
+		}
+		if len(values) > cur.opt.BatchSize {
+			t.Fatalf("batch too large: %d", len(values))
+		}
+		all = append(all, values...)
+	}
+}
+
+// 合并Cache、新文件和旧文件的数据，相同时间戳以新数据为准
+func TestCursor_Merge(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	// 期望结果，按写入顺序覆盖
+	exp := make(map[int64]byte)
+	write := func(ts []int64, values []byte) {
+		for i := range ts {
+			exp[ts[i]] = values[i]
+		}
+	}
+	series := func(start, end, step int64, v func(i int) byte) ([]int64, []byte) {
+		var ts []int64
+		var values []byte
+		for t := start; t < end; t += step {
+			ts = append(ts, t)
+			values = append(values, v(len(values)))
+		}
+		return ts, values
+	}
+
+	// 旧文件，多个block
+	ts, values := series(0, 30000, 10, func(i int) byte { return byte(i) })
+	s.writeFile(t, 1, ts, values)
+	write(ts, values)
+	// 新文件覆盖一部分
+	ts, values = series(5000, 6000, 5, func(i int) byte { return 200 })
+	s.writeFile(t, 1, ts, values)
+	write(ts, values)
+	// 其他key不影响结果
+	s.writeFile(t, 2, []int64{5000}, []byte{1})
+
+	// Cache中最新
+	c := cache.NewCache(0)
+	ts, values = series(5500, 5600, 10, func(i int) byte { return 250 })
+	ts, values = append(ts, 40000), append(values, 7)
+	if err := c.Write(1, ts, values); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	write(ts, values)
+
+	min, max := int64(1000), int64(40000)
+	var except coder.Values
+	for t, v := range exp {
+		if t >= min && t <= max {
+			except = append(except, coder.NewValue(t, v))
+		}
+	}
+	except = except.Deduplicate()
+
+	for _, ascending := range []bool{true, false} {
+		cur := NewCursor(c, s.fs, CursorOptions{Key: 1, Min: min, Max: max, Ascending: ascending, BatchSize: 64})
+		actual := readCursor(t, cur)
+		cur.Close()
+		if len(actual) != len(except) {
+			t.Fatalf("values count error. ascending %v: except %d, actual %d", ascending, len(except), len(actual))
+		}
+		for i := range except {
+			e := except[i]
+			if !ascending {
+				e = except[len(except)-1-i]
+			}
+			if actual[i] != e {
+				t.Fatalf("value error. ascending %v, index %d: except %v, actual %v", ascending, i, e, actual[i])
+			}
+		}
+	}
+}
+
+// 游标读取期间文件被替换，关闭游标后才删除
+func TestCursor_ReplaceInUse(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+	s.writeFile(t, 1, []int64{1, 2, 3}, []byte{1, 2, 3})
+	path := s.fs.Files()[0].Path()
+
+	cur := NewCursor(nil, s.fs, CursorOptions{Key: 1, Min: math.MinInt64, Max: math.MaxInt64, Ascending: true})
+	if err := s.fs.Replace([]string{path}, nil); err != nil {
+		t.Fatalf("replace fail: %v", err)
+	}
+	if values := readCursor(t, cur); len(values) != 3 {
+		t.Fatalf("values error: %v", values)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file in use should not be removed: %v", err)
+	}
+	if err := cur.Close(); err != nil {
+		t.Fatalf("close cursor fail: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file should be removed after cursor closed")
+	}
+}
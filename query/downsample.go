@@ -0,0 +1,262 @@
+package query
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 降采样参数
+type DownsampleOptions struct {
+	// 降采样方式: lttb或minmax
+	Mode string
+	// 最多返回的点数
+	Points int
+}
+
+// Downsample 把游标中的数据按时间等分为若干段，从原始数据中挑选不超过opt.Points个点。
+// lttb为Largest-Triangle-Three-Buckets算法；minmax每段保留第一个、最小、最大和最后一个点，
+// 保证峰值和段边界处的状态变化不丢失。数据量不超过opt.Points时返回原始数据
+func Downsample(cur *Cursor, opt DownsampleOptions) (coder.Values, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	var d downsampler
+	switch opt.Mode {
+	case "lttb":
+		if opt.Points < 3 {
+			return nil, ErrTooFewPoints(opt.Points, 3)
+		}
+		d = &lttb{buckets: opt.Points - 2}
+	case "minmax":
+		if opt.Points < 4 {
+			return nil, ErrTooFewPoints(opt.Points, 4)
+		}
+		d = &minMax{buckets: opt.Points / 4}
+	default:
+		return nil, ErrUnknownFunction(opt.Mode)
+	}
+	lo, hi := cur.dataRange()
+
+	// 先缓存opt.Points个点，数据量不超过时直接返回
+	var raw coder.Values
+	started := false
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			break
+		}
+		if !started {
+			raw = append(raw, values...)
+			if len(raw) <= opt.Points {
+				continue
+			}
+			started = true
+			d.init(lo, hi)
+			values, raw = raw, nil
+		}
+		for _, v := range values {
+			d.add(v)
+		}
+	}
+	if !started {
+		return raw, nil
+	}
+	return d.finish(), nil
+}
+
+// 游标中数据的时间范围，不读取数据
+func (c *Cursor) dataRange() (int64, int64) {
+	lo, hi := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, b := range c.blocks {
+		if b.loc.Entry.MinTime < lo {
+			lo = b.loc.Entry.MinTime
+		}
+		if b.loc.Entry.MaxTime > hi {
+			hi = b.loc.Entry.MaxTime
+		}
+	}
+	for _, r := range c.runs {
+		if r.values[0].UnixNano < lo {
+			lo = r.values[0].UnixNano
+		}
+		if r.values[len(r.values)-1].UnixNano > hi {
+			hi = r.values[len(r.values)-1].UnixNano
+		}
+	}
+	if lo < c.opt.Min {
+		lo = c.opt.Min
+	}
+	if hi > c.opt.Max {
+		hi = c.opt.Max
+	}
+	return lo, hi
+}
+
+type downsampler interface {
+	init(lo, hi int64)
+	add(v coder.Value)
+	finish() coder.Values
+}
+
+// 把时间范围[lo, hi]等分为n段
+type timeBuckets struct {
+	lo    int64
+	width float64
+	n     int
+}
+
+func (b *timeBuckets) init(lo, hi int64, n int) {
+	b.lo, b.n = lo, n
+	b.width = float64(hi-lo+1) / float64(n)
+}
+
+// t所在的段
+func (b *timeBuckets) index(t int64) int {
+	i := int(float64(t-b.lo) / b.width)
+	if i < 0 {
+		return 0
+	}
+	if i >= b.n {
+		return b.n - 1
+	}
+	return i
+}
+
+// 流式LTTB: 第一个点和最后一个点总是保留，中间每段选出与前一个选中点、后一段平均点构成的三角形面积最大的点
+type lttb struct {
+	buckets int
+	tb      timeBuckets
+
+	out coder.Values
+	// 当前段和下一个非空段
+	cur, next       coder.Values
+	curIdx, nextIdx int
+}
+
+func (l *lttb) init(lo, hi int64) {
+	l.tb.init(lo, hi, l.buckets)
+	l.curIdx, l.nextIdx = -1, -1
+}
+
+func (l *lttb) add(v coder.Value) {
+	if len(l.out) == 0 {
+		l.out = append(l.out, v)
+		return
+	}
+	i := l.tb.index(v.UnixNano)
+	switch {
+	case l.curIdx < 0 || i == l.curIdx:
+		l.cur, l.curIdx = append(l.cur, v), i
+	case l.nextIdx < 0 || i == l.nextIdx:
+		l.next, l.nextIdx = append(l.next, v), i
+	default:
+		// 下一段已完整，选出当前段的点
+		l.selectFrom(l.cur, average(l.next))
+		l.cur, l.curIdx = append(l.cur[:0], l.next...), l.nextIdx
+		l.next, l.nextIdx = append(l.next[:0], v), i
+	}
+}
+
+func (l *lttb) finish() coder.Values {
+	// 最后一个点总是保留
+	var last coder.Value
+	if len(l.next) > 0 {
+		last, l.next = l.next[len(l.next)-1], l.next[:len(l.next)-1]
+		if len(l.next) > 0 {
+			l.selectFrom(l.cur, average(l.next))
+			l.selectFrom(l.next, last)
+		} else {
+			l.selectFrom(l.cur, last)
+		}
+	} else if len(l.cur) > 0 {
+		last, l.cur = l.cur[len(l.cur)-1], l.cur[:len(l.cur)-1]
+		l.selectFrom(l.cur, last)
+	} else {
+		return l.out
+	}
+	return append(l.out, last)
+}
+
+// 从bucket中选出与前一个选中点、c构成的三角形面积最大的点
+func (l *lttb) selectFrom(bucket coder.Values, c coder.Value) {
+	if len(bucket) == 0 {
+		return
+	}
+	a := l.out[len(l.out)-1]
+	best, max := 0, -1.0
+	for i, b := range bucket {
+		area := math.Abs(float64(a.UnixNano-c.UnixNano)*(float64(b.Value)-float64(a.Value)) -
+			float64(a.UnixNano-b.UnixNano)*(float64(c.Value)-float64(a.Value)))
+		if area > max {
+			best, max = i, area
+		}
+	}
+	l.out = append(l.out, bucket[best])
+}
+
+// 平均点，时间和值均取平均
+func average(values coder.Values) coder.Value {
+	var t, v float64
+	for _, p := range values {
+		t += float64(p.UnixNano)
+		v += float64(p.Value)
+	}
+	n := float64(len(values))
+	return coder.Value{UnixNano: int64(t / n), Value: byte(math.Round(v / n))}
+}
+
+// 每段保留第一个、最小、最大和最后一个点
+type minMax struct {
+	buckets int
+	tb      timeBuckets
+
+	out coder.Values
+	// 当前段
+	idx                   int
+	first, min, max, last coder.Value
+	active                bool
+}
+
+func (m *minMax) init(lo, hi int64) {
+	m.tb.init(lo, hi, m.buckets)
+}
+
+func (m *minMax) add(v coder.Value) {
+	i := m.tb.index(v.UnixNano)
+	if !m.active || i != m.idx {
+		m.flush()
+		m.idx, m.active = i, true
+		m.first, m.min, m.max = v, v, v
+	}
+	if v.Value < m.min.Value {
+		m.min = v
+	}
+	if v.Value > m.max.Value {
+		m.max = v
+	}
+	m.last = v
+}
+
+func (m *minMax) flush() {
+	if !m.active {
+		return
+	}
+	points := coder.Values{m.first, m.min, m.max, m.last}
+	sort.Stable(points)
+	for i, p := range points {
+		if i == 0 || p.UnixNano != points[i-1].UnixNano {
+			m.out = append(m.out, p)
+		}
+	}
+	m.active = false
+}
+
+func (m *minMax) finish() coder.Values {
+	m.flush()
+	return m.out
+}
@@ -0,0 +1,77 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+func TestDownsample(t *testing.T) {
+	// 在10和20之间切换的状态信号，中间有一个尖峰
+	var ts []int64
+	var values []byte
+	for i := 0; i < 10000; i++ {
+		v := byte(10)
+		if (i/1000)%2 == 1 {
+			v = 20
+		}
+		if i == 4321 {
+			v = 250
+		}
+		ts, values = append(ts, int64(i)), append(values, v)
+	}
+	c := newTestCache(t, 1, ts, values)
+	contains := func(values coder.Values, p coder.Value) bool {
+		for _, v := range values {
+			if v == p {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, mode := range []string{"lttb", "minmax"} {
+		cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 20000, Ascending: true, BatchSize: 333})
+		out, err := Downsample(cur, DownsampleOptions{Mode: mode, Points: 100})
+		if err != nil {
+			t.Fatalf("%s downsample fail: %v", mode, err)
+		}
+		if len(out) > 100 || len(out) < 50 {
+			t.Fatalf("%s points count error: %d", mode, len(out))
+		}
+		for i := 1; i < len(out); i++ {
+			if out[i].UnixNano <= out[i-1].UnixNano {
+				t.Fatalf("%s points not sorted at %d", mode, i)
+			}
+		}
+		if !contains(out, coder.NewValue(4321, 250)) {
+			t.Fatalf("%s lost peak", mode)
+		}
+		if out[0] != coder.NewValue(0, 10) {
+			t.Fatalf("%s lost first point: %v", mode, out[0])
+		}
+		// 每次状态切换前后的值都保留: 切换前最后一个点为旧值，切换后第一个点为新值
+		for k := 1; k < 10; k++ {
+			i := 0
+			for i < len(out) && out[i].UnixNano < int64(k*1000) {
+				i++
+			}
+			if i == 0 || i == len(out) || out[i-1].Value != values[k*1000-1] || out[i].Value != values[k*1000] {
+				t.Fatalf("%s lost transition at %d", mode, k*1000)
+			}
+		}
+	}
+	// lttb保留最后一个点
+	cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 20000, Ascending: true})
+	out, err := Downsample(cur, DownsampleOptions{Mode: "lttb", Points: 100})
+	if err != nil || out[len(out)-1] != coder.NewValue(9999, 20) {
+		t.Fatalf("lttb lost last point: %v", err)
+	}
+
+	// 数据量较少时返回原始数据
+	cur = NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 49, Ascending: true})
+	out, err = Downsample(cur, DownsampleOptions{Mode: "lttb", Points: 100})
+	if err != nil || len(out) != 50 {
+		t.Fatalf("expected raw values: %d, %v", len(out), err)
+	}
+}
@@ -0,0 +1,82 @@
+package query
+
+import "fmt"
+
+// QueryError 查询语句本身的错误，如字段、函数参数或时间条件不合法，与读取数据时的错误区分
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string { return e.Message }
+
+func newQueryError(format string, a ...interface{}) error {
+	return &QueryError{Message: fmt.Sprintf(format, a...)}
+}
+
+// IsQueryError 错误是否由查询语句本身引起，包括解析错误
+func IsQueryError(err error) bool {
+	switch err.(type) {
+	case *QueryError, *ParseError:
+		return true
+	}
+	return false
+}
+
+var (
+	// 聚合等计算要求游标按时间升序
+	ErrDescendingCursor = fmt.Errorf("cursor must be ascending")
+
+	// 已按位取值的游标不能再按位统计
+	ErrExtractBit = fmt.Errorf("cursor already extracts a bit")
+
+	// 数据不足，无法推断采样间隔
+	ErrUnknownInterval error = &QueryError{Message: "unable to infer sampling interval"}
+
+	// 百分位超出范围
+	ErrInvalidPercentile error = &QueryError{Message: "percentile must be between 0 and 100"}
+)
+
+// 不支持的函数
+func ErrUnknownFunction(name string) error {
+	return newQueryError("unknown function: %s", name)
+}
+
+// 降采样的点数过少
+func ErrTooFewPoints(n, min int) error {
+	return newQueryError("too few points: %d, at least %d", n, min)
+}
+
+// 表达式引用了不存在的列
+func ErrUnknownColumn(name string) error {
+	return newQueryError("unknown column: $%s", name)
+}
+
+// 时间窗口数量超过上限
+func ErrTooManyWindows(n, limit int64) error {
+	return newQueryError("too many windows: (%d/%d)", n, limit)
+}
+
+// 不支持的语句
+func ErrUnsupportedStatement(stmt Statement) error {
+	return newQueryError("unsupported statement: %s", stmt)
+}
+
+// 函数参数错误
+func ErrInvalidArguments(call *Call) error {
+	return newQueryError("invalid arguments: %s", call)
+}
+
+// 不能作为输出的字段
+func ErrInvalidField(e Expr) error {
+	return newQueryError("invalid field: %s", e)
+}
+
+// 时间条件只能是time与时间的比较，并且只能用AND连接
+func ErrInvalidTimeCondition(e Expr) error {
+	return newQueryError("invalid time condition: %s", e)
+}
+
+// 无法计算为时间的表达式
+func ErrInvalidTime(e Expr) error {
+	return newQueryError("invalid time: %s", e)
+}
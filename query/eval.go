@@ -0,0 +1,85 @@
+package query
+
+import "math"
+
+// EvalExpr 计算表达式，lookup返回列或变量的值，没有值时返回false。
+// 比较和布尔运算的结果为1或0，非0的值视为真。任一操作数没有值或除数为0时结果没有值
+func EvalExpr(e Expr, lookup func(name string) (float64, bool)) (float64, bool) {
+	switch e := e.(type) {
+	case *NumberLiteral:
+		return e.Val, true
+	case *ColumnRef:
+		return lookup(e.Name)
+	case *VarRef:
+		return lookup(e.Name)
+	case *ParenExpr:
+		return EvalExpr(e.Expr, lookup)
+	case *UnaryExpr:
+		x, ok := EvalExpr(e.X, lookup)
+		if !ok {
+			return 0, false
+		}
+		switch e.Op {
+		case SUB:
+			return -x, true
+		case NOT:
+			return boolValue(x == 0), true
+		}
+	case *BinaryExpr:
+		lhs, ok := EvalExpr(e.LHS, lookup)
+		if !ok {
+			return 0, false
+		}
+		rhs, ok := EvalExpr(e.RHS, lookup)
+		if !ok {
+			return 0, false
+		}
+		return evalBinary(e.Op, lhs, rhs)
+	}
+	return 0, false
+}
+
+func evalBinary(op Token, lhs, rhs float64) (float64, bool) {
+	switch op {
+	case ADD:
+		return lhs + rhs, true
+	case SUB:
+		return lhs - rhs, true
+	case MUL:
+		return lhs * rhs, true
+	case DIV:
+		if rhs == 0 {
+			return 0, false
+		}
+		return lhs / rhs, true
+	case MOD:
+		if rhs == 0 {
+			return 0, false
+		}
+		return math.Mod(lhs, rhs), true
+	case AND:
+		return boolValue(lhs != 0 && rhs != 0), true
+	case OR:
+		return boolValue(lhs != 0 || rhs != 0), true
+	case EQ:
+		return boolValue(lhs == rhs), true
+	case NEQ:
+		return boolValue(lhs != rhs), true
+	case LT:
+		return boolValue(lhs < rhs), true
+	case LTE:
+		return boolValue(lhs <= rhs), true
+	case GT:
+		return boolValue(lhs > rhs), true
+	case GTE:
+		return boolValue(lhs >= rhs), true
+	}
+	return 0, false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
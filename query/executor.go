@@ -0,0 +1,600 @@
+package query
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+// Result 查询结果
+type Result struct {
+	Series []*Series `json:"series"`
+}
+
+// Series 一组结果数据，Values中每行与Columns一一对应
+type Series struct {
+	Name    string          `json:"name"`
+	Columns []string        `json:"columns"`
+	Values  [][]interface{} `json:"values"`
+}
+
+// ResultWriter 接收流式输出的查询结果。
+// 每组结果先调用BeginSeries，再逐行调用WriteRow，返回错误时停止执行
+type ResultWriter interface {
+	BeginSeries(name string, columns []string) error
+	WriteRow(row []interface{}) error
+}
+
+// 把流式结果收集为Result
+type resultCollector struct {
+	res Result
+}
+
+func (c *resultCollector) BeginSeries(name string, columns []string) error {
+	c.res.Series = append(c.res.Series, &Series{Name: name, Columns: columns})
+	return nil
+}
+
+func (c *resultCollector) WriteRow(row []interface{}) error {
+	s := c.res.Series[len(c.res.Series)-1]
+	s.Values = append(s.Values, row)
+	return nil
+}
+
+// EXPLAIN输出的列
+var explainColumns = []string{"key", "source", "path", "min_time", "max_time", "offset", "size", "count", "skip"}
+
+// Executor 在Cache和TSM文件上执行查询语句
+type Executor struct {
+	Cache     *cache.Cache
+	FileStore *lsm.FileStore
+	// 当前时间，用于now()。为nil时使用time.Now
+	Now func() time.Time
+}
+
+// Execute 解析并执行查询语句
+func (e *Executor) Execute(q string) (*Result, error) {
+	stmt, err := ParseStatement(q)
+	if err != nil {
+		return nil, err
+	}
+	return e.ExecuteStatement(stmt)
+}
+
+// ExecuteStatement 执行语句并返回全部结果。
+// SELECT对每个key输出一组结果，第一列为时间；字段中引用$key时把所有key对齐后输出一组结果
+func (e *Executor) ExecuteStatement(stmt Statement) (*Result, error) {
+	c := &resultCollector{}
+	if err := e.ExecuteStream(context.Background(), stmt, c); err != nil {
+		return nil, err
+	}
+	return &c.res, nil
+}
+
+// ExecuteStream 执行语句并把结果逐行写入w。
+// 只查询原始值时边读边写，不缓存整个结果；ctx取消后停止读取并返回ctx.Err()
+func (e *Executor) ExecuteStream(ctx context.Context, stmt Statement, w ResultWriter) error {
+	switch stmt := stmt.(type) {
+	case *SelectStatement:
+		return e.executeSelect(ctx, stmt, w)
+	case *ExplainStatement:
+		return e.explain(stmt.Statement, w)
+	}
+	return ErrUnsupportedStatement(stmt)
+}
+
+// 为每个key制定读取计划，返回计划和WHERE中的非时间条件
+func (e *Executor) plan(stmt *SelectStatement) ([]*KeyPlan, Expr, error) {
+	min, max, filter, err := e.splitCondition(stmt.Condition)
+	if err != nil {
+		return nil, nil, err
+	}
+	plans := make([]*KeyPlan, len(stmt.Sources))
+	for i, key := range stmt.Sources {
+		plans[i] = PlanKey(e.Cache, e.FileStore, key, min, max)
+	}
+	return plans, filter, nil
+}
+
+func (e *Executor) executeSelect(ctx context.Context, stmt *SelectStatement, w ResultWriter) error {
+	plans, filter, err := e.plan(stmt)
+	if err != nil {
+		return err
+	}
+	defer releasePlans(plans)
+	for _, f := range stmt.Fields {
+		if len(ExprColumns(f.Expr)) > 0 {
+			return e.selectAligned(ctx, stmt, plans, filter, w)
+		}
+	}
+
+	raw := true
+	for _, f := range stmt.Fields {
+		raw = raw && isValueRef(f.Expr)
+	}
+	for _, p := range plans {
+		if raw {
+			err = e.selectValues(ctx, stmt, p, filter, w)
+		} else {
+			err = e.selectPoints(ctx, stmt, p, filter, w)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 只查询原始值时按批读取并输出
+func (e *Executor) selectValues(ctx context.Context, stmt *SelectStatement, p *KeyPlan, filter Expr, w ResultWriter) error {
+	cur := p.Cursor(CursorOptions{Ascending: true, Condition: filter, Context: ctx})
+	defer cur.Close()
+	if err := w.BeginSeries(strconv.FormatUint(uint64(p.Key), 10), fieldColumns(stmt)); err != nil {
+		return err
+	}
+	n := 0
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		for _, v := range values {
+			if stmt.Limit > 0 && n >= stmt.Limit {
+				return nil
+			}
+			row := make([]interface{}, len(stmt.Fields)+1)
+			row[0] = v.UnixNano
+			for i := range stmt.Fields {
+				row[i+1] = float64(v.Value)
+			}
+			if err := w.WriteRow(row); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+}
+
+// 分别计算每个字段后按时间合并输出。原始值字段直接从游标逐批读取，不在内存中收集
+func (e *Executor) selectPoints(ctx context.Context, stmt *SelectStatement, p *KeyPlan, filter Expr, w ResultWriter) error {
+	cols := make([]*pointColumn, len(stmt.Fields))
+	for i, f := range stmt.Fields {
+		if isValueRef(f.Expr) {
+			cur := p.Cursor(CursorOptions{Ascending: true, Condition: filter, Context: ctx})
+			defer cur.Close()
+			cols[i] = &pointColumn{cur: cur}
+			continue
+		}
+		points, err := e.fieldPoints(ctx, stmt, f, p, filter)
+		if err != nil {
+			return err
+		}
+		cols[i] = &pointColumn{points: points}
+	}
+	if err := w.BeginSeries(strconv.FormatUint(uint64(p.Key), 10), fieldColumns(stmt)); err != nil {
+		return err
+	}
+	return joinPoints(cols, stmt.Limit, w.WriteRow)
+}
+
+func releasePlans(plans []*KeyPlan) {
+	for _, p := range plans {
+		p.Release()
+	}
+}
+
+// 输出列名，第一列为时间
+func fieldColumns(stmt *SelectStatement) []string {
+	columns := []string{"time"}
+	for _, f := range stmt.Fields {
+		columns = append(columns, f.Name())
+	}
+	return columns
+}
+
+// 计算一个key的一个字段
+func (e *Executor) fieldPoints(ctx context.Context, stmt *SelectStatement, f *Field, p *KeyPlan, filter Expr) ([]Point, error) {
+	cur := p.Cursor(CursorOptions{Ascending: true, Condition: filter, Context: ctx})
+	defer cur.Close()
+	w := stmt.Window()
+
+	switch expr := f.Expr.(type) {
+	case *VarRef:
+		// 原始值字段由selectPoints从游标读取
+		return nil, ErrInvalidField(expr)
+
+	case *Call:
+		name := strings.ToLower(expr.Name)
+		switch name {
+		case "percentile":
+			if len(expr.Args) != 2 || !isValueRef(expr.Args[0]) {
+				return nil, ErrInvalidArguments(expr)
+			}
+			pct, ok := expr.Args[1].(*NumberLiteral)
+			if !ok {
+				return nil, ErrInvalidArguments(expr)
+			}
+			return Percentile(cur, HistogramOptions{Window: w}, pct.Val)
+		case "mode":
+			if len(expr.Args) != 1 || !isValueRef(expr.Args[0]) {
+				return nil, ErrInvalidArguments(expr)
+			}
+			return Mode(cur, HistogramOptions{Window: w})
+		case "difference", "non_negative_difference", "derivative", "rate":
+			// 逐点计算，不分窗口
+			opt := CounterOptions{Func: name}
+			switch {
+			case len(expr.Args) == 2 && (name == "derivative" || name == "rate"):
+				unit, ok := expr.Args[1].(*DurationLiteral)
+				if !ok || unit.Val <= 0 {
+					return nil, ErrInvalidArguments(expr)
+				}
+				opt.Unit = unit.Val
+			case len(expr.Args) != 1:
+				return nil, ErrInvalidArguments(expr)
+			}
+			if !isValueRef(expr.Args[0]) {
+				return nil, ErrInvalidArguments(expr)
+			}
+			return Counter(cur, opt)
+		}
+
+		if len(expr.Args) != 1 || !isValueRef(expr.Args[0]) {
+			return nil, ErrInvalidArguments(expr)
+		}
+		points, err := Aggregate(cur, AggregateOptions{Func: name, Window: w, Fill: stmt.Fill, FillValue: stmt.FillValue})
+		if err != nil {
+			return nil, err
+		}
+		// 不分窗口且没有起始时间时输出在0时刻
+		for i := range points {
+			if points[i].Time == math.MinInt64 {
+				points[i].Time = 0
+			}
+		}
+		return points, nil
+	}
+	return nil, ErrInvalidField(f.Expr)
+}
+
+func isValueRef(e Expr) bool {
+	ref, ok := e.(*VarRef)
+	return ok && ref.Name == "value"
+}
+
+// 一个字段按时间升序的结果。cur不为空时为原始值，每次只缓存一批
+type pointColumn struct {
+	points []Point
+	cur    *Cursor
+}
+
+// 返回当前最早的点，没有更多点时ok为false
+func (c *pointColumn) peek() (p Point, ok bool, err error) {
+	for len(c.points) == 0 && c.cur != nil {
+		values, err := c.cur.Next()
+		if err != nil {
+			return Point{}, false, err
+		}
+		if len(values) == 0 {
+			c.cur = nil
+			break
+		}
+		for _, v := range values {
+			c.points = append(c.points, Point{Time: v.UnixNano, Value: float64(v.Value)})
+		}
+	}
+	if len(c.points) == 0 {
+		return Point{}, false, nil
+	}
+	return c.points[0], true, nil
+}
+
+// 丢弃当前最早的点
+func (c *pointColumn) pop() {
+	c.points = c.points[1:]
+}
+
+// 按时间合并多个字段的结果并逐行交给fn，某个字段在该时间没有值时为nil
+func joinPoints(cols []*pointColumn, limit int, fn func(row []interface{}) error) error {
+	for n := 0; limit == 0 || n < limit; n++ {
+		// 各字段中最早的时间
+		t, ok := int64(math.MaxInt64), false
+		for _, c := range cols {
+			p, has, err := c.peek()
+			if err != nil {
+				return err
+			}
+			if has && p.Time <= t {
+				t, ok = p.Time, true
+			}
+		}
+		if !ok {
+			break
+		}
+
+		row := make([]interface{}, len(cols)+1)
+		row[0] = t
+		for i, c := range cols {
+			if p, has, _ := c.peek(); has && p.Time == t {
+				if !p.Nil {
+					row[i+1] = p.Value
+				}
+				c.pop()
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把所有key对齐到同一时间网格后计算引用$key的字段。FILL(linear)时线性插值，否则取之前最近的值
+func (e *Executor) selectAligned(ctx context.Context, stmt *SelectStatement, plans []*KeyPlan, filter Expr, w ResultWriter) error {
+	for _, f := range stmt.Fields {
+		var invalid Expr
+		walkExpr(f.Expr, func(x Expr) {
+			switch x.(type) {
+			case *VarRef, *Call, *DurationLiteral, *StringLiteral:
+				invalid = x
+			}
+		})
+		if invalid != nil {
+			return ErrInvalidField(invalid)
+		}
+	}
+
+	names := make([]string, len(plans))
+	curs := make([]*Cursor, len(plans))
+	for i, p := range plans {
+		names[i] = strconv.FormatUint(uint64(p.Key), 10)
+		curs[i] = p.Cursor(CursorOptions{Ascending: true, Context: ctx})
+		defer curs[i].Close()
+	}
+	opt := AlignOptions{Grid: stmt.Window()}
+	if stmt.Fill == FillLinear {
+		opt.Method = AlignLinear
+	}
+	t, err := Align(curs, opt)
+	if err != nil {
+		return err
+	}
+	if filter != nil {
+		if t, err = t.Where(filter); err != nil {
+			return err
+		}
+	}
+
+	cols := make([]*Column, len(stmt.Fields))
+	for i, f := range stmt.Fields {
+		if cols[i], err = t.Eval(f.Expr); err != nil {
+			return err
+		}
+	}
+	if err := w.BeginSeries(strings.Join(names, ","), fieldColumns(stmt)); err != nil {
+		return err
+	}
+	for j, tm := range t.Times {
+		if stmt.Limit > 0 && j >= stmt.Limit {
+			break
+		}
+		row := make([]interface{}, len(cols)+1)
+		row[0] = tm
+		for i, col := range cols {
+			if col.Valid[j] {
+				row[i+1] = col.Values[j]
+			}
+		}
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 输出每个key读取的文件、block和Cache数据
+func (e *Executor) explain(stmt *SelectStatement, w ResultWriter) error {
+	plans, _, err := e.plan(stmt)
+	if err != nil {
+		return err
+	}
+	defer releasePlans(plans)
+	if err := w.BeginSeries("explain", explainColumns); err != nil {
+		return err
+	}
+	for _, p := range plans {
+		for _, fp := range p.Files {
+			row := []interface{}{p.Key, "file", fp.Path, nil, nil, nil, nil, len(fp.Blocks), fp.Skip}
+			if fp.Skip != SkipKeyRange && fp.Skip != SkipBloom {
+				row[3], row[4] = fp.MinTime, fp.MaxTime
+			}
+			if err := w.WriteRow(row); err != nil {
+				return err
+			}
+
+			for _, b := range fp.Blocks {
+				var count interface{}
+				if fp.reader.HasStats() {
+					count = b.Count
+				}
+				if err := w.WriteRow([]interface{}{p.Key, "block", fp.Path, b.MinTime, b.MaxTime, b.Offset, b.Size, count, ""}); err != nil {
+					return err
+				}
+			}
+		}
+		if n := len(p.Cached); n > 0 {
+			if err := w.WriteRow([]interface{}{p.Key, "cache", nil, p.Cached[0].UnixNano, p.Cached[n-1].UnixNano, nil, nil, n, ""}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 从WHERE条件中取出时间范围，返回其余条件。时间条件只能用AND与其他条件连接
+func (e *Executor) splitCondition(cond Expr) (int64, int64, Expr, error) {
+	min, max := int64(math.MinInt64), int64(math.MaxInt64)
+	var filter Expr
+	for _, c := range conjuncts(cond) {
+		if !refsTime(c) {
+			if filter == nil {
+				filter = c
+			} else {
+				filter = &BinaryExpr{Op: AND, LHS: filter, RHS: c}
+			}
+			continue
+		}
+
+		b, ok := c.(*BinaryExpr)
+		if !ok {
+			return 0, 0, nil, ErrInvalidTimeCondition(c)
+		}
+		op, other := b.Op, b.RHS
+		if !isTimeRef(b.LHS) {
+			if !isTimeRef(b.RHS) {
+				return 0, 0, nil, ErrInvalidTimeCondition(c)
+			}
+			op, other = flipComparison(op), b.LHS
+		}
+		t, err := e.evalTime(other)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		switch op {
+		case GT:
+			if t == math.MaxInt64 {
+				min = t
+			} else if t+1 > min {
+				min = t + 1
+			}
+		case GTE:
+			if t > min {
+				min = t
+			}
+		case LT:
+			if t == math.MinInt64 {
+				max = t
+			} else if t-1 < max {
+				max = t - 1
+			}
+		case LTE:
+			if t < max {
+				max = t
+			}
+		case EQ:
+			if t > min {
+				min = t
+			}
+			if t < max {
+				max = t
+			}
+		default:
+			return 0, 0, nil, ErrInvalidTimeCondition(c)
+		}
+	}
+	return min, max, filter, nil
+}
+
+// 按AND拆分条件
+func conjuncts(e Expr) []Expr {
+	switch x := e.(type) {
+	case nil:
+		return nil
+	case *ParenExpr:
+		return conjuncts(x.Expr)
+	case *BinaryExpr:
+		if x.Op == AND {
+			return append(conjuncts(x.LHS), conjuncts(x.RHS)...)
+		}
+	}
+	return []Expr{e}
+}
+
+func isTimeRef(e Expr) bool {
+	ref, ok := e.(*VarRef)
+	return ok && ref.Name == "time"
+}
+
+// 表达式是否引用了time
+func refsTime(e Expr) bool {
+	found := false
+	walkExpr(e, func(x Expr) {
+		if isTimeRef(x) {
+			found = true
+		}
+	})
+	return found
+}
+
+// 交换比较运算的左右两侧后对应的运算符
+func flipComparison(op Token) Token {
+	switch op {
+	case LT:
+		return GT
+	case LTE:
+		return GTE
+	case GT:
+		return LT
+	case GTE:
+		return LTE
+	}
+	return op
+}
+
+// 计算时间表达式，结果为纳秒时间戳。支持now()、RFC3339字符串、纳秒整数和时间长度的加减
+func (e *Executor) evalTime(x Expr) (int64, error) {
+	switch x := x.(type) {
+	case *NumberLiteral:
+		if t, err := strconv.ParseInt(x.Raw, 10, 64); err == nil {
+			return t, nil
+		}
+		return int64(x.Val), nil
+	case *DurationLiteral:
+		return int64(x.Val), nil
+	case *StringLiteral:
+		t, err := time.Parse(time.RFC3339Nano, x.Val)
+		if err != nil {
+			return 0, ErrInvalidTime(x)
+		}
+		return t.UnixNano(), nil
+	case *Call:
+		if strings.ToLower(x.Name) != "now" || len(x.Args) != 0 {
+			return 0, ErrInvalidTime(x)
+		}
+		if e.Now != nil {
+			return e.Now().UnixNano(), nil
+		}
+		return time.Now().UnixNano(), nil
+	case *ParenExpr:
+		return e.evalTime(x.Expr)
+	case *UnaryExpr:
+		if x.Op == SUB {
+			t, err := e.evalTime(x.X)
+			return -t, err
+		}
+	case *BinaryExpr:
+		if x.Op == ADD || x.Op == SUB {
+			lhs, err := e.evalTime(x.LHS)
+			if err != nil {
+				return 0, err
+			}
+			rhs, err := e.evalTime(x.RHS)
+			if err != nil {
+				return 0, err
+			}
+			if x.Op == SUB {
+				return lhs - rhs, nil
+			}
+			return lhs + rhs, nil
+		}
+	}
+	return 0, ErrInvalidTime(x)
+}
@@ -0,0 +1,267 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hooone/datacc/store/cache"
+)
+
+// 测试数据: key 12在两个文件和Cache中，key 13在一个文件中
+func newTestExecutor(t *testing.T) (*Executor, *testStore) {
+	s := newTestStore(t)
+	series := func(start, n int, value func(i int) byte) ([]int64, []byte) {
+		ts, values := make([]int64, n), make([]byte, n)
+		for i := range ts {
+			ts[i] = int64(start+i) * int64(time.Second)
+			values[i] = value(i)
+		}
+		return ts, values
+	}
+	ts, values := series(0, 10, func(i int) byte { return byte(i) })
+	s.writeFile(t, 12, ts, values)
+	ts, values = series(100, 10, func(i int) byte { return byte(10 + i) })
+	s.writeFile(t, 12, ts, values)
+	ts, values = series(0, 10, func(i int) byte { return 1 })
+	s.writeFile(t, 13, ts, values)
+
+	c := cache.NewCache(0)
+	ts, values = series(200, 5, func(i int) byte { return byte(1 + i) })
+	if err := c.Write(12, ts, values); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	now := time.Unix(205, 0)
+	return &Executor{Cache: c, FileStore: s.fs, Now: func() time.Time { return now }}, s
+}
+
+func checkRows(t *testing.T, q string, actual *Series, except [][]interface{}) {
+	if len(actual.Values) != len(except) {
+		t.Fatalf("%s: except %d rows, actual %d: %v", q, len(except), len(actual.Values), actual.Values)
+	}
+	for i, row := range except {
+		if len(actual.Values[i]) != len(row) {
+			t.Fatalf("%s: row %d except %v, actual %v", q, i, row, actual.Values[i])
+		}
+		for j := range row {
+			if actual.Values[i][j] != row[j] {
+				t.Fatalf("%s: row %d except %v, actual %v", q, i, row, actual.Values[i])
+			}
+		}
+	}
+}
+
+func TestExecutor_Select(t *testing.T) {
+	e, s := newTestExecutor(t)
+	defer s.Close()
+
+	sec := int64(time.Second)
+	tests := []struct {
+		q       string
+		columns []string
+		except  [][]interface{}
+	}{
+		{
+			"SELECT mean(value), count(value) AS n FROM 12 WHERE time >= 100s GROUP BY time(10s)",
+			[]string{"time", "mean", "n"},
+			[][]interface{}{{100 * sec, 14.5, 10.0}, {200 * sec, 3.0, 5.0}},
+		},
+		{
+			"SELECT max(value) FROM 12 WHERE time >= 100s AND time < 130s GROUP BY time(10s) FILL(0)",
+			[]string{"time", "max"},
+			[][]interface{}{{100 * sec, 19.0}, {110 * sec, 0.0}, {120 * sec, 0.0}},
+		},
+		{
+			"SELECT count(value) FROM 12 WHERE value >= 15",
+			[]string{"time", "count"},
+			[][]interface{}{{int64(0), 5.0}},
+		},
+		{
+			"SELECT count(value) FROM 12 WHERE time > now() - 5s",
+			[]string{"time", "count"},
+			[][]interface{}{{200*sec + 1, 4.0}},
+		},
+		{
+			"SELECT value FROM 12 LIMIT 3",
+			[]string{"time", "value"},
+			[][]interface{}{{int64(0), 0.0}, {sec, 1.0}, {2 * sec, 2.0}},
+		},
+		{
+			"SELECT value, max(value) FROM 12 WHERE time >= 8s AND time < 102s LIMIT 3",
+			[]string{"time", "value", "max"},
+			[][]interface{}{{8 * sec, 8.0, 11.0}, {9 * sec, 9.0, nil}, {100 * sec, 10.0, nil}},
+		},
+		{
+			"SELECT difference(value) FROM 12 WHERE time >= 8s AND time <= 100s",
+			[]string{"time", "difference"},
+			[][]interface{}{{9 * sec, 1.0}, {100 * sec, 1.0}},
+		},
+	}
+	for _, tt := range tests {
+		res, err := e.Execute(tt.q)
+		if err != nil {
+			t.Fatalf("%s: execute fail: %v", tt.q, err)
+		}
+		if len(res.Series) != 1 || res.Series[0].Name != "12" {
+			t.Fatalf("%s: series error: %v", tt.q, res.Series)
+		}
+		if got := res.Series[0].Columns; len(got) != len(tt.columns) || got[len(got)-1] != tt.columns[len(tt.columns)-1] {
+			t.Fatalf("%s: columns error: except %v, actual %v", tt.q, tt.columns, got)
+		}
+		checkRows(t, tt.q, res.Series[0], tt.except)
+	}
+
+	// 每个key一组结果
+	q := "SELECT sum(value) FROM 12,13 WHERE time < 10s"
+	res, err := e.Execute(q)
+	if err != nil {
+		t.Fatalf("%s: execute fail: %v", q, err)
+	}
+	if len(res.Series) != 2 || res.Series[1].Name != "13" {
+		t.Fatalf("%s: series error: %v", q, res.Series)
+	}
+	checkRows(t, q, res.Series[0], [][]interface{}{{int64(0), 45.0}})
+	checkRows(t, q, res.Series[1], [][]interface{}{{int64(0), 10.0}})
+
+	// 按上海时间的自然日分组，UTC 0点为上海8点
+	q = "SELECT count(value) FROM 12 GROUP BY calendar(day) TZ('Asia/Shanghai')"
+	if res, err = e.Execute(q); err != nil {
+		t.Fatalf("%s: execute fail: %v", q, err)
+	}
+	checkRows(t, q, res.Series[0], [][]interface{}{{-8 * int64(time.Hour), 25.0}})
+
+	// 引用$key时对齐后计算
+	q = "SELECT $12 - $13 AS diff FROM 12,13 WHERE time <= 2s AND $12 > 0"
+	if res, err = e.Execute(q); err != nil {
+		t.Fatalf("%s: execute fail: %v", q, err)
+	}
+	if len(res.Series) != 1 || res.Series[0].Name != "12,13" {
+		t.Fatalf("%s: series error: %v", q, res.Series)
+	}
+	checkRows(t, q, res.Series[0], [][]interface{}{{sec, 0.0}, {2 * sec, 1.0}})
+
+	for _, q := range []string{
+		"SELECT median(value) FROM 12",
+		"SELECT mean(time) FROM 12",
+		"SELECT percentile(value) FROM 12",
+		"SELECT mean(value) FROM 12 WHERE time > 1s OR value > 2",
+		"SELECT mean(value) FROM 12 WHERE time > 'yesterday'",
+		"SELECT $12 + value FROM 12",
+	} {
+		if _, err := e.Execute(q); err == nil {
+			t.Fatalf("expected %q fail", q)
+		}
+	}
+}
+
+// EXPLAIN按文件的key范围和时间范围跳过文件，列出读取的block和Cache数据
+func TestExecutor_Explain(t *testing.T) {
+	e, s := newTestExecutor(t)
+	defer s.Close()
+
+	res, err := e.Execute("EXPLAIN SELECT mean(value) FROM 12 WHERE time >= 100s GROUP BY time(10s)")
+	if err != nil {
+		t.Fatalf("explain fail: %v", err)
+	}
+	rows := res.Series[0].Values
+	var sources, skips []string
+	for _, row := range rows {
+		if row[0] != uint32(12) {
+			t.Fatalf("explain key error: %v", row)
+		}
+		sources = append(sources, row[1].(string))
+		skips = append(skips, row[8].(string))
+	}
+	exceptSources := []string{"file", "file", "block", "file", "cache"}
+	exceptSkips := []string{SkipTimeRange, "", "", SkipKeyRange, ""}
+	if len(sources) != len(exceptSources) {
+		t.Fatalf("explain rows error: %v", rows)
+	}
+	for i := range sources {
+		if sources[i] != exceptSources[i] || skips[i] != exceptSkips[i] {
+			t.Fatalf("explain row %d error: %v", i, rows[i])
+		}
+	}
+	if block := rows[2]; block[3] != 100*int64(time.Second) || block[7] != uint32(10) {
+		t.Fatalf("explain block error: %v", block)
+	}
+	if cached := rows[4]; cached[7] != 5 {
+		t.Fatalf("explain cache error: %v", cached)
+	}
+}
+
+// 按行数停止的ResultWriter
+type limitWriter struct {
+	rows, max int
+}
+
+func (w *limitWriter) BeginSeries(name string, columns []string) error { return nil }
+
+func (w *limitWriter) WriteRow(row []interface{}) error {
+	if w.rows++; w.rows > w.max {
+		return errStop
+	}
+	return nil
+}
+
+// 纳秒时间戳按整数比较，float64无法精确表示的边界不会被舍入
+func TestExecutor_NanosecondTime(t *testing.T) {
+	e, s := newTestExecutor(t)
+	defer s.Close()
+
+	const ts = int64(1700000000123456789)
+	if int64(float64(ts)) == ts {
+		t.Fatalf("boundary %d is representable as float64", ts)
+	}
+	if err := e.Cache.Write(14, []int64{ts - 1, ts, ts + 1}, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	tests := []struct {
+		q      string
+		except [][]interface{}
+	}{
+		{"SELECT value FROM 14 WHERE time >= 1700000000123456789", [][]interface{}{{ts, 2.0}, {ts + 1, 3.0}}},
+		{"SELECT value FROM 14 WHERE time < 1700000000123456789", [][]interface{}{{ts - 1, 1.0}}},
+		{"SELECT value FROM 14 WHERE time = 1700000000123456789", [][]interface{}{{ts, 2.0}}},
+	}
+	for _, tt := range tests {
+		res, err := e.Execute(tt.q)
+		if err != nil {
+			t.Fatalf("%s: execute fail: %v", tt.q, err)
+		}
+		checkRows(t, tt.q, res.Series[0], tt.except)
+	}
+}
+
+var errStop = fmt.Errorf("stop")
+
+func TestExecutor_ExecuteStream(t *testing.T) {
+	e, s := newTestExecutor(t)
+	defer s.Close()
+
+	stmt, err := ParseStatement("SELECT value FROM 12, 13")
+	if err != nil {
+		t.Fatalf("parse fail: %v", err)
+	}
+	w := &limitWriter{max: 3}
+	if err := e.ExecuteStream(context.Background(), stmt, w); err != errStop || w.rows != 4 {
+		t.Fatalf("writer error not returned: %v, rows %d", err, w.rows)
+	}
+
+	// 原始值和聚合混合时同样逐行输出
+	if stmt, err = ParseStatement("SELECT value, max(value) FROM 12"); err != nil {
+		t.Fatalf("parse fail: %v", err)
+	}
+	w = &limitWriter{max: 3}
+	if err := e.ExecuteStream(context.Background(), stmt, w); err != errStop || w.rows != 4 {
+		t.Fatalf("writer error not returned: %v, rows %d", err, w.rows)
+	}
+
+	// 取消后游标停止读取
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.ExecuteStream(ctx, stmt, &limitWriter{max: 100}); err != context.Canceled {
+		t.Fatalf("except context canceled, actual %v", err)
+	}
+}
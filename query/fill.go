@@ -0,0 +1,79 @@
+package query
+
+import "fmt"
+
+// 空窗口的填充方式
+type FillMode int
+
+const (
+	// 不输出空窗口
+	FillNone FillMode = iota
+	// 空窗口输出空值
+	FillNull
+	// 使用前一个窗口的值
+	FillPrevious
+	// 使用前后窗口的值线性插值
+	FillLinear
+	// 使用固定值
+	FillConstant
+)
+
+func (m FillMode) String() string {
+	switch m {
+	case FillNone:
+		return "none"
+	case FillNull:
+		return "null"
+	case FillPrevious:
+		return "previous"
+	case FillLinear:
+		return "linear"
+	case FillConstant:
+		return "constant"
+	}
+	return fmt.Sprintf("unknown(%d)", int(m))
+}
+
+// 填充空窗口。points按时间升序，包含所有窗口
+func fill(points []Point, mode FillMode, value float64) []Point {
+	switch mode {
+	case FillNone:
+		out := points[:0]
+		for _, p := range points {
+			if !p.Nil {
+				out = append(out, p)
+			}
+		}
+		return out
+	case FillPrevious:
+		for i := 1; i < len(points); i++ {
+			if points[i].Nil && !points[i-1].Nil {
+				points[i].Value, points[i].Nil = points[i-1].Value, false
+			}
+		}
+	case FillLinear:
+		prev := -1
+		for i := range points {
+			if points[i].Nil {
+				continue
+			}
+			// 在前后两个有值的窗口之间插值，两端的空窗口保持为空
+			if prev >= 0 && i-prev > 1 {
+				p0, p1 := points[prev], points[i]
+				slope := (p1.Value - p0.Value) / float64(p1.Time-p0.Time)
+				for j := prev + 1; j < i; j++ {
+					points[j].Value = p0.Value + slope*float64(points[j].Time-p0.Time)
+					points[j].Nil = false
+				}
+			}
+			prev = i
+		}
+	case FillConstant:
+		for i := range points {
+			if points[i].Nil {
+				points[i].Value, points[i].Nil = value, false
+			}
+		}
+	}
+	return points
+}
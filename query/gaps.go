@@ -0,0 +1,181 @@
+package query
+
+import (
+	"math"
+
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+const (
+	// 默认的间隔容差，相邻两点间隔超过期望间隔的该倍数时视为缺失
+	DefaultGapTolerance = 1.5
+	// 推断采样间隔时最多读取的block数
+	maxInferBlocks = 64
+)
+
+// 缺失检测参数
+type GapOptions struct {
+	// 统计完整度的时间窗口
+	Window Window
+	// 期望的采样间隔，为0时从时间戳差值中推断
+	Interval int64
+	// 间隔容差，默认为DefaultGapTolerance
+	Tolerance float64
+}
+
+// 缺失的区间，即前后两个点的时间。在查询范围的边界处为查询的起止时间
+type Gap struct {
+	Start, End int64
+}
+
+// 一个窗口的数据完整度
+type Completeness struct {
+	// 窗口起点
+	Time int64
+	// 期望的点数和实际的点数
+	Expected int64
+	Actual   int64
+	// 完整度百分比，最大为100
+	Percent float64
+}
+
+// 缺失检测结果
+type GapReport struct {
+	// 使用的采样间隔
+	Interval int64
+	Gaps     []Gap
+	Windows  []Completeness
+}
+
+// DetectGaps 按期望的采样间隔找出缺失的区间，并统计每个窗口的完整度
+func DetectGaps(cur *Cursor, opt GapOptions) (*GapReport, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	interval := opt.Interval
+	if interval <= 0 {
+		var err error
+		if interval, err = cur.inferInterval(); err != nil {
+			return nil, err
+		}
+	}
+	tolerance := opt.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultGapTolerance
+	}
+	threshold := int64(float64(interval) * tolerance)
+	w := opt.Window
+
+	report := &GapReport{Interval: interval}
+	counts := make(map[int64]int64)
+	first, last := int64(0), int64(0)
+	started := false
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			break
+		}
+		for _, v := range values {
+			if !started {
+				first, started = v.UnixNano, true
+			} else if v.UnixNano-last > threshold {
+				report.Gaps = append(report.Gaps, Gap{Start: last, End: v.UnixNano})
+			}
+			last = v.UnixNano
+			counts[w.Start(v.UnixNano)]++
+		}
+	}
+
+	// 查询范围的边界，无界时以数据为界
+	min, max := cur.opt.Min, cur.opt.Max
+	if !started {
+		if min != math.MinInt64 && max != math.MaxInt64 {
+			report.Gaps = append(report.Gaps, Gap{Start: min, End: max})
+		}
+		return report, nil
+	}
+	if min == math.MinInt64 {
+		min = first
+	} else if first-min > threshold {
+		report.Gaps = append([]Gap{{Start: min, End: first}}, report.Gaps...)
+	}
+	if max == math.MaxInt64 {
+		max = last
+	} else if max-last > threshold {
+		report.Gaps = append(report.Gaps, Gap{Start: last, End: max})
+	}
+
+	// 每个窗口的完整度
+	if n := w.Count(min, max); n > MaxWindows {
+		return nil, ErrTooManyWindows(n, MaxWindows)
+	}
+	for start := w.Start(min); ; start = w.Next(start) {
+		end := w.Next(start)
+		// 窗口与查询范围的重叠部分
+		lo, hi := start, end
+		if lo < min {
+			lo = min
+		}
+		if hi > max || hi == math.MaxInt64 {
+			hi = max + 1
+		}
+		expected := (hi - lo) / interval
+		if expected < 1 {
+			expected = 1
+		}
+		c := Completeness{Time: windowTime(w, start, cur.opt.Min), Expected: expected, Actual: counts[start]}
+		c.Percent = math.Min(100, float64(c.Actual)/float64(c.Expected)*100)
+		report.Windows = append(report.Windows, c)
+		if end > max {
+			break
+		}
+	}
+	return report, nil
+}
+
+// 从时间戳差值中推断采样间隔，取出现次数最多的差值。
+// 文件中的block只读取时间戳部分，直接使用编码时的差值
+func (c *Cursor) inferInterval() (int64, error) {
+	counts := make(map[int64]int)
+	var deltas []int64
+	for i, b := range c.blocks {
+		if i >= maxInferBlocks {
+			break
+		}
+		data, err := b.loc.Reader.ReadBlock(c.opt.Key, &b.loc.Entry)
+		if err != nil {
+			return 0, err
+		}
+		tb, _, err := lsm.UnpackBlock(data)
+		if err != nil {
+			return 0, err
+		}
+		if deltas, err = coder.DecodeTimeDeltas(deltas[:0], tb); err != nil {
+			return 0, err
+		}
+		for _, d := range deltas {
+			counts[d]++
+		}
+	}
+	for _, r := range c.runs {
+		for i := 1; i < len(r.values); i++ {
+			counts[r.values[i].UnixNano-r.values[i-1].UnixNano]++
+		}
+	}
+
+	var interval int64
+	n := 0
+	for d, c := range counts {
+		if d > 0 && (c > n || (c == n && d < interval)) {
+			interval, n = d, c
+		}
+	}
+	if interval == 0 {
+		return 0, ErrUnknownInterval
+	}
+	return interval, nil
+}
@@ -0,0 +1,55 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hooone/datacc/store/cache"
+)
+
+func TestDetectGaps(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	var ts []int64
+	var values []byte
+	for i := 0; i < 100; i++ {
+		ts, values = append(ts, int64(i*10)), append(values, 1)
+	}
+	s.writeFile(t, 1, ts, values)
+	c := cache.NewCache(0)
+	ts, values = nil, nil
+	for i := 0; i < 50; i++ {
+		ts, values = append(ts, int64(1500+i*10)), append(values, 1)
+	}
+	if err := c.Write(1, ts, values); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+
+	// 推断采样间隔
+	cur := NewCursor(c, s.fs, CursorOptions{Key: 1, Min: 0, Max: 2999, Ascending: true})
+	report, err := DetectGaps(cur, GapOptions{Window: Window{Interval: 1000}})
+	if err != nil {
+		t.Fatalf("detect gaps fail: %v", err)
+	}
+	if report.Interval != 10 {
+		t.Fatalf("interval error: except 10, actual %d", report.Interval)
+	}
+	if except := []Gap{{990, 1500}, {1990, 2999}}; !reflect.DeepEqual(report.Gaps, except) {
+		t.Fatalf("gaps error: except %v, actual %v", except, report.Gaps)
+	}
+	except := []Completeness{{0, 100, 100, 100}, {1000, 100, 50, 50}, {2000, 100, 0, 0}}
+	if !reflect.DeepEqual(report.Windows, except) {
+		t.Fatalf("completeness error: except %v, actual %v", except, report.Windows)
+	}
+
+	// 指定较大的采样间隔时没有缺失
+	cur = NewCursor(c, s.fs, CursorOptions{Key: 1, Min: 0, Max: 1999, Ascending: true})
+	report, err = DetectGaps(cur, GapOptions{Interval: 500})
+	if err != nil {
+		t.Fatalf("detect gaps fail: %v", err)
+	}
+	if len(report.Gaps) != 0 || len(report.Windows) != 1 || report.Windows[0].Percent != 100 {
+		t.Fatalf("report error: %+v", report)
+	}
+}
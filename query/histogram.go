@@ -0,0 +1,244 @@
+package query
+
+import (
+	"math"
+)
+
+// 一个窗口内256个值的分布
+type Histogram struct {
+	// 窗口起点
+	Time int64
+	// 每个值的点数，按时间加权时为持续时间
+	Counts [256]int64
+}
+
+// 总点数或总时间
+func (h *Histogram) Total() int64 {
+	var n int64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Percentile 第p百分位的值，按最近秩计算。p的范围为[0, 100]
+func (h *Histogram) Percentile(p float64) (byte, bool) {
+	total := h.Total()
+	if total == 0 {
+		return 0, false
+	}
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for v, c := range h.Counts {
+		n += c
+		if n >= rank {
+			return byte(v), true
+		}
+	}
+	return 255, true
+}
+
+// Mode 出现次数最多(或持续时间最长)的值，相同时取较小的值
+func (h *Histogram) Mode() (byte, bool) {
+	var mode byte
+	var max int64
+	for v, c := range h.Counts {
+		if c > max {
+			mode, max = byte(v), c
+		}
+	}
+	return mode, max > 0
+}
+
+// 直方图参数
+type HistogramOptions struct {
+	Window Window
+	// 按每个值的持续时间加权，每个点的值持续到下一个点，最后一个点持续到查询的结束时间
+	TimeWeighted bool
+}
+
+// Histograms 统计每个窗口内的值分布，只输出有数据的窗口。
+// 不与其他数据重叠的RLE编码block按段累加，不展开数据
+func Histograms(cur *Cursor, opt HistogramOptions) ([]Histogram, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	h := &histWindows{w: opt.Window, min: cur.opt.Min}
+	s := &stepFunc{h: h}
+
+	// RLE数据段
+	var runErr error
+	cur.SetRunHandler(func(r *Run) bool {
+		// 按时间加权时，值不变的数据段等同于其第一个点
+		if opt.TimeWeighted && r.Delta == 0 {
+			s.add(r.MinTime, r.Value)
+			return true
+		}
+		// 按点数统计时，在一个窗口内的数据段直接累加
+		if !opt.TimeWeighted && h.w.Start(r.MinTime) == h.w.Start(r.MaxTime) {
+			h.window(r.MinTime)
+			addRun(&h.cur.Counts, r.Value, r.Delta, r.Count)
+			return true
+		}
+		times, err := r.Times()
+		if err != nil {
+			runErr = err
+			return true
+		}
+		for i, t := range times {
+			if opt.TimeWeighted {
+				s.add(t, r.At(i))
+			} else {
+				h.window(t)
+				h.cur.Counts[r.At(i)]++
+			}
+		}
+		return true
+	})
+
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if runErr != nil {
+			return nil, runErr
+		}
+		if len(values) == 0 {
+			break
+		}
+		for _, v := range values {
+			if opt.TimeWeighted {
+				s.add(v.UnixNano, v.Value)
+			} else {
+				h.window(v.UnixNano)
+				h.cur.Counts[v.Value]++
+			}
+		}
+	}
+	if opt.TimeWeighted && cur.opt.Max != math.MaxInt64 {
+		s.end(cur.opt.Max + 1)
+	}
+	h.flush()
+	return h.out, nil
+}
+
+// Percentile 每个窗口内第p百分位的值
+func Percentile(cur *Cursor, opt HistogramOptions, p float64) ([]Point, error) {
+	if p < 0 || p > 100 {
+		return nil, ErrInvalidPercentile
+	}
+	return histogramPoints(cur, opt, func(h *Histogram) (byte, bool) { return h.Percentile(p) })
+}
+
+// Mode 每个窗口内出现次数最多的值
+func Mode(cur *Cursor, opt HistogramOptions) ([]Point, error) {
+	return histogramPoints(cur, opt, (*Histogram).Mode)
+}
+
+func histogramPoints(cur *Cursor, opt HistogramOptions, fn func(h *Histogram) (byte, bool)) ([]Point, error) {
+	hists, err := Histograms(cur, opt)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, 0, len(hists))
+	for i := range hists {
+		v, ok := fn(&hists[i])
+		points = append(points, Point{Time: hists[i].Time, Value: float64(v), Nil: !ok})
+	}
+	return points, nil
+}
+
+// 不展开地累加等差数据段: 第i个值为first+i*delta，共count个。
+// 值按256取模，以256/gcd(delta, 256)为周期循环
+func addRun(counts *[256]int64, first, delta byte, count int) {
+	period := 256
+	if delta == 0 {
+		period = 1
+	} else {
+		for d := delta; d%2 == 0; d /= 2 {
+			period /= 2
+		}
+	}
+	full, rem := count/period, count%period
+	v := first
+	for i := 0; i < period; i++ {
+		n := int64(full)
+		if i < rem {
+			n++
+		}
+		counts[v] += n
+		v += delta
+	}
+}
+
+// 按窗口累计直方图
+type histWindows struct {
+	w   Window
+	min int64
+
+	// 当前窗口
+	start, end int64
+	active     bool
+	cur        Histogram
+
+	out []Histogram
+}
+
+// 切换到t所在的窗口
+func (h *histWindows) window(t int64) {
+	if h.active && t < h.end {
+		return
+	}
+	h.flush()
+	h.start = h.w.Start(t)
+	h.end = h.w.Next(h.start)
+	h.cur = Histogram{Time: windowTime(h.w, h.start, h.min)}
+	h.active = true
+}
+
+// 累计值v在[from, to)内的持续时间，跨窗口时拆分
+func (h *histWindows) addSpan(v byte, from, to int64) {
+	for from < to {
+		h.window(from)
+		seg := to
+		if seg > h.end {
+			seg = h.end
+		}
+		h.cur.Counts[v] += seg - from
+		from = seg
+	}
+}
+
+func (h *histWindows) flush() {
+	if !h.active {
+		return
+	}
+	h.out = append(h.out, h.cur)
+	h.active = false
+}
+
+// 阶梯函数: 每个点的值持续到下一个点，第一个点之前的状态未知
+type stepFunc struct {
+	h        *histWindows
+	prev     byte
+	prevTime int64
+	started  bool
+}
+
+func (s *stepFunc) add(t int64, v byte) {
+	if s.started {
+		s.h.addSpan(s.prev, s.prevTime, t)
+	}
+	s.prev, s.prevTime, s.started = v, t, true
+}
+
+// 最后一个点持续到end
+func (s *stepFunc) end(end int64) {
+	if s.started {
+		s.h.addSpan(s.prev, s.prevTime, end)
+	}
+}
@@ -0,0 +1,99 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/hooone/datacc/store/cache"
+)
+
+// 不展开累加与逐个累加结果一致
+func TestAddRun(t *testing.T) {
+	for _, delta := range []byte{0, 1, 6, 128, 255} {
+		var except, actual [256]int64
+		v := byte(200)
+		for i := 0; i < 1000; i++ {
+			except[v]++
+			v += delta
+		}
+		addRun(&actual, 200, delta, 1000)
+		if except != actual {
+			t.Fatalf("add run error. delta %d", delta)
+		}
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+
+	// 值不变的RLE block
+	var ts []int64
+	var values []byte
+	for i := 0; i < 100; i++ {
+		ts, values = append(ts, int64(i*10)), append(values, 5)
+	}
+	s.writeFile(t, 1, ts, values)
+	// 等差的RLE block
+	ts, values = nil, nil
+	for i := 0; i < 10; i++ {
+		ts, values = append(ts, int64(1000+i*10)), append(values, byte(i*3))
+	}
+	s.writeFile(t, 1, ts, values)
+	c := cache.NewCache(0)
+	if err := c.Write(1, []int64{2000, 2010}, []byte{9, 9}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	newCursor := func() *Cursor {
+		return NewCursor(c, s.fs, CursorOptions{Key: 1, Min: 0, Max: 2999, Ascending: true})
+	}
+	w := Window{Interval: 1000}
+
+	// 按点数
+	hists, err := Histograms(newCursor(), HistogramOptions{Window: w})
+	if err != nil {
+		t.Fatalf("histograms fail: %v", err)
+	}
+	if len(hists) != 3 {
+		t.Fatalf("histograms count error: %d", len(hists))
+	}
+	if hists[0].Counts[5] != 100 || hists[0].Total() != 100 {
+		t.Fatalf("histogram 0 error: %d", hists[0].Counts[5])
+	}
+	for i := 0; i < 10; i++ {
+		if hists[1].Counts[i*3] != 1 {
+			t.Fatalf("histogram 1 error. value %d: %d", i*3, hists[1].Counts[i*3])
+		}
+	}
+	if hists[2].Time != 2000 || hists[2].Counts[9] != 2 {
+		t.Fatalf("histogram 2 error: %d", hists[2].Counts[9])
+	}
+	// RLE block不经过解码
+	if stats := s.fs.Statistics(); stats.BlockCacheMisses != 0 {
+		t.Fatalf("expected run blocks not decoded: %+v", stats)
+	}
+
+	// 按时间加权
+	hists, err = Histograms(newCursor(), HistogramOptions{Window: w, TimeWeighted: true})
+	if err != nil {
+		t.Fatalf("histograms fail: %v", err)
+	}
+	if hists[0].Counts[5] != 1000 || hists[1].Counts[0] != 10 || hists[1].Counts[27] != 910 || hists[2].Counts[9] != 1000 {
+		t.Fatalf("time weighted histograms error: %d %d %d %d", hists[0].Counts[5], hists[1].Counts[0], hists[1].Counts[27], hists[2].Counts[9])
+	}
+
+	points, err := Percentile(newCursor(), HistogramOptions{Window: w}, 50)
+	if err != nil {
+		t.Fatalf("percentile fail: %v", err)
+	}
+	checkPoints(t, "percentile", points, []int64{0, 1000, 2000}, []interface{}{5.0, 12.0, 9.0})
+
+	points, err = Mode(newCursor(), HistogramOptions{Window: w, TimeWeighted: true})
+	if err != nil {
+		t.Fatalf("mode fail: %v", err)
+	}
+	checkPoints(t, "mode", points, []int64{0, 1000, 2000}, []interface{}{5.0, 27.0, 9.0})
+
+	if _, err := Percentile(newCursor(), HistogramOptions{}, 101); err != ErrInvalidPercentile {
+		t.Fatalf("expected invalid percentile error, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+const eof = rune(0)
+
+// 词法扫描器
+type Scanner struct {
+	r *bufio.Reader
+	// 当前位置
+	pos int
+}
+
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{r: bufio.NewReader(r)}
+}
+
+// Scan 返回下一个词法单元、其位置和原文
+func (s *Scanner) Scan() (tok Token, pos int, lit string) {
+	pos = s.pos
+	ch := s.read()
+
+	switch {
+	case isWhitespace(ch):
+		s.unread()
+		return s.scanWhitespace()
+	case isLetter(ch):
+		s.unread()
+		return s.scanIdent()
+	case isDigit(ch):
+		s.unread()
+		tok, pos, lit := s.scanNumber()
+		// 数字后紧跟单位时为时间长度
+		if isLetter(s.peek()) && !strings.Contains(lit, ".") {
+			_, _, unit := s.scanIdent()
+			return DURATION, pos, lit + unit
+		}
+		return tok, pos, lit
+	case ch == '\'':
+		return s.scanString()
+	case ch == '.':
+		if isDigit(s.peek()) {
+			s.unread()
+			return s.scanNumber()
+		}
+		return ILLEGAL, pos, string(ch)
+	case ch == '$':
+		_, _, lit := s.scanNumber()
+		if lit == "" || strings.Contains(lit, ".") {
+			return ILLEGAL, pos, "$" + lit
+		}
+		return REF, pos, lit
+	}
+
+	switch ch {
+	case eof:
+		return EOF, pos, ""
+	case '+':
+		return ADD, pos, ""
+	case '-':
+		return SUB, pos, ""
+	case '*':
+		return MUL, pos, ""
+	case '/':
+		return DIV, pos, ""
+	case '%':
+		return MOD, pos, ""
+	case '(':
+		return LPAREN, pos, ""
+	case ')':
+		return RPAREN, pos, ""
+	case ',':
+		return COMMA, pos, ""
+	case '&':
+		if s.read() == '&' {
+			return AND, pos, ""
+		}
+		s.unread()
+	case '|':
+		if s.read() == '|' {
+			return OR, pos, ""
+		}
+		s.unread()
+	case '=':
+		if s.read() != '=' {
+			s.unread()
+		}
+		return EQ, pos, ""
+	case '!':
+		if s.read() == '=' {
+			return NEQ, pos, ""
+		}
+		s.unread()
+		return NOT, pos, ""
+	case '<':
+		if s.read() == '=' {
+			return LTE, pos, ""
+		}
+		s.unread()
+		return LT, pos, ""
+	case '>':
+		if s.read() == '=' {
+			return GTE, pos, ""
+		}
+		s.unread()
+		return GT, pos, ""
+	}
+	return ILLEGAL, pos, string(ch)
+}
+
+func (s *Scanner) scanWhitespace() (Token, int, string) {
+	pos := s.pos
+	var buf bytes.Buffer
+	for ch := s.read(); ch != eof; ch = s.read() {
+		if !isWhitespace(ch) {
+			s.unread()
+			break
+		}
+		buf.WriteRune(ch)
+	}
+	return WS, pos, buf.String()
+}
+
+// 标识符或关键字
+func (s *Scanner) scanIdent() (Token, int, string) {
+	pos := s.pos
+	var buf bytes.Buffer
+	for ch := s.read(); ch != eof; ch = s.read() {
+		if !isLetter(ch) && !isDigit(ch) && ch != '_' {
+			s.unread()
+			break
+		}
+		buf.WriteRune(ch)
+	}
+	lit := buf.String()
+	if tok, ok := keywords[strings.ToUpper(lit)]; ok {
+		return tok, pos, lit
+	}
+	return IDENT, pos, lit
+}
+
+// 单引号包围的字符串，单引号用\'转义
+func (s *Scanner) scanString() (Token, int, string) {
+	pos := s.pos - 1
+	var buf bytes.Buffer
+	for {
+		ch := s.read()
+		switch ch {
+		case eof:
+			return ILLEGAL, pos, buf.String()
+		case '\'':
+			return STRING, pos, buf.String()
+		case '\\':
+			if next := s.read(); next == '\'' || next == '\\' {
+				buf.WriteRune(next)
+			} else {
+				buf.WriteRune(ch)
+				s.unread()
+			}
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+}
+
+// 数字，可以带小数点
+func (s *Scanner) scanNumber() (Token, int, string) {
+	pos := s.pos
+	var buf bytes.Buffer
+	dot := false
+	for ch := s.read(); ch != eof; ch = s.read() {
+		if ch == '.' && !dot {
+			dot = true
+		} else if !isDigit(ch) {
+			s.unread()
+			break
+		}
+		buf.WriteRune(ch)
+	}
+	return NUMBER, pos, buf.String()
+}
+
+func (s *Scanner) read() rune {
+	ch, _, err := s.r.ReadRune()
+	if err != nil {
+		// 读到结尾时也前进一位，使unread对称
+		s.pos++
+		return eof
+	}
+	s.pos++
+	return ch
+}
+
+func (s *Scanner) unread() {
+	s.pos--
+	_ = s.r.UnreadRune()
+}
+
+func (s *Scanner) peek() rune {
+	ch := s.read()
+	s.unread()
+	return ch
+}
+
+func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+func isLetter(ch rune) bool     { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' }
+func isDigit(ch rune) bool      { return ch >= '0' && ch <= '9' }
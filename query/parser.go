@@ -0,0 +1,495 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 语法解析器
+type Parser struct {
+	s *Scanner
+	// 回退缓存
+	buf []scanned
+	// 最近读取的词法单元
+	last scanned
+}
+
+type scanned struct {
+	tok Token
+	pos int
+	lit string
+}
+
+func NewParser(r io.Reader) *Parser {
+	return &Parser{s: NewScanner(r)}
+}
+
+// ParseExpr 解析表达式字符串
+func ParseExpr(s string) (Expr, error) {
+	p := NewParser(strings.NewReader(s))
+	e, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	}
+	return e, nil
+}
+
+// ParseExpr 按运算符优先级解析表达式
+func (p *Parser) ParseExpr() (Expr, error) {
+	return p.parseBinary(1)
+}
+
+// 解析优先级不低于prec的二元运算
+func (p *Parser) parseBinary(prec int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, _, _ := p.scanIgnoreWhitespace()
+		if op.Precedence() < prec {
+			p.unscan()
+			return lhs, nil
+		}
+		// 左结合: 右侧只解析优先级更高的运算
+		rhs, err := p.parseBinary(op.Precedence() + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *Parser) parseUnary() (Expr, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case SUB, NOT:
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: tok, X: x}, nil
+	case LPAREN:
+		e, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+			return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+		}
+		return &ParenExpr{Expr: e}, nil
+	case NUMBER:
+		v, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse number", Pos: pos}
+		}
+		return &NumberLiteral{Val: v, Raw: lit}, nil
+	case REF:
+		return &ColumnRef{Name: lit}, nil
+	case DURATION:
+		d, ok := parseDuration(lit)
+		if !ok {
+			return nil, &ParseError{Message: "invalid duration " + lit, Pos: pos}
+		}
+		return &DurationLiteral{Val: d}, nil
+	case STRING:
+		return &StringLiteral{Val: lit}, nil
+	case IDENT:
+		if next, _, _ := p.scan(); next != LPAREN {
+			p.unscan()
+			return &VarRef{Name: lit}, nil
+		}
+		return p.parseCall(lit)
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"number", "$key", "identifier", "("}, pos)
+}
+
+// 解析函数调用的参数，左括号已读取
+func (p *Parser) parseCall(name string) (Expr, error) {
+	call := &Call{Name: name}
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == RPAREN {
+		return call, nil
+	}
+	p.unscan()
+	for {
+		arg, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		switch tok {
+		case COMMA:
+			continue
+		case RPAREN:
+			return call, nil
+		}
+		return nil, newParseError(tokstr(tok, lit), []string{",", ")"}, pos)
+	}
+}
+
+// ParseStatement 解析查询语句字符串
+func ParseStatement(s string) (Statement, error) {
+	p := NewParser(strings.NewReader(s))
+	stmt, err := p.ParseStatement()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != EOF {
+		return nil, newParseError(tokstr(tok, lit), []string{"EOF"}, pos)
+	}
+	return stmt, nil
+}
+
+// ParseStatement 解析SELECT或EXPLAIN SELECT语句
+func (p *Parser) ParseStatement() (Statement, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case SELECT:
+		return p.parseSelectStatement()
+	case EXPLAIN:
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+			return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+		}
+		stmt, err := p.parseSelectStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &ExplainStatement{Statement: stmt}, nil
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"SELECT", "EXPLAIN"}, pos)
+}
+
+// 解析SELECT之后的部分
+func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
+	stmt := &SelectStatement{}
+	var err error
+	if stmt.Fields, err = p.parseFields(); err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != FROM {
+		return nil, newParseError(tokstr(tok, lit), []string{"FROM"}, pos)
+	}
+	if stmt.Sources, err = p.parseSources(); err != nil {
+		return nil, err
+	}
+
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == WHERE {
+		if stmt.Condition, err = p.ParseExpr(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == GROUP {
+		if err := p.parseGroupBy(stmt); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FILL {
+		if err := p.parseFill(stmt); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == LIMIT {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		n, err := strconv.Atoi(lit)
+		if tok != NUMBER || err != nil || n < 0 {
+			return nil, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+		}
+		stmt.Limit = n
+	} else {
+		p.unscan()
+	}
+
+	if tok, pos, _ := p.scanIgnoreWhitespace(); tok == TZ {
+		if err := p.parseTimeZone(stmt); err != nil {
+			return nil, err
+		}
+		if stmt.Calendar == CalendarNone && len(stmt.Shifts) == 0 {
+			return nil, &ParseError{Message: "TZ() requires GROUP BY calendar() or shift()", Pos: pos}
+		}
+	} else {
+		p.unscan()
+	}
+	return stmt, nil
+}
+
+// 解析逗号分隔的输出字段，字段可带AS别名
+func (p *Parser) parseFields() ([]*Field, error) {
+	var fields []*Field
+	for {
+		e, err := p.ParseExpr()
+		if err != nil {
+			return nil, err
+		}
+		f := &Field{Expr: e}
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == AS {
+			tok, pos, lit := p.scanIgnoreWhitespace()
+			if tok != IDENT {
+				return nil, newParseError(tokstr(tok, lit), []string{"identifier"}, pos)
+			}
+			f.Alias = lit
+		} else {
+			p.unscan()
+		}
+		fields = append(fields, f)
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return fields, nil
+		}
+	}
+}
+
+// 解析逗号分隔的key
+func (p *Parser) parseSources() ([]uint32, error) {
+	var keys []uint32
+	for {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		key, err := strconv.ParseUint(lit, 10, 32)
+		if tok != NUMBER || err != nil {
+			return nil, newParseError(tokstr(tok, lit), []string{"key"}, pos)
+		}
+		keys = append(keys, uint32(key))
+
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok != COMMA {
+			p.unscan()
+			return keys, nil
+		}
+	}
+}
+
+// 解析GROUP BY time(interval[, offset])、calendar(day|week|month)或shift('06:00', ...)，GROUP已读取
+func (p *Parser) parseGroupBy(stmt *SelectStatement) error {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != BY {
+		return newParseError(tokstr(tok, lit), []string{"BY"}, pos)
+	}
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	name := strings.ToLower(lit)
+	if tok != IDENT || (name != "time" && name != "calendar" && name != "shift") {
+		return newParseError(tokstr(tok, lit), []string{"time", "calendar", "shift"}, pos)
+	}
+	if tok, pos, lit := p.scan(); tok != LPAREN {
+		return newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	switch name {
+	case "calendar":
+		return p.parseCalendar(stmt)
+	case "shift":
+		return p.parseShifts(stmt)
+	}
+	e, err := p.ParseExpr()
+	if err != nil {
+		return err
+	}
+	interval, ok := durationValue(e)
+	if !ok || interval <= 0 {
+		return &ParseError{Message: "GROUP BY time() interval must be a positive duration", Pos: p.last.pos}
+	}
+	stmt.Interval = interval
+
+	tok, pos, lit = p.scanIgnoreWhitespace()
+	if tok == COMMA {
+		e, err := p.ParseExpr()
+		if err != nil {
+			return err
+		}
+		offset, ok := durationValue(e)
+		if !ok {
+			return &ParseError{Message: "GROUP BY time() offset must be a duration", Pos: pos}
+		}
+		stmt.Offset = offset
+		tok, pos, lit = p.scanIgnoreWhitespace()
+	}
+	if tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{",", ")"}, pos)
+	}
+	return nil
+}
+
+// 解析calendar(day|week|month)的参数，左括号已读取
+func (p *Parser) parseCalendar(stmt *SelectStatement) error {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	switch strings.ToLower(lit) {
+	case "day":
+		stmt.Calendar = CalendarDay
+	case "week":
+		stmt.Calendar = CalendarWeek
+	case "month":
+		stmt.Calendar = CalendarMonth
+	default:
+		return newParseError(tokstr(tok, lit), []string{"day", "week", "month"}, pos)
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return nil
+}
+
+// 解析shift('06:00', '14:00', ...)的参数，左括号已读取。班次按时刻排序
+func (p *Parser) parseShifts(stmt *SelectStatement) error {
+	for {
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok != STRING {
+			return newParseError(tokstr(tok, lit), []string{"'15:04'"}, pos)
+		}
+		shift, ok := parseClock(lit)
+		if !ok {
+			return &ParseError{Message: "invalid shift time " + lit, Pos: pos}
+		}
+		stmt.Shifts = append(stmt.Shifts, shift)
+
+		tok, pos, lit = p.scanIgnoreWhitespace()
+		if tok == RPAREN {
+			break
+		} else if tok != COMMA {
+			return newParseError(tokstr(tok, lit), []string{",", ")"}, pos)
+		}
+	}
+	sort.Slice(stmt.Shifts, func(i, j int) bool { return stmt.Shifts[i] < stmt.Shifts[j] })
+	for i := 1; i < len(stmt.Shifts); i++ {
+		if stmt.Shifts[i] == stmt.Shifts[i-1] {
+			return &ParseError{Message: "duplicate shift time " + formatClock(stmt.Shifts[i]), Pos: p.last.pos}
+		}
+	}
+	return nil
+}
+
+// 解析TZ('Asia/Shanghai')，TZ已读取
+func (p *Parser) parseTimeZone(stmt *SelectStatement) error {
+	if tok, pos, lit := p.scan(); tok != LPAREN {
+		return newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != STRING {
+		return newParseError(tokstr(tok, lit), []string{"time zone"}, pos)
+	}
+	loc, err := time.LoadLocation(lit)
+	if err != nil {
+		return &ParseError{Message: "unknown time zone " + lit, Pos: pos}
+	}
+	stmt.Location = loc
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return nil
+}
+
+// 时间长度或其相反数
+func durationValue(e Expr) (time.Duration, bool) {
+	switch e := e.(type) {
+	case *DurationLiteral:
+		return e.Val, true
+	case *UnaryExpr:
+		if d, ok := durationValue(e.X); ok && e.Op == SUB {
+			return -d, true
+		}
+	}
+	return 0, false
+}
+
+// 解析FILL(none|null|previous|linear|数字)，FILL已读取
+func (p *Parser) parseFill(stmt *SelectStatement) error {
+	if tok, pos, lit := p.scan(); tok != LPAREN {
+		return newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+	e, err := p.ParseExpr()
+	if err != nil {
+		return err
+	}
+	switch e := e.(type) {
+	case *VarRef:
+		switch strings.ToLower(e.Name) {
+		case "none":
+			stmt.Fill = FillNone
+		case "null":
+			stmt.Fill = FillNull
+		case "previous":
+			stmt.Fill = FillPrevious
+		case "linear":
+			stmt.Fill = FillLinear
+		default:
+			return &ParseError{Message: "unknown fill option " + e.Name, Pos: p.last.pos}
+		}
+	default:
+		v, ok := EvalExpr(e, func(string) (float64, bool) { return 0, false })
+		if !ok {
+			return &ParseError{Message: "fill value must be a number", Pos: p.last.pos}
+		}
+		stmt.Fill, stmt.FillValue = FillConstant, v
+	}
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+	return nil
+}
+
+// 读取下一个词法单元
+func (p *Parser) scan() (Token, int, string) {
+	if n := len(p.buf); n > 0 {
+		p.last = p.buf[n-1]
+		p.buf = p.buf[:n-1]
+		return p.last.tok, p.last.pos, p.last.lit
+	}
+	tok, pos, lit := p.s.Scan()
+	p.last = scanned{tok, pos, lit}
+	return tok, pos, lit
+}
+
+// 读取下一个非空白的词法单元
+func (p *Parser) scanIgnoreWhitespace() (Token, int, string) {
+	tok, pos, lit := p.scan()
+	if tok == WS {
+		tok, pos, lit = p.scan()
+	}
+	p.last = scanned{tok, pos, lit}
+	return tok, pos, lit
+}
+
+// 回退最近读取的一个词法单元
+func (p *Parser) unscan() {
+	p.buf = append(p.buf, p.last)
+}
+
+// 解析错误
+type ParseError struct {
+	Message  string
+	Found    string
+	Expected []string
+	Pos      int
+}
+
+func newParseError(found string, expected []string, pos int) *ParseError {
+	return &ParseError{Found: found, Expected: expected, Pos: pos}
+}
+
+func (e *ParseError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s at position %d", e.Message, e.Pos)
+	}
+	return fmt.Sprintf("found %s, expected %s at position %d", e.Found, strings.Join(e.Expected, ", "), e.Pos)
+}
+
+// 词法单元的显示文本
+func tokstr(tok Token, lit string) string {
+	if lit != "" {
+		return lit
+	}
+	return tok.String()
+}
@@ -0,0 +1,121 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		s      string
+		except string
+		value  float64
+		valid  bool
+	}{
+		{"$12 - $13", "$12 - $13", 3, true},
+		{"1 + 2 * 3", "1 + 2 * 3", 7, true},
+		{"(1 + 2) * 3", "(1 + 2) * 3", 9, true},
+		{"10 - 4 - 3", "10 - 4 - 3", 3, true},
+		{"$5 * ($6 == 1)", "$5 * ($6 == 1)", 7, true},
+		{"$6 = 1 and $12 > 5 || !$6", "$6 == 1 AND $12 > 5 OR NOT $6", 1, true},
+		{"-$13 % 4", "-$13 % 4", -3, true},
+		{"$12 / 0", "$12 / 0", 0, false},
+		{"$99 + 1", "$99 + 1", 0, false},
+	}
+	row := map[string]float64{"12": 10, "13": 7, "5": 7, "6": 1}
+	lookup := func(name string) (float64, bool) {
+		v, ok := row[name]
+		return v, ok
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.s)
+		if err != nil {
+			t.Fatalf("parse %q fail: %v", tt.s, err)
+		}
+		if e.String() != tt.except {
+			t.Fatalf("parse %q error: except %q, actual %q", tt.s, tt.except, e.String())
+		}
+		v, ok := EvalExpr(e, lookup)
+		if ok != tt.valid || v != tt.value {
+			t.Fatalf("eval %q error: except %v %v, actual %v %v", tt.s, tt.value, tt.valid, v, ok)
+		}
+	}
+
+	for _, s := range []string{"$12 +", "($12", "$12 $13", "$a", "1 & 2"} {
+		if _, err := ParseExpr(s); err == nil {
+			t.Fatalf("expected parse %q fail", s)
+		}
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		s      string
+		except string
+	}{
+		{
+			"SELECT mean(value) FROM 12,13 WHERE time >= now()-1h GROUP BY time(1m) FILL(previous)",
+			"SELECT mean(value) FROM 12,13 WHERE time >= now() - 1h GROUP BY time(1m) FILL(previous)",
+		},
+		{
+			"select value from 1 where time > '2021-01-01T00:00:00Z' and value != 0 limit 10",
+			"SELECT value FROM 1 WHERE time > '2021-01-01T00:00:00Z' AND value != 0 LIMIT 10",
+		},
+		{
+			"SELECT min(value) AS lo, percentile(value, 90) FROM 5 GROUP BY time(1h, -15m) FILL(-1)",
+			"SELECT min(value) AS lo, percentile(value, 90) FROM 5 GROUP BY time(1h, -15m) FILL(-1)",
+		},
+		{
+			"EXPLAIN SELECT $12 - $13 FROM 12, 13 GROUP BY time(90s)",
+			"EXPLAIN SELECT $12 - $13 FROM 12,13 GROUP BY time(90s)",
+		},
+		{
+			"SELECT mean(value) FROM 1 GROUP BY calendar(Month) FILL(null) tz('Europe/Berlin')",
+			"SELECT mean(value) FROM 1 GROUP BY calendar(month) FILL(null) TZ('Europe/Berlin')",
+		},
+		{
+			"SELECT count(value) FROM 1 GROUP BY shift('22:00', '06:00', '14:00:30') TZ('Asia/Shanghai')",
+			"SELECT count(value) FROM 1 GROUP BY shift('06:00', '14:00:30', '22:00') TZ('Asia/Shanghai')",
+		},
+	}
+	for _, tt := range tests {
+		stmt, err := ParseStatement(tt.s)
+		if err != nil {
+			t.Fatalf("parse %q fail: %v", tt.s, err)
+		}
+		if stmt.String() != tt.except {
+			t.Fatalf("parse %q error: except %q, actual %q", tt.s, tt.except, stmt.String())
+		}
+	}
+
+	stmt, _ := ParseStatement("SELECT mean(value) FROM 12 GROUP BY time(1m, 30s) FILL(linear) LIMIT 5")
+	s := stmt.(*SelectStatement)
+	if s.Interval != time.Minute || s.Offset != 30*time.Second || s.Fill != FillLinear || s.Limit != 5 {
+		t.Fatalf("parse statement error: %+v", s)
+	}
+
+	for _, s := range []string{
+		"SELECT",
+		"SELECT value",
+		"SELECT value FROM",
+		"SELECT value FROM a",
+		"SELECT value FROM 1 GROUP BY value",
+		"SELECT value FROM 1 GROUP BY time(0s)",
+		"SELECT value FROM 1 GROUP BY time(1x)",
+		"SELECT value FROM 1 FILL(zero)",
+		"SELECT value FROM 1 LIMIT -1",
+		"SELECT mean(value FROM 1",
+		"SELECT value FROM 1 WHERE time > 'abc",
+		"EXPLAIN value FROM 1",
+		"SELECT value FROM 1 GROUP BY calendar(year)",
+		"SELECT value FROM 1 GROUP BY shift()",
+		"SELECT value FROM 1 GROUP BY shift('25:00')",
+		"SELECT value FROM 1 GROUP BY shift('06:00', '06:00')",
+		"SELECT value FROM 1 GROUP BY calendar(day) TZ('Mars/Olympus')",
+		"SELECT value FROM 1 GROUP BY time(1h) TZ('UTC')",
+	} {
+		if _, err := ParseStatement(s); err == nil {
+			t.Fatalf("expected parse %q fail", s)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/lsm"
+)
+
+// 跳过TSM文件的原因
+const (
+	SkipKeyRange  = "key range"
+	SkipBloom     = "bloom filter"
+	SkipTimeRange = "time range"
+	SkipNoBlocks  = "no blocks"
+)
+
+// KeyPlan 一个key在时间范围[Min, Max]内的读取计划
+type KeyPlan struct {
+	Key      uint32
+	Min, Max int64
+	// 按从旧到新排列的TSM文件
+	Files []*FilePlan
+	// Cache(含快照)中时间范围内的数据
+	Cached coder.Values
+
+	// 引用的TSM文件，由Release释放
+	readers []*lsm.TSMReader
+}
+
+// FilePlan 一个TSM文件的读取计划
+type FilePlan struct {
+	Path string
+	// 文件中数据的时间范围，文件被按key跳过时不读取索引，为0
+	MinTime, MaxTime int64
+	// 跳过该文件的原因，为空时读取Blocks
+	Skip   string
+	Blocks []lsm.IndexEntry
+
+	reader *lsm.TSMReader
+}
+
+// PlanKey 确定读取key在时间范围内的数据需要的文件、block和Cache数据。
+// 依次按文件的key范围、bloom过滤器和索引中的最小最大时间跳过文件，再按block的时间范围跳过block。
+// c和fs均可为nil。计划引用所有TSM文件，使用完后调用Release
+func PlanKey(c *cache.Cache, fs *lsm.FileStore, key uint32, min, max int64) *KeyPlan {
+	p := &KeyPlan{Key: key, Min: min, Max: max}
+	if fs != nil {
+		p.readers = fs.Acquire()
+		for _, r := range p.readers {
+			fp := &FilePlan{Path: r.Path(), reader: r}
+			p.Files = append(p.Files, fp)
+
+			if minKey, maxKey := r.KeyRange(); key < minKey || key > maxKey {
+				fp.Skip = SkipKeyRange
+				continue
+			}
+			if !r.MayContain(key) {
+				fp.Skip = SkipBloom
+				continue
+			}
+			fp.MinTime, fp.MaxTime = r.TimeRange()
+			if fp.MaxTime < min || fp.MinTime > max {
+				fp.Skip = SkipTimeRange
+				continue
+			}
+			for _, e := range r.Entries(key) {
+				if e.OverlapsTimeRange(min, max) {
+					fp.Blocks = append(fp.Blocks, e)
+				}
+			}
+			if len(fp.Blocks) == 0 {
+				fp.Skip = SkipNoBlocks
+			}
+		}
+	}
+	if c != nil {
+		p.Cached = filterRange(c.Values(key), min, max)
+	}
+	return p
+}
+
+// 按计划新建游标，opt中的Key和时间范围由计划决定
+func (p *KeyPlan) Cursor(opt CursorOptions) *Cursor {
+	opt.Key, opt.Min, opt.Max = p.Key, p.Min, p.Max
+	var locs []lsm.BlockLocation
+	for _, fp := range p.Files {
+		for _, e := range fp.Blocks {
+			locs = append(locs, lsm.BlockLocation{Reader: fp.reader, Entry: e})
+		}
+	}
+	return newCursor(locs, p.Cached, opt)
+}
+
+// Release 释放计划引用的TSM文件，已新建的游标持有各自的引用
+func (p *KeyPlan) Release() {
+	for _, r := range p.readers {
+		r.Unref()
+	}
+	p.readers = nil
+}
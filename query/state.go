@@ -0,0 +1,67 @@
+package query
+
+// 状态变化
+type StateChange struct {
+	Time int64
+	Old  byte
+	New  byte
+}
+
+// StateChanges 读取升序游标中的所有数据，只返回值发生变化的点
+func StateChanges(cur *Cursor) ([]StateChange, error) {
+	if !cur.opt.Ascending {
+		return nil, ErrDescendingCursor
+	}
+	var changes []StateChange
+	var prev byte
+	first := true
+	for {
+		values, err := cur.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return changes, nil
+		}
+		for _, v := range values {
+			if !first && v.Value != prev {
+				changes = append(changes, StateChange{Time: v.UnixNano, Old: prev, New: v.Value})
+			}
+			prev, first = v.Value, false
+		}
+	}
+}
+
+// 一个值的持续时间
+type StateDuration struct {
+	Value    byte
+	Duration int64
+}
+
+// 一个窗口内每个值的持续时间
+type WindowStates struct {
+	// 窗口起点
+	Time int64
+	// 按值排序
+	States []StateDuration
+}
+
+// TimeInState 统计每个窗口内每个值的持续时间。
+// 每个点的值持续到下一个点，最后一个点持续到查询的结束时间，第一个点之前的状态未知不做统计
+func TimeInState(cur *Cursor, w Window) ([]WindowStates, error) {
+	hists, err := Histograms(cur, HistogramOptions{Window: w, TimeWeighted: true})
+	if err != nil {
+		return nil, err
+	}
+	windows := make([]WindowStates, 0, len(hists))
+	for _, h := range hists {
+		ws := WindowStates{Time: h.Time}
+		for v, d := range h.Counts {
+			if d > 0 {
+				ws.States = append(ws.States, StateDuration{Value: byte(v), Duration: d})
+			}
+		}
+		windows = append(windows, ws)
+	}
+	return windows, nil
+}
@@ -0,0 +1,36 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStateChanges(t *testing.T) {
+	c := newTestCache(t, 1, []int64{0, 5, 10, 12, 20, 30}, []byte{1, 1, 2, 2, 0, 1})
+	cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 100, Ascending: true, BatchSize: 2})
+	changes, err := StateChanges(cur)
+	if err != nil {
+		t.Fatalf("state changes fail: %v", err)
+	}
+	except := []StateChange{{10, 1, 2}, {20, 2, 0}, {30, 0, 1}}
+	if !reflect.DeepEqual(changes, except) {
+		t.Fatalf("state changes error: except %v, actual %v", except, changes)
+	}
+}
+
+func TestTimeInState(t *testing.T) {
+	c := newTestCache(t, 1, []int64{5, 15, 25, 28}, []byte{1, 2, 1, 3})
+	cur := NewCursor(c, nil, CursorOptions{Key: 1, Min: 0, Max: 39, Ascending: true})
+	windows, err := TimeInState(cur, Window{Interval: 20})
+	if err != nil {
+		t.Fatalf("time in state fail: %v", err)
+	}
+	// 1: [5,15) [25,28)；2: [15,25)；3: [28,40)
+	except := []WindowStates{
+		{Time: 0, States: []StateDuration{{1, 10}, {2, 5}}},
+		{Time: 20, States: []StateDuration{{1, 3}, {2, 5}, {3, 12}}},
+	}
+	if !reflect.DeepEqual(windows, except) {
+		t.Fatalf("time in state error: except %v, actual %v", except, windows)
+	}
+}
@@ -0,0 +1,136 @@
+package query
+
+// 词法单元
+type Token int
+
+const (
+	ILLEGAL Token = iota
+	EOF
+	WS
+
+	// 字面量
+	IDENT    // value
+	NUMBER   // 12.5
+	DURATION // 1h
+	STRING   // '2021-01-01T00:00:00Z'
+	REF      // $12，引用key为12的列
+
+	// 运算符
+	ADD // +
+	SUB // -
+	MUL // *
+	DIV // /
+	MOD // %
+
+	AND // AND, &&
+	OR  // OR, ||
+	NOT // NOT, !
+
+	EQ  // ==, =
+	NEQ // !=
+	LT  // <
+	LTE // <=
+	GT  // >
+	GTE // >=
+
+	LPAREN // (
+	RPAREN // )
+	COMMA  // ,
+
+	// 关键字
+	SELECT
+	FROM
+	WHERE
+	GROUP
+	BY
+	FILL
+	LIMIT
+	AS
+	TZ
+	EXPLAIN
+)
+
+var tokens = [...]string{
+	ILLEGAL: "ILLEGAL",
+	EOF:     "EOF",
+	WS:      "WS",
+
+	IDENT:    "IDENT",
+	NUMBER:   "NUMBER",
+	DURATION: "DURATION",
+	STRING:   "STRING",
+	REF:      "REF",
+
+	ADD: "+",
+	SUB: "-",
+	MUL: "*",
+	DIV: "/",
+	MOD: "%",
+
+	AND: "AND",
+	OR:  "OR",
+	NOT: "NOT",
+
+	EQ:  "==",
+	NEQ: "!=",
+	LT:  "<",
+	LTE: "<=",
+	GT:  ">",
+	GTE: ">=",
+
+	LPAREN: "(",
+	RPAREN: ")",
+	COMMA:  ",",
+
+	SELECT:  "SELECT",
+	FROM:    "FROM",
+	WHERE:   "WHERE",
+	GROUP:   "GROUP",
+	BY:      "BY",
+	FILL:    "FILL",
+	LIMIT:   "LIMIT",
+	AS:      "AS",
+	TZ:      "TZ",
+	EXPLAIN: "EXPLAIN",
+}
+
+func (t Token) String() string {
+	if t >= 0 && int(t) < len(tokens) {
+		return tokens[t]
+	}
+	return ""
+}
+
+// 二元运算符的优先级，不是二元运算符时为0
+func (t Token) Precedence() int {
+	switch t {
+	case OR:
+		return 1
+	case AND:
+		return 2
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		return 3
+	case ADD, SUB:
+		return 4
+	case MUL, DIV, MOD:
+		return 5
+	}
+	return 0
+}
+
+// 关键字
+var keywords = map[string]Token{
+	"AND":     AND,
+	"OR":      OR,
+	"NOT":     NOT,
+	"SELECT":  SELECT,
+	"FROM":    FROM,
+	"WHERE":   WHERE,
+	"GROUP":   GROUP,
+	"BY":      BY,
+	"FILL":    FILL,
+	"LIMIT":   LIMIT,
+	"AS":      AS,
+	"TZ":      TZ,
+	"EXPLAIN": EXPLAIN,
+}
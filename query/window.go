@@ -0,0 +1,184 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	// 没有系统时区数据库时(如Windows)使用内置的时区数据
+	_ "time/tzdata"
+)
+
+// 一次查询最多的时间窗口数量
+const MaxWindows = 1000000
+
+// 日历窗口的单位
+type Calendar int
+
+const (
+	// 不使用日历窗口
+	CalendarNone Calendar = iota
+	// 本地零点开始的自然日
+	CalendarDay
+	// 周一零点开始的自然周
+	CalendarWeek
+	// 1日零点开始的自然月
+	CalendarMonth
+)
+
+func (c Calendar) String() string {
+	switch c {
+	case CalendarNone:
+		return "none"
+	case CalendarDay:
+		return "day"
+	case CalendarWeek:
+		return "week"
+	case CalendarMonth:
+		return "month"
+	}
+	return fmt.Sprintf("unknown(%d)", int(c))
+}
+
+// 可表示的最晚时间，日历窗口超过该时间时视为无界
+var maxWindowTime = time.Unix(0, math.MaxInt64)
+
+// 时间窗口。默认为固定长度的窗口，窗口起点为Offset + k*Interval，Interval为0时整个时间范围为一个窗口。
+// 设置Shifts或Calendar时按Location的本地时间划分班次或日历窗口，不使用Interval和Offset，Shifts优先
+type Window struct {
+	Interval int64
+	Offset   int64
+	// 按天、周或月划分，窗口边界跟随本地零点和夏令时变化
+	Calendar Calendar
+	// 班次的起始时刻，为距本地零点的时长，升序排列且小于24小时。每个班次持续到下一个班次开始
+	Shifts []time.Duration
+	// 日历和班次窗口使用的时区，为nil时使用UTC
+	Location *time.Location
+}
+
+// 是否划分窗口
+func (w Window) grouped() bool {
+	return w.Interval > 0 || w.Calendar != CalendarNone || len(w.Shifts) > 0
+}
+
+// t所在窗口的起点
+func (w Window) Start(t int64) int64 {
+	switch {
+	case len(w.Shifts) > 0:
+		_, _, start := w.shiftStart(t)
+		return start
+	case w.Calendar != CalendarNone:
+		return w.calendarStart(t).UnixNano()
+	case w.Interval <= 0:
+		return math.MinInt64
+	}
+	d := (t - w.Offset%w.Interval) % w.Interval
+	if d < 0 {
+		d += w.Interval
+	}
+	return t - d
+}
+
+// start开始的窗口的下一个窗口起点
+func (w Window) Next(start int64) int64 {
+	switch {
+	case len(w.Shifts) > 0:
+		day, k, _ := w.shiftStart(start)
+		if k++; k == len(w.Shifts) {
+			day, k = day+1, 0
+		}
+		return unixNano(w.shiftTime(day, k))
+	case w.Calendar != CalendarNone:
+		y, m, d := time.Unix(0, start).In(w.location()).Date()
+		switch w.Calendar {
+		case CalendarDay:
+			d++
+		case CalendarWeek:
+			d += 7
+		case CalendarMonth:
+			m++
+		}
+		return unixNano(time.Date(y, m, d, 0, 0, 0, 0, w.location()))
+	}
+	if w.Interval <= 0 || start > math.MaxInt64-w.Interval {
+		return math.MaxInt64
+	}
+	return start + w.Interval
+}
+
+// 时间范围[min, max]内的窗口数量
+func (w Window) Count(min, max int64) int64 {
+	switch {
+	case len(w.Shifts) > 0:
+		d1, k1, _ := w.shiftStart(min)
+		d2, k2, _ := w.shiftStart(max)
+		return (d2-d1)*int64(len(w.Shifts)) + int64(k2-k1) + 1
+	case w.Calendar == CalendarMonth:
+		y1, m1, _ := time.Unix(0, min).In(w.location()).Date()
+		y2, m2, _ := time.Unix(0, max).In(w.location()).Date()
+		return int64(y2-y1)*12 + int64(m2-m1) + 1
+	case w.Calendar != CalendarNone:
+		n := civilDay(w.calendarStart(max).In(w.location()).Date()) - civilDay(w.calendarStart(min).In(w.location()).Date())
+		if w.Calendar == CalendarWeek {
+			n /= 7
+		}
+		return n + 1
+	case w.Interval <= 0:
+		return 1
+	}
+	return (w.Start(max)-w.Start(min))/w.Interval + 1
+}
+
+func (w Window) location() *time.Location {
+	if w.Location == nil {
+		return time.UTC
+	}
+	return w.Location
+}
+
+// t所在的日历窗口的起点
+func (w Window) calendarStart(t int64) time.Time {
+	y, m, d := time.Unix(0, t).In(w.location()).Date()
+	switch w.Calendar {
+	case CalendarWeek:
+		wd := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Weekday()
+		d -= (int(wd) + 6) % 7
+	case CalendarMonth:
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, w.location())
+}
+
+// t所在的班次: 班次开始的本地日期(距1970-01-01的天数)、班次序号和起点
+func (w Window) shiftStart(t int64) (int64, int, int64) {
+	day := civilDay(time.Unix(0, t).In(w.location()).Date())
+	// 当天第一个班次之前属于前一天的最后一个班次
+	for back := int64(0); back <= 1; back++ {
+		for k := len(w.Shifts) - 1; k >= 0; k-- {
+			if start := w.shiftTime(day-back, k); start.UnixNano() <= t {
+				return day - back, k, start.UnixNano()
+			}
+		}
+	}
+	return day - 1, 0, w.shiftTime(day-1, 0).UnixNano()
+}
+
+// 本地日期day的第k个班次的开始时间
+func (w Window) shiftTime(day int64, k int) time.Time {
+	y, m, d := time.Unix(day*86400, 0).UTC().Date()
+	s := w.Shifts[k]
+	return time.Date(y, m, d, int(s/time.Hour), int(s%time.Hour/time.Minute), int(s%time.Minute/time.Second), int(s%time.Second), w.location())
+}
+
+// 日期距1970-01-01的天数
+func civilDay(y int, m time.Month, d int) int64 {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// 超出可表示范围时返回math.MaxInt64
+func unixNano(t time.Time) int64 {
+	if t.After(maxWindowTime) {
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
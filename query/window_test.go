@@ -0,0 +1,90 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load location %s fail: %v", name, err)
+	}
+	return loc
+}
+
+// 依次检查从t开始的窗口边界
+func checkWindows(t *testing.T, name string, w Window, from time.Time, except []time.Time) {
+	start := w.Start(from.UnixNano())
+	for i, e := range except {
+		if start != e.UnixNano() {
+			t.Fatalf("%s: window %d except %v, actual %v", name, i, e, time.Unix(0, start).In(e.Location()))
+		}
+		start = w.Next(start)
+	}
+}
+
+func TestWindow_Calendar(t *testing.T) {
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	date := func(y int, m time.Month, d, h int) time.Time { return time.Date(y, m, d, h, 0, 0, 0, berlin) }
+
+	// 夏令时开始的一天只有23小时
+	w := Window{Calendar: CalendarDay, Location: berlin}
+	checkWindows(t, "day", w, date(2021, 3, 27, 15), []time.Time{
+		date(2021, 3, 27, 0), date(2021, 3, 28, 0), date(2021, 3, 29, 0),
+	})
+	if d := w.Next(date(2021, 3, 28, 0).UnixNano()) - date(2021, 3, 28, 0).UnixNano(); d != int64(23*time.Hour) {
+		t.Fatalf("dst day length error: %v", time.Duration(d))
+	}
+	if n := w.Count(date(2021, 3, 27, 15).UnixNano(), date(2021, 3, 29, 1).UnixNano()); n != 3 {
+		t.Fatalf("day count error: %d", n)
+	}
+
+	// 周一开始
+	w = Window{Calendar: CalendarWeek, Location: berlin}
+	checkWindows(t, "week", w, date(2021, 3, 28, 12), []time.Time{
+		date(2021, 3, 22, 0), date(2021, 3, 29, 0), date(2021, 4, 5, 0),
+	})
+	if n := w.Count(date(2021, 3, 28, 12).UnixNano(), date(2021, 4, 5, 0).UnixNano()); n != 3 {
+		t.Fatalf("week count error: %d", n)
+	}
+
+	w = Window{Calendar: CalendarMonth, Location: berlin}
+	checkWindows(t, "month", w, date(2021, 1, 31, 23), []time.Time{
+		date(2021, 1, 1, 0), date(2021, 2, 1, 0), date(2021, 3, 1, 0), date(2021, 4, 1, 0),
+	})
+	if n := w.Count(date(2020, 12, 31, 23).UnixNano(), date(2021, 2, 1, 0).UnixNano()); n != 3 {
+		t.Fatalf("month count error: %d", n)
+	}
+
+	// 不设置时区时使用UTC
+	w = Window{Calendar: CalendarDay}
+	if start := w.Start(date(2021, 3, 28, 1).UnixNano()); start != time.Date(2021, 3, 28, 0, 0, 0, 0, time.UTC).UnixNano() {
+		t.Fatalf("utc day error: %v", time.Unix(0, start).UTC())
+	}
+}
+
+func TestWindow_Shifts(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	at := func(d, h int) time.Time { return time.Date(2021, 6, d, h, 0, 0, 0, shanghai) }
+
+	w := Window{Shifts: []time.Duration{6 * time.Hour, 14 * time.Hour, 22 * time.Hour}, Location: shanghai}
+	// 凌晨属于前一天的夜班
+	checkWindows(t, "shift", w, at(2, 3), []time.Time{
+		at(1, 22), at(2, 6), at(2, 14), at(2, 22), at(3, 6),
+	})
+	if start := w.Start(at(2, 14).UnixNano()); start != at(2, 14).UnixNano() {
+		t.Fatalf("shift boundary error: %v", time.Unix(0, start).In(shanghai))
+	}
+	if n := w.Count(at(1, 23).UnixNano(), at(3, 7).UnixNano()); n != 5 {
+		t.Fatalf("shift count error: %d", n)
+	}
+
+	// 夏令时结束的夜班多1小时
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	w = Window{Shifts: []time.Duration{6 * time.Hour, 18 * time.Hour}, Location: berlin}
+	night := time.Date(2021, 10, 30, 18, 0, 0, 0, berlin)
+	if d := w.Next(night.UnixNano()) - night.UnixNano(); d != int64(13*time.Hour) {
+		t.Fatalf("dst shift length error: %v", time.Duration(d))
+	}
+}
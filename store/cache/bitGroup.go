@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// BitGroup 把最多8个布尔key合并存储为一个key，第i个key对应值的第i位。
+// 每个时间戳输出所有位的最新状态，没有新数据的位沿用之前的状态
+type BitGroup struct {
+	mu sync.Mutex
+
+	// 合并后的key
+	key uint32
+	// 每个源key对应的位
+	bits map[uint32]uint8
+	// 当前所有位的状态
+	state byte
+}
+
+// 新建BitGroup。sources[i]为第i位对应的源key，为0时该位不使用
+func NewBitGroup(key uint32, sources [8]uint32) (*BitGroup, error) {
+	g := &BitGroup{key: key, bits: make(map[uint32]uint8)}
+	for i, k := range sources {
+		if k == 0 {
+			continue
+		}
+		if _, ok := g.bits[k]; ok {
+			return nil, fmt.Errorf("duplicate key %d in bit group %d", k, key)
+		}
+		g.bits[k] = uint8(i)
+	}
+	return g, nil
+}
+
+// 合并后的key
+func (g *BitGroup) Key() uint32 {
+	return g.key
+}
+
+// Seed 设置所有位的当前状态，打开存储时用合并key最新的已存储值恢复，
+// 否则重启后没有新数据的位会被当作0写入
+func (g *BitGroup) Seed(state byte) {
+	g.mu.Lock()
+	g.state = state
+	g.mu.Unlock()
+}
+
+// Pack 把values中属于该组的key合并为一个key，其他key保持不变。
+// 值不为0时对应位为1；直接写入合并key的值覆盖所有位。输出的合并key按时间升序，各批数据应按时间顺序写入
+func (g *BitGroup) Pack(values map[uint32][]coder.Value) map[uint32][]coder.Value {
+	// 取出组内的数据，按时间排序，同一时间的数据一起生效，其中合并key的值先生效
+	type bitValue struct {
+		t   int64
+		all bool
+		bit uint8
+		v   byte
+	}
+	var changes []bitValue
+	out := make(map[uint32][]coder.Value, len(values))
+	for k, vs := range values {
+		if k == g.key {
+			for _, v := range vs {
+				changes = append(changes, bitValue{t: v.UnixNano, all: true, v: v.Value})
+			}
+			continue
+		}
+		bit, ok := g.bits[k]
+		if !ok {
+			out[k] = vs
+			continue
+		}
+		for _, v := range vs {
+			changes = append(changes, bitValue{t: v.UnixNano, bit: bit, v: v.Value})
+		}
+	}
+	if len(changes) == 0 {
+		return out
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].t != changes[j].t {
+			return changes[i].t < changes[j].t
+		}
+		return changes[i].all && !changes[j].all
+	})
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var packed []coder.Value
+	for i, c := range changes {
+		switch {
+		case c.all:
+			g.state = c.v
+		case c.v != 0:
+			g.state |= 1 << c.bit
+		default:
+			g.state &^= 1 << c.bit
+		}
+		if i == len(changes)-1 || changes[i+1].t != c.t {
+			packed = append(packed, coder.NewValue(c.t, g.state))
+		}
+	}
+	out[g.key] = packed
+	return out
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+func TestBitGroup_Pack(t *testing.T) {
+	g, err := NewBitGroup(100, [8]uint32{1, 2, 0, 3})
+	if err != nil {
+		t.Fatalf("new bit group fail: %v", err)
+	}
+	out := g.Pack(map[uint32][]coder.Value{
+		1: {coder.NewValue(10, 1), coder.NewValue(30, 0)},
+		2: {coder.NewValue(20, 1)},
+		3: {coder.NewValue(20, 1)},
+		9: {coder.NewValue(10, 7)},
+	})
+	if len(out) != 2 || len(out[9]) != 1 {
+		t.Fatalf("pack keys error: %v", out)
+	}
+	except := []coder.Value{coder.NewValue(10, 0x01), coder.NewValue(20, 0x0B), coder.NewValue(30, 0x0A)}
+	if len(out[100]) != len(except) {
+		t.Fatalf("pack values error: %v", out[100])
+	}
+	for i, v := range except {
+		if out[100][i] != v {
+			t.Fatalf("pack value error. index %d: except %v, actual %v", i, v, out[100][i])
+		}
+	}
+
+	// 之后的写入沿用之前的状态
+	out = g.Pack(map[uint32][]coder.Value{2: {coder.NewValue(40, 0)}})
+	if len(out[100]) != 1 || out[100][0] != coder.NewValue(40, 0x08) {
+		t.Fatalf("pack state error: %v", out[100])
+	}
+
+	if _, err := NewBitGroup(100, [8]uint32{1, 1}); err == nil {
+		t.Fatalf("expected duplicate key error")
+	}
+}
+
+// 从已存储的值恢复状态，直接写入合并key的值与组内key按时间合并
+func TestBitGroup_SeedAndMerge(t *testing.T) {
+	g, err := NewBitGroup(100, [8]uint32{1, 2})
+	if err != nil {
+		t.Fatalf("new bit group fail: %v", err)
+	}
+	g.Seed(0x82)
+	out := g.Pack(map[uint32][]coder.Value{
+		1:   {coder.NewValue(10, 1), coder.NewValue(30, 0)},
+		100: {coder.NewValue(5, 0x40), coder.NewValue(20, 0x10), coder.NewValue(30, 0xF0)},
+	})
+	except := []coder.Value{
+		coder.NewValue(5, 0x40), coder.NewValue(10, 0x41), coder.NewValue(20, 0x10), coder.NewValue(30, 0xF0),
+	}
+	if len(out) != 1 || len(out[100]) != len(except) {
+		t.Fatalf("pack values error: %v", out)
+	}
+	for i, v := range except {
+		if out[100][i] != v {
+			t.Fatalf("pack value error. index %d: except %v, actual %v", i, v, out[100][i])
+		}
+	}
+
+	// 没有新数据的位沿用恢复的状态
+	g.Seed(0x82)
+	out = g.Pack(map[uint32][]coder.Value{1: {coder.NewValue(40, 1)}})
+	if len(out[100]) != 1 || out[100][0] != coder.NewValue(40, 0x83) {
+		t.Fatalf("seeded state error: %v", out[100])
+	}
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 多个Cache共享的进程级内存预算
+type MemoryBudget struct {
+	// 内存上限，超过后拒绝写入，0为不限制
+	limit uint64
+	// 超过该值后对占用内存最多的Cache提前触发快照，0为不触发
+	snapshotSize uint64
+
+	// 触发快照的回调，由存储引擎设置。回调在独立协程中执行，返回后才会再次触发同一个Cache
+	OnSnapshot func(name string, c *Cache)
+
+	mu sync.RWMutex
+	// 已注册的Cache
+	caches map[string]*Cache
+	// 正在执行快照回调的Cache
+	snapshotting map[*Cache]bool
+	// 提前触发快照的次数
+	snapshots int64
+	// 已通过检查、还没有写入完成的内存
+	reserved uint64
+}
+
+// 每个Cache的内存占用
+type CacheMemUsage struct {
+	Name string
+	// 工作分区和快照的内存占用
+	MemSize uint64
+	// 其中快照的内存占用
+	SnapshotMemSize uint64
+}
+
+func NewMemoryBudget(limit, snapshotSize uint64) *MemoryBudget {
+	return &MemoryBudget{
+		limit:        limit,
+		snapshotSize: snapshotSize,
+		caches:       make(map[string]*Cache),
+		snapshotting: make(map[*Cache]bool),
+	}
+}
+
+// Register 把Cache加入预算管理
+func (b *MemoryBudget) Register(name string, c *Cache) {
+	b.mu.Lock()
+	b.caches[name] = c
+	b.mu.Unlock()
+
+	c.mu.Lock()
+	c.budget = b
+	c.mu.Unlock()
+}
+
+// Unregister 把Cache移出预算管理
+func (b *MemoryBudget) Unregister(name string) {
+	b.mu.Lock()
+	c := b.caches[name]
+	delete(b.caches, name)
+	b.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.budget == b {
+		c.budget = nil
+	}
+	c.mu.Unlock()
+}
+
+// Used 所有Cache占用的内存总和
+func (b *MemoryBudget) Used() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var n uint64
+	for _, c := range b.caches {
+		n += c.MemSize()
+	}
+	return n
+}
+
+// Limit 内存上限
+func (b *MemoryBudget) Limit() uint64 {
+	return b.limit
+}
+
+// Snapshots 提前触发快照的次数
+func (b *MemoryBudget) Snapshots() int64 {
+	return atomic.LoadInt64(&b.snapshots)
+}
+
+// Usage 按占用从大到小列出每个Cache的内存
+func (b *MemoryBudget) Usage() []CacheMemUsage {
+	b.mu.RLock()
+	usage := make([]CacheMemUsage, 0, len(b.caches))
+	for name, c := range b.caches {
+		usage = append(usage, CacheMemUsage{
+			Name:            name,
+			MemSize:         c.MemSize(),
+			SnapshotMemSize: atomic.LoadUint64(&c.snapshotMemSize),
+		})
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].MemSize != usage[j].MemSize {
+			return usage[i].MemSize > usage[j].MemSize
+		}
+		return usage[i].Name < usage[j].Name
+	})
+	return usage
+}
+
+// 写入前预留n字节的内存，并发的写入不会同时通过检查。写入结束后调用release
+func (b *MemoryBudget) reserve(n uint64) error {
+	if b == nil || b.limit == 0 {
+		return nil
+	}
+	used := b.Used() + atomic.AddUint64(&b.reserved, n)
+	if used > b.limit {
+		atomic.AddUint64(&b.reserved, ^(n - 1))
+		return ErrMemoryBudgetExceeded(used, b.limit)
+	}
+	return nil
+}
+
+// 释放reserve预留的内存
+func (b *MemoryBudget) release(n uint64) {
+	if b == nil || b.limit == 0 || n == 0 {
+		return
+	}
+	atomic.AddUint64(&b.reserved, ^(n - 1))
+}
+
+// 写入后检查是否需要提前触发快照
+func (b *MemoryBudget) check() {
+	if b == nil || b.snapshotSize == 0 || b.OnSnapshot == nil {
+		return
+	}
+	if b.Used() <= b.snapshotSize {
+		return
+	}
+
+	// 选出工作分区占用内存最多且没有在快照中的Cache
+	b.mu.Lock()
+	var (
+		name string
+		c    *Cache
+		max  uint64
+	)
+	for n, cc := range b.caches {
+		if b.snapshotting[cc] {
+			continue
+		}
+		if sz := atomic.LoadUint64(&cc.memSize); sz > max {
+			name, c, max = n, cc, sz
+		}
+	}
+	if c == nil {
+		b.mu.Unlock()
+		return
+	}
+	b.snapshotting[c] = true
+	b.mu.Unlock()
+	atomic.AddInt64(&b.snapshots, 1)
+
+	// 异步执行快照回调，不阻塞写入
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.snapshotting, c)
+			b.mu.Unlock()
+		}()
+		b.OnSnapshot(name, c)
+	}()
+}
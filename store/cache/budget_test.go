@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// 内存统计测试
+func TestCache_MemSize(t *testing.T) {
+	cache := NewCache(0)
+	ts := make([]int64, 10)
+	data := make([]byte, 10)
+	for i := 0; i < len(ts); i++ {
+		ts[i] = int64(i)
+	}
+
+	if err := cache.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	exp := uint64(entryMemOverhead + 10*valueMemSize)
+	if cache.MemSize() != exp {
+		t.Fatalf("mem size error: except %d, actual %d", exp, cache.MemSize())
+	}
+
+	// 追加写入按切片扩容后的容量统计
+	if err := cache.Write(1, ts[:1], data[:1]); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if cache.MemSize() <= exp+uint64(valueMemSize) {
+		t.Fatalf("mem size not grown by capacity: %d", cache.MemSize())
+	}
+
+	// 快照后内存转入快照，清除快照后释放
+	total := cache.MemSize()
+	if _, err := cache.Snapshot(); err != nil {
+		t.Fatalf("cache snapshot fail: %v", err)
+	}
+	if cache.MemSize() != total || cache.memSize != 0 {
+		t.Fatalf("snapshot mem size error: %d", cache.MemSize())
+	}
+	cache.ClearSnapshot(true)
+	if cache.MemSize() != 0 {
+		t.Fatalf("clear snapshot mem size error: %d", cache.MemSize())
+	}
+}
+
+// 去重丢弃的数据不再计入内存
+func TestCache_DeduplicateMemSize(t *testing.T) {
+	cache := NewCache(0)
+	ts := make([]int64, 10)
+	data := make([]byte, 10)
+	for i := 0; i < len(ts); i++ {
+		ts[i] = int64(i)
+	}
+	exp := uint64(entryMemOverhead + 10*valueMemSize)
+
+	for i := 0; i < 2; i++ {
+		if err := cache.Write(1, ts, data); err != nil {
+			t.Fatalf("write cache fail: %v", err)
+		}
+	}
+	cache.Deduplicate()
+	if cache.MemSize() != exp {
+		t.Fatalf("deduplicate mem size error: except %d, actual %d", exp, cache.MemSize())
+	}
+
+	// 读取时去重同样减少内存统计，包括快照中的数据
+	if err := cache.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if _, err := cache.Snapshot(); err != nil {
+		t.Fatalf("cache snapshot fail: %v", err)
+	}
+	if err := cache.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if err := cache.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if values := cache.Values(1); len(values) != 10 {
+		t.Fatalf("values count error: %d", len(values))
+	}
+	if cache.MemSize() != 2*exp || cache.snapshotMemSize != exp {
+		t.Fatalf("values mem size error: except %d, actual %d", 2*exp, cache.MemSize())
+	}
+}
+
+// 写入结束后释放预留的内存
+func TestMemoryBudget_Release(t *testing.T) {
+	budget := NewMemoryBudget(uint64(entryMemOverhead+20*valueMemSize), 0)
+	c := NewCache(0)
+	budget.Register("c", c)
+
+	ts, data := make([]int64, 15), make([]byte, 15)
+	for i := range ts {
+		ts[i] = int64(i)
+	}
+	if err := c.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if err := c.Write(1, ts, data); err == nil {
+		t.Fatalf("expected memory budget exceeded")
+	}
+	if budget.reserved != 0 {
+		t.Fatalf("reserved memory not released: %d", budget.reserved)
+	}
+}
+
+// 全局内存预算测试
+func TestMemoryBudget(t *testing.T) {
+	perValue := uint64(valueMemSize)
+	budget := NewMemoryBudget(uint64(entryMemOverhead)*2+perValue*30, perValue*10)
+	snapshotC := make(chan string, 4)
+	budget.OnSnapshot = func(name string, c *Cache) {
+		snapshotC <- name
+	}
+
+	c1, c2 := NewCache(0), NewCache(0)
+	budget.Register("c1", c1)
+	budget.Register("c2", c2)
+
+	ts := make([]int64, 20)
+	data := make([]byte, 20)
+	if err := c1.Write(1, ts[:5], data[:5]); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if err := c2.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+
+	// 超过快照阈值，占用最多的Cache被提前快照
+	select {
+	case name := <-snapshotC:
+		if name != "c2" {
+			t.Fatalf("snapshot cache error: %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected snapshot triggered")
+	}
+
+	// 按Cache报告占用
+	usage := budget.Usage()
+	if len(usage) != 2 || usage[0].Name != "c2" || usage[0].MemSize != c2.MemSize() {
+		t.Fatalf("usage error: %+v", usage)
+	}
+	if budget.Used() != c1.MemSize()+c2.MemSize() {
+		t.Fatalf("used error: %d", budget.Used())
+	}
+
+	// 超过上限后拒绝写入
+	if err := c1.Write(2, ts, data); err == nil {
+		t.Fatalf("expected memory budget exceeded")
+	}
+	budget.Unregister("c2")
+	if err := c1.Write(2, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -27,17 +28,26 @@ type Cache struct {
 	maxSize uint64
 	// 工作中的分区的数据量
 	size uint64
+	// 工作中的分区实际占用的内存
+	memSize uint64
 	// 最近写入时间
 	lastWriteTime time.Time
 
 	// 快照的数据量
 	snapshotSize uint64
+	// 快照实际占用的内存
+	snapshotMemSize uint64
 	// 快照标志位
 	snapshotting bool
 	// 快照对象
 	snapshot *Cache
 	// 快照时间
 	lastSnapshot time.Time
+
+	// 新数据的订阅者
+	subs *subscribers
+	// 所属的全局内存预算
+	budget *MemoryBudget
 }
 
 func NewCache(maxSize uint64) *Cache {
@@ -45,6 +55,7 @@ func NewCache(maxSize uint64) *Cache {
 		maxSize:      maxSize,
 		stats:        &CacheStatistics{},
 		lastSnapshot: time.Now(),
+		subs:         newSubscribers(),
 	}
 	return c
 }
@@ -75,9 +86,17 @@ func (c *Cache) Write(key uint32, ts []int64, values []byte) error {
 		atomic.AddInt64(&c.stats.WriteErr, 1)
 		return fmt.Errorf("cache-max-memory-size exceeded: (%d/%d)", n, limit)
 	}
+	budget := c.memoryBudget()
+	reserved := uint64(len(ts) * valueMemSize)
+	if err := budget.reserve(reserved); err != nil {
+		atomic.AddInt64(&c.stats.WriteErr, 1)
+		return err
+	}
+	// 写入完成后内存计入memSize，失败时没有占用，两种情况都释放预留
+	defer budget.release(reserved)
 
 	// 数据写入
-	newKey, err := c.store.write(key, ts, values)
+	newKey, mem, err := c.store.write(key, ts, values)
 	if err != nil {
 		atomic.AddInt64(&c.stats.WriteErr, 1)
 		return err
@@ -88,13 +107,24 @@ func (c *Cache) Write(key uint32, ts []int64, values []byte) error {
 		addedSize += 4
 	}
 	atomic.AddUint64(&c.size, addedSize)
-	atomic.AddInt64(&c.stats.MemSizeBytes, int64(addedSize))
+	atomic.AddUint64(&c.memSize, uint64(mem))
+	atomic.StoreInt64(&c.stats.MemSizeBytes, int64(c.MemSize()))
 	atomic.AddInt64(&c.stats.WriteOK, 1)
+	budget.check()
 
 	c.mu.Lock()
 	c.lastWriteTime = time.Now()
 	c.mu.Unlock()
 
+	// 推送给订阅者
+	c.publish(key, func() coder.Values {
+		vls := make(coder.Values, len(ts))
+		for i := range ts {
+			vls[i] = coder.NewValue(ts[i], values[i])
+		}
+		return vls
+	})
+
 	return nil
 }
 
@@ -103,9 +133,10 @@ func (c *Cache) WriteMulti(values map[uint32][]coder.Value) error {
 	c.init()
 
 	// 写入数据的大小校验
-	var addedSize uint64
+	var addedSize, addedMem uint64
 	for _, v := range values {
 		addedSize += uint64(coder.Values(v).Size())
+		addedMem += uint64(len(v) * valueMemSize)
 	}
 	limit := c.maxSize
 	n := c.Size() + addedSize
@@ -113,6 +144,12 @@ func (c *Cache) WriteMulti(values map[uint32][]coder.Value) error {
 		atomic.AddInt64(&c.stats.WriteErr, 1)
 		return ErrCacheMemorySizeLimitExceeded(n, limit)
 	}
+	budget := c.memoryBudget()
+	if err := budget.reserve(addedMem); err != nil {
+		atomic.AddInt64(&c.stats.WriteErr, 1)
+		return err
+	}
+	defer budget.release(addedMem)
 
 	var werr error
 	c.mu.RLock()
@@ -121,12 +158,16 @@ func (c *Cache) WriteMulti(values map[uint32][]coder.Value) error {
 
 	// 数据写入和数量统计
 	atomic.AddUint64(&c.size, addedSize)
+	written := make([]uint32, 0, len(values))
 	for k, v := range values {
-		newKey, err := store.writeValues(k, v)
+		newKey, mem, err := store.writeValues(k, v)
+		atomic.AddUint64(&c.memSize, uint64(mem))
 		if err != nil {
 			werr = err
 			addedSize -= uint64(coder.Values(v).Size())
 			atomic.AddUint64(&c.size, ^(uint64(coder.Values(v).Size()) - 1))
+		} else {
+			written = append(written, k)
 		}
 		if newKey {
 			addedSize += uint64(4)
@@ -138,24 +179,55 @@ func (c *Cache) WriteMulti(values map[uint32][]coder.Value) error {
 	if werr != nil {
 		atomic.AddInt64(&c.stats.WriteErr, 1)
 	}
-	atomic.AddInt64(&c.stats.MemSizeBytes, int64(addedSize))
+	atomic.StoreInt64(&c.stats.MemSizeBytes, int64(c.MemSize()))
 	atomic.AddInt64(&c.stats.WriteOK, 1)
+	budget.check()
 
 	c.mu.Lock()
 	c.lastWriteTime = time.Now()
 	c.mu.Unlock()
 
+	// 推送给订阅者
+	for _, k := range written {
+		v := values[k]
+		c.publish(k, func() coder.Values {
+			return append(coder.Values(nil), v...)
+		})
+	}
+
 	return werr
 }
 
-// Deduplicate 去重复
+// Deduplicate 去重复，并从memSize中减去丢弃的数据占用的内存
 func (c *Cache) Deduplicate() {
+	// 持读锁，去重期间不会切换快照
 	c.mu.RLock()
-	store := c.store
-	c.mu.RUnlock()
+	defer c.mu.RUnlock()
+	if c.store == nil {
+		return
+	}
 
 	// 并发执行去重算法
-	_ = store.apply(func(_ []byte, e *entry) error { e.deduplicate(); return nil })
+	var freed uint64
+	_ = c.store.apply(func(_ []byte, e *entry) error {
+		atomic.AddUint64(&freed, uint64(e.deduplicate()))
+		return nil
+	})
+	c.releaseMem(freed, false)
+}
+
+// 去重释放内存后减少工作分区或快照的内存统计
+func (c *Cache) releaseMem(n uint64, snapshot bool) {
+	if n == 0 {
+		return
+	}
+	if snapshot {
+		atomic.AddUint64(&c.snapshot.memSize, ^(n - 1))
+		atomic.AddUint64(&c.snapshotMemSize, ^(n - 1))
+	} else {
+		atomic.AddUint64(&c.memSize, ^(n - 1))
+	}
+	atomic.StoreInt64(&c.stats.MemSizeBytes, int64(c.MemSize()))
 }
 
 // 获取当前Cache当前的总大小
@@ -163,6 +235,18 @@ func (c *Cache) Size() uint64 {
 	return atomic.LoadUint64(&c.size) + atomic.LoadUint64(&c.snapshotSize)
 }
 
+// 获取当前Cache实际占用的内存，包括快照
+func (c *Cache) MemSize() uint64 {
+	return atomic.LoadUint64(&c.memSize) + atomic.LoadUint64(&c.snapshotMemSize)
+}
+
+// 获取所属的全局内存预算
+func (c *Cache) memoryBudget() *MemoryBudget {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.budget
+}
+
 // Snapshot 把当前的数据存入c.snapshot中，然后清空当前cache
 func (c *Cache) Snapshot() (*Cache, error) {
 	c.init()
@@ -199,9 +283,15 @@ func (c *Cache) Snapshot() (*Cache, error) {
 	atomic.StoreUint64(&c.snapshot.size, snapshotSize)
 	atomic.StoreUint64(&c.snapshotSize, snapshotSize)
 
+	// 将当前Cache占用的内存转给快照
+	memSize := atomic.LoadUint64(&c.memSize)
+	atomic.StoreUint64(&c.snapshot.memSize, memSize)
+	atomic.StoreUint64(&c.snapshotMemSize, memSize)
+
 	// 重置当前Cache的工作区
 	c.store.reset()
 	atomic.StoreUint64(&c.size, 0)
+	atomic.StoreUint64(&c.memSize, 0)
 
 	// 更新统计值
 	c.lastSnapshot = time.Now()
@@ -209,6 +299,26 @@ func (c *Cache) Snapshot() (*Cache, error) {
 	return c.snapshot, nil
 }
 
+// ClearSnapshot 快照处理结束。success为true时释放快照数据，否则保留快照等待下次处理
+func (c *Cache) ClearSnapshot(success bool) {
+	c.init()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshotting = false
+	if !success || c.snapshot == nil {
+		return
+	}
+
+	// 释放快照数据
+	c.snapshot.store.reset()
+	atomic.StoreUint64(&c.snapshot.size, 0)
+	atomic.StoreUint64(&c.snapshot.memSize, 0)
+	atomic.StoreUint64(&c.snapshotSize, 0)
+	atomic.StoreUint64(&c.snapshotMemSize, 0)
+	atomic.StoreInt64(&c.stats.MemSizeBytes, int64(c.MemSize()))
+}
+
 // 获得当前cache中的所有key
 func (c *Cache) Keys() []uint32 {
 	c.mu.RLock()
@@ -217,30 +327,56 @@ func (c *Cache) Keys() []uint32 {
 	return store.keys(true)
 }
 
+// 获得当前cache和快照中的所有key，按顺序排列
+func (c *Cache) allKeys() []uint32 {
+	c.mu.RLock()
+	keys := c.store.keys(false)
+	if c.snapshot != nil {
+		keys = append(keys, c.snapshot.store.keys(false)...)
+	}
+	c.mu.RUnlock()
+
+	sort.Sort(uint32Slices(keys))
+	n := 0
+	for i, k := range keys {
+		if i == 0 || k != keys[n-1] {
+			keys[n] = k
+			n++
+		}
+	}
+	return keys[:n]
+}
+
 // 返回当前cache和快照中的所有数据
 func (c *Cache) Values(key uint32) coder.Values {
 	var snapshotEntries *entry
 
-	// 获得cache和快照中的相关entry
+	// 获得cache和快照中的相关entry，还没有写入过时没有数据。
+	// 去重时持读锁，释放的内存计入entry所在的工作分区或快照
 	c.mu.RLock()
+	if c.store == nil {
+		c.mu.RUnlock()
+		return nil
+	}
 	e := c.store.entry(key)
 	if c.snapshot != nil {
 		snapshotEntries = c.snapshot.store.entry(key)
 	}
+	if e != nil {
+		c.releaseMem(uint64(e.deduplicate()), false)
+	}
+	if snapshotEntries != nil {
+		c.releaseMem(uint64(snapshotEntries.deduplicate()), true) // guarantee we are deduplicated
+	}
 	c.mu.RUnlock()
-	if e == nil {
-		if snapshotEntries == nil {
-			return nil
-		}
-	} else {
-		e.deduplicate()
+	if e == nil && snapshotEntries == nil {
+		return nil
 	}
 
 	// entry打包并统计数量
 	var entries []*entry
 	sz := 0
 	if snapshotEntries != nil {
-		snapshotEntries.deduplicate() // guarantee we are deduplicated
 		entries = append(entries, snapshotEntries)
 		sz += snapshotEntries.count()
 	}
@@ -1,82 +1,275 @@
 package cache
 
 import (
+	"io"
 	"os"
+	"runtime"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hooone/datacc/dlog"
 	"github.com/hooone/datacc/store/wal"
 )
 
+// WAL回放进度
+type LoadProgress struct {
+	// 已回放的文件数和文件总数
+	Files      int
+	TotalFiles int
+	// 已回放的字节数和总字节数
+	Bytes      int64
+	TotalBytes int64
+	// 已回放的WALEntry数量
+	Entries int64
+	// 已耗费的时间
+	Elapsed time.Duration
+}
+
+// WAL回放结果统计
+type LoadStatistics struct {
+	Files   int
+	Bytes   int64
+	Entries int64
+	// 写入Cache的数据点数量
+	Values int64
+	// 损坏并被截断的文件数量
+	Corrupt  int
+	Duration time.Duration
+}
+
 type CacheLoader struct {
 	files []string
+	// 每个文件开始回放的字节偏移
+	offsets []int64
+
+	// 并发解码的文件数量，<=0时使用CPU核数
+	Concurrency int
+	// 每回放完一个文件调用一次
+	Progress func(LoadProgress)
+	// 最近一次Load的统计结果
+	Stats LoadStatistics
 
 	Logger dlog.Logger
 }
 
+// 一个文件的解码结果
+type segmentResult struct {
+	name    string
+	size    int64
+	entries []*wal.WriteWALEntry
+	corrupt bool
+	err     error
+}
+
 func NewCacheLoader(files []string) *CacheLoader {
 	return &CacheLoader{
 		files:  files,
 		Logger: dlog.NewNop(),
 	}
 }
+
+// 并发解码WAL文件，并按文件顺序写入Cache
 func (cl *CacheLoader) Load(cache *Cache) error {
-	var r *wal.WALSegmentReader
-	// 遍历要加载的文件
-	for _, fn := range cl.files {
-		if err := func() error {
-			// 打开文件
-			f, err := os.OpenFile(fn, os.O_CREATE|os.O_RDWR, 0666)
-			if err != nil {
-				return err
+	start := time.Now()
+	concurrency := cl.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	// 统计文件总大小，用于进度报告
+	var totalBytes int64
+	for i, fn := range cl.files {
+		if stat, err := os.Stat(fn); err == nil && stat.Size() > cl.offset(i) {
+			totalBytes += stat.Size() - cl.offset(i)
+		}
+	}
+
+	// 每个文件的解码结果通道
+	results := make([]chan segmentResult, len(cl.files))
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+
+	// 限制已解码但未写入Cache的文件数量，避免占用过多内存
+	tokens := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, fn := range cl.files {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
 			}
-			defer f.Close()
+			wg.Add(1)
+			go func(i int, fn string) {
+				defer wg.Done()
+				results[i] <- cl.readSegment(fn, cl.offset(i))
+			}(i, fn)
+		}
+	}()
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
 
-			// 把文件信息写入log
-			stat, err := os.Stat(f.Name())
-			if err != nil {
+	// 按顺序写入Cache
+	stats := LoadStatistics{}
+	for i := range cl.files {
+		res := <-results[i]
+		<-tokens
+		if res.err != nil {
+			return res.err
+		}
+		for _, entry := range res.entries {
+			if err := cache.WriteMulti(entry.Values); err != nil {
 				return err
 			}
-			cl.Logger.Release("Reading file " + f.Name() + ",size: " + strconv.Itoa(int(stat.Size())))
-
-			// Nothing to read, skip it
-			if stat.Size() == 0 {
-				return nil
+			for _, v := range entry.Values {
+				stats.Values += int64(len(v))
 			}
+		}
 
-			// 用字节流初始化reader
-			if r == nil {
-				r = wal.NewWALSegmentReader(f)
-				defer r.Close()
-			} else {
-				r.Reset(f)
-			}
+		// 统计并报告进度
+		stats.Files++
+		stats.Bytes += res.size
+		stats.Entries += int64(len(res.entries))
+		if res.corrupt {
+			stats.Corrupt++
+		}
+		if cl.Progress != nil {
+			cl.Progress(LoadProgress{
+				Files:      stats.Files,
+				TotalFiles: len(cl.files),
+				Bytes:      stats.Bytes,
+				TotalBytes: totalBytes,
+				Entries:    stats.Entries,
+				Elapsed:    time.Since(start),
+			})
+		}
+	}
 
-			// 遍历读取WAL数据库
-			for r.Next() {
-				entry, err := r.Read()
-				if err != nil {
-					n := r.Count()
-					cl.Logger.Release("File corrupt: " + f.Name())
-					if err := f.Truncate(n); err != nil {
-						return err
-					}
-					// WAL文件出错时，丢弃该文件
-					break
-				}
-
-				switch t := entry.(type) {
-				case *wal.WriteWALEntry:
-					if err := cache.WriteMulti(t.Values); err != nil {
-						return err
-					}
-				}
-			}
+	// 把回放结果写入log
+	stats.Duration = time.Since(start)
+	cl.Stats = stats
+	cl.Logger.Release("Reloaded WAL files: " + strconv.Itoa(stats.Files) +
+		", bytes: " + strconv.FormatInt(stats.Bytes, 10) +
+		", entries: " + strconv.FormatInt(stats.Entries, 10) +
+		", values: " + strconv.FormatInt(stats.Values, 10) +
+		", corrupt: " + strconv.Itoa(stats.Corrupt) +
+		", duration: " + stats.Duration.String())
+	return nil
+}
 
-			return r.Close()
-		}(); err != nil {
+// LoadWithCheckpoint 先读取checkpoint，再回放其WAL位置之后的数据。
+// checkpoint缺失或损坏时回放全部WAL文件。读取成功后删除checkpoint文件
+func (cl *CacheLoader) LoadWithCheckpoint(cache *Cache, checkpoint string) error {
+	pos, err := cache.ReadCheckpoint(checkpoint)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			cl.Logger.Error("Load checkpoint " + checkpoint + " fail: " + err.Error())
+		}
+		return cl.Load(cache)
+	}
+	cl.Logger.Release("Loaded checkpoint " + checkpoint + ", segment: " + strconv.Itoa(pos.SegmentID) +
+		", offset: " + strconv.FormatInt(pos.Offset, 10))
+
+	// 跳过checkpoint已经包含的WAL数据
+	files, offsets := cl.files[:0:0], []int64{}
+	for _, fn := range cl.files {
+		id, err := wal.ParseSegmentID(fn)
+		if err != nil {
 			return err
 		}
+		if id < pos.SegmentID {
+			continue
+		}
+		offset := int64(0)
+		if id == pos.SegmentID {
+			offset = pos.Offset
+		}
+		files = append(files, fn)
+		offsets = append(offsets, offset)
 	}
-	return nil
+
+	loader := *cl
+	loader.files, loader.offsets = files, offsets
+	err = loader.Load(cache)
+	cl.Stats = loader.Stats
+	if err != nil {
+		return err
+	}
+	return os.Remove(checkpoint)
+}
+
+// 第i个文件开始回放的字节偏移
+func (cl *CacheLoader) offset(i int) int64 {
+	if i < len(cl.offsets) {
+		return cl.offsets[i]
+	}
+	return 0
+}
+
+// 读取并解码一个WAL文件，从offset处开始
+func (cl *CacheLoader) readSegment(fn string, offset int64) segmentResult {
+	res := segmentResult{name: fn}
+
+	// 打开文件
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	// 把文件信息写入log
+	stat, err := os.Stat(f.Name())
+	if err != nil {
+		f.Close()
+		res.err = err
+		return res
+	}
+	cl.Logger.Debug("Reading file " + f.Name() + ",size: " + strconv.Itoa(int(stat.Size())))
+
+	// Nothing to read, skip it
+	if stat.Size() <= offset {
+		f.Close()
+		return res
+	}
+	res.size = stat.Size() - offset
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			res.err = err
+			return res
+		}
+	}
+
+	// 遍历读取WAL数据
+	r := wal.NewWALSegmentReader(f)
+	defer r.Close()
+	for r.Next() {
+		entry, err := r.Read()
+		if err != nil {
+			n := offset + r.Count()
+			cl.Logger.Release("File corrupt: " + f.Name())
+			if err := f.Truncate(n); err != nil {
+				res.err = err
+				return res
+			}
+			// WAL文件出错时，丢弃该文件余下的数据
+			res.corrupt = true
+			break
+		}
+
+		switch t := entry.(type) {
+		case *wal.WriteWALEntry:
+			res.entries = append(res.entries, t)
+		}
+	}
+
+	res.err = r.Close()
+	return res
 }
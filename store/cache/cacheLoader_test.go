@@ -77,6 +77,71 @@ func TestCacheLoader_LoadSingle(t *testing.T) {
 	}
 }
 
+// 多文件并发回放测试
+func TestCacheLoader_LoadMulti(t *testing.T) {
+	// 准备文件，后面的文件覆盖前面文件中相同时间戳的数据
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	var files []string
+	for n := 0; n < 5; n++ {
+		f := MustTempFile(dir)
+		w := wal.NewWALSegmentWriter(f)
+		for e := 0; e < 3; e++ {
+			v := make([]coder.Value, 10)
+			for i := 0; i < 10; i++ {
+				v[i] = coder.NewValue(int64(e*10+i), byte(n))
+			}
+			entry := &wal.WriteWALEntry{Values: map[uint32][]coder.Value{1: v}}
+			b, err := entry.Encode(nil)
+			if err != nil {
+				t.Fatalf("error encoding: %v", err)
+			}
+			if err := w.Write(snappy.Encode(nil, b)); err != nil {
+				t.Fatalf("write WAL fail: %v", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush WAL fail: %v", err)
+		}
+		files = append(files, f.Name())
+	}
+
+	// 读取WAL到Cache，记录进度
+	cache := NewCache(0)
+	loader := NewCacheLoader(files)
+	loader.Concurrency = 2
+	var progress []LoadProgress
+	loader.Progress = func(p LoadProgress) {
+		progress = append(progress, p)
+	}
+	if err := loader.Load(cache); err != nil {
+		t.Fatalf("failed to load cache: %s", err.Error())
+	}
+
+	// 校验进度和统计
+	if len(progress) != 5 {
+		t.Fatalf("progress count error: %d", len(progress))
+	}
+	last := progress[len(progress)-1]
+	if last.Entries != 15 || last.Bytes != last.TotalBytes || last.Files != 5 {
+		t.Fatalf("progress error: %+v", last)
+	}
+	if loader.Stats.Values != 150 || loader.Stats.Entries != 15 {
+		t.Fatalf("stats error: %+v", loader.Stats)
+	}
+
+	// 按文件顺序回放，最后的文件生效
+	vs := cache.Values(1)
+	if len(vs) != 30 {
+		t.Fatalf("values count error: %d", len(vs))
+	}
+	for i := range vs {
+		if vs[i].Value != 4 {
+			t.Fatalf("replay order error. index: %d, value: %d", i, vs[i].Value)
+		}
+	}
+}
+
 func MustTempDir() string {
 	dir, err := ioutil.TempDir("C:\\share\\tes", "tsm1-")
 	if err != nil {
@@ -1,6 +1,10 @@
 package cache
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/hooone/datacc/store/coder"
+)
 
 // 写入和读取测试
 func TestCache_Write(t *testing.T) {
@@ -122,3 +126,72 @@ func TestCache_Deduplicate(t *testing.T) {
 		}
 	}
 }
+
+// 订阅测试
+func TestCache_Subscribe(t *testing.T) {
+	cache := NewCache(1000)
+	// 重复的key只推送一次
+	sub := cache.Subscribe([]uint32{1, 1}, 2)
+
+	ts := []int64{1, 2, 3}
+	data := []byte{4, 5, 6}
+	if err := cache.Write(1, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	// 未订阅的key不推送
+	if err := cache.Write(2, ts, data); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+
+	// 校验推送的数据
+	b := <-sub.C
+	if b.Key != 1 || len(b.Values) != 3 {
+		t.Fatalf("subscribe batch error: key %d, len %d", b.Key, len(b.Values))
+	}
+	for i := range ts {
+		if b.Values[i].UnixNano != ts[i] || b.Values[i].Value != data[i] {
+			t.Fatalf("subscribe value error. index: %d", i)
+		}
+	}
+	select {
+	case b := <-sub.C:
+		t.Fatalf("unexpected batch for key %d", b.Key)
+	default:
+	}
+
+	// 取消订阅后通道关闭
+	cache.Unsubscribe(sub)
+	cache.Unsubscribe(sub)
+	if _, ok := <-sub.C; ok {
+		t.Fatalf("expected closed channel")
+	}
+}
+
+// 慢订阅者测试
+func TestCache_SubscribeSlow(t *testing.T) {
+	cache := NewCache(1000)
+	sub := cache.Subscribe([]uint32{1}, 1)
+
+	// 默认策略：丢弃数据，写入不阻塞
+	for i := 0; i < 3; i++ {
+		if err := cache.Write(1, []int64{int64(i)}, []byte{1}); err != nil {
+			t.Fatalf("write cache fail: %v", err)
+		}
+	}
+	if sub.Dropped() != 2 {
+		t.Fatalf("dropped count error: except 2, actual %d", sub.Dropped())
+	}
+	if cache.stats.SubscribeDropped != 2 {
+		t.Fatalf("stats dropped count error: %d", cache.stats.SubscribeDropped)
+	}
+
+	// 断开策略：通道满时关闭订阅
+	cache.SetSubscribePolicy(SubscribeDisconnect)
+	if err := cache.WriteMulti(map[uint32][]coder.Value{1: {coder.NewValue(10, 1)}}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	<-sub.C
+	if _, ok := <-sub.C; ok {
+		t.Fatalf("expected slow subscriber disconnected")
+	}
+}
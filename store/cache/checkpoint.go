@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/wal"
+)
+
+/*
+┌───────────────────────────────────────────────┐
+│                    Header                     │
+├─────────┬─────────┬──────────────┬────────────┤
+│  Magic  │ Version │  Segment ID  │   Offset   │
+│ 4 bytes │ 1 byte  │   8 bytes    │  8 bytes   │
+└─────────┴─────────┴──────────────┴────────────┘
+┌───────────────────────────────────────────────────────────────┐
+│                            Entries                            │
+├─────────┬─────────┬───────────┬─────────┬───────────┬─────┬───┤
+│   Key   │  Count  │ First Time│  Value  │Time Delta │Value│...│
+│ 4 bytes │ varint  │  varint   │ 1 byte  │  uvarint  │1byte│   │
+└─────────┴─────────┴───────────┴─────────┴───────────┴─────┴───┘
+┌─────────┐
+│ Footer  │
+├─────────┤
+│   CRC   │
+│ 4 bytes │
+└─────────┘
+*/
+
+const (
+	// Checkpoint文件类型标志位
+	checkpointMagic uint32 = 0x16D1C4E7
+	// Checkpoint文件版本号
+	checkpointVersion byte = 1
+	// 文件头大小
+	checkpointHeaderSize = 4 + 1 + 8 + 8
+)
+
+// WriteCheckpoint 把Cache和快照中排序去重后的数据写入checkpoint文件，pos为这些数据对应的WAL位置
+func (c *Cache) WriteCheckpoint(path string, pos wal.Position) error {
+	c.init()
+
+	// 先写入临时文件，完成后重命名
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if err := c.writeCheckpoint(f, pos); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *Cache) writeCheckpoint(f *os.File, pos wal.Position) error {
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriterSize(f, 1024*1024)
+	write := func(b []byte) error {
+		crc.Write(b)
+		_, err := w.Write(b)
+		return err
+	}
+
+	// 文件头
+	var hdr [checkpointHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], checkpointMagic)
+	hdr[4] = checkpointVersion
+	binary.LittleEndian.PutUint64(hdr[5:13], uint64(pos.SegmentID))
+	binary.LittleEndian.PutUint64(hdr[13:21], uint64(pos.Offset))
+	if err := write(hdr[:]); err != nil {
+		return err
+	}
+
+	// 按key顺序写入数据，包括正在写入TSM文件的快照，时间戳以差值方式变长编码
+	buf := make([]byte, 0, 4+binary.MaxVarintLen64)
+	for _, key := range c.allKeys() {
+		values := c.Values(key)
+		if len(values) == 0 {
+			continue
+		}
+		buf = buf[:4]
+		binary.LittleEndian.PutUint32(buf, key)
+		buf = appendUvarint(buf, uint64(len(values)))
+		if err := write(buf); err != nil {
+			return err
+		}
+
+		prev := int64(0)
+		for i, v := range values {
+			buf = buf[:0]
+			if i == 0 {
+				buf = appendVarint(buf, v.UnixNano)
+			} else {
+				buf = appendUvarint(buf, uint64(v.UnixNano-prev))
+			}
+			buf = append(buf, v.Value)
+			if err := write(buf); err != nil {
+				return err
+			}
+			prev = v.UnixNano
+		}
+	}
+
+	// 文件尾的CRC校验码
+	var sum [crc32.Size]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err := w.Write(sum[:]); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// ReadCheckpoint 校验并读取checkpoint文件，把数据写入Cache，返回其对应的WAL位置
+func (c *Cache) ReadCheckpoint(path string) (wal.Position, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return wal.Position{}, err
+	}
+
+	// 校验文件头和CRC
+	if len(b) < checkpointHeaderSize+crc32.Size {
+		return wal.Position{}, ErrCheckpointCorrupt
+	}
+	body, sum := b[:len(b)-crc32.Size], b[len(b)-crc32.Size:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(sum) {
+		return wal.Position{}, ErrCheckpointCorrupt
+	}
+	if binary.LittleEndian.Uint32(body[0:4]) != checkpointMagic {
+		return wal.Position{}, ErrCheckpointCorrupt
+	}
+	if body[4] != checkpointVersion {
+		return wal.Position{}, fmt.Errorf("unsupported checkpoint version: %d", body[4])
+	}
+	pos := wal.Position{
+		SegmentID: int(binary.LittleEndian.Uint64(body[5:13])),
+		Offset:    int64(binary.LittleEndian.Uint64(body[13:21])),
+	}
+
+	// 先完整解析，确认无误后再写入Cache
+	values := make(map[uint32][]coder.Value)
+	i := checkpointHeaderSize
+	for i < len(body) {
+		if i+4 > len(body) {
+			return wal.Position{}, ErrCheckpointCorrupt
+		}
+		key := binary.LittleEndian.Uint32(body[i : i+4])
+		i += 4
+		count, n := binary.Uvarint(body[i:])
+		if n <= 0 || count > uint64(len(body)) {
+			return wal.Position{}, ErrCheckpointCorrupt
+		}
+		i += n
+
+		vls := make([]coder.Value, count)
+		var ts int64
+		for j := range vls {
+			if j == 0 {
+				v, n := binary.Varint(body[i:])
+				if n <= 0 {
+					return wal.Position{}, ErrCheckpointCorrupt
+				}
+				ts = v
+				i += n
+			} else {
+				d, n := binary.Uvarint(body[i:])
+				if n <= 0 {
+					return wal.Position{}, ErrCheckpointCorrupt
+				}
+				ts += int64(d)
+				i += n
+			}
+			if i >= len(body) {
+				return wal.Position{}, ErrCheckpointCorrupt
+			}
+			vls[j] = coder.NewValue(ts, body[i])
+			i++
+		}
+		values[key] = vls
+	}
+
+	if err := c.WriteMulti(values); err != nil {
+		return wal.Position{}, err
+	}
+	return pos, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
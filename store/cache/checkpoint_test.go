@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/wal"
+)
+
+// checkpoint加载测试
+func TestCacheLoader_LoadWithCheckpoint(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	walDir := filepath.Join(dir, "wal")
+	ckpt := filepath.Join(dir, "cache.ckpt")
+
+	// 写入WAL和Cache
+	l := wal.NewWAL(walDir)
+	if err := l.Open(); err != nil {
+		t.Fatalf("open WAL fail: %v", err)
+	}
+	c := NewCache(0)
+	write := func(key uint32, ts int64, v byte) {
+		values := map[uint32][]coder.Value{key: {coder.NewValue(ts, v)}}
+		if _, err := l.WriteMulti(values); err != nil {
+			t.Fatalf("write WAL fail: %v", err)
+		}
+		if err := c.WriteMulti(values); err != nil {
+			t.Fatalf("write cache fail: %v", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		write(1, int64(i), byte(i))
+		write(2, int64(i*1000), byte(i+1))
+	}
+
+	// 关闭WAL后写checkpoint
+	if err := l.Close(); err != nil {
+		t.Fatalf("close WAL fail: %v", err)
+	}
+	if err := c.WriteCheckpoint(ckpt, l.Position()); err != nil {
+		t.Fatalf("write checkpoint fail: %v", err)
+	}
+
+	// 重新打开WAL，写入checkpoint之后的数据
+	l = wal.NewWAL(walDir)
+	if err := l.Open(); err != nil {
+		t.Fatalf("open WAL fail: %v", err)
+	}
+	write(1, 100, 100)
+	if err := l.Close(); err != nil {
+		t.Fatalf("close WAL fail: %v", err)
+	}
+
+	// 只回放checkpoint之后的WAL文件
+	files, err := wal.SegmentFileNames(walDir)
+	if err != nil {
+		t.Fatalf("list WAL fail: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("WAL files count error: %d", len(files))
+	}
+	loaded := NewCache(0)
+	loader := NewCacheLoader(files)
+	if err := loader.LoadWithCheckpoint(loaded, ckpt); err != nil {
+		t.Fatalf("failed to load cache: %v", err)
+	}
+	if loader.Stats.Entries != 1 {
+		t.Fatalf("replayed entries error: %d", loader.Stats.Entries)
+	}
+	if _, err := os.Stat(ckpt); !os.IsNotExist(err) {
+		t.Fatalf("expected checkpoint removed")
+	}
+	checkValues := func(cache *Cache) {
+		v1, v2 := cache.Values(1), cache.Values(2)
+		if len(v1) != 11 || len(v2) != 10 {
+			t.Fatalf("values count error: %d, %d", len(v1), len(v2))
+		}
+		if v1[10].UnixNano != 100 || v1[10].Value != 100 || v2[9].UnixNano != 9000 || v2[9].Value != 10 {
+			t.Fatalf("values error: %v, %v", v1[10], v2[9])
+		}
+	}
+	checkValues(loaded)
+
+	// 损坏的checkpoint回退到全量回放
+	if err := c.WriteCheckpoint(ckpt, wal.Position{SegmentID: 2}); err != nil {
+		t.Fatalf("write checkpoint fail: %v", err)
+	}
+	b, _ := ioutil.ReadFile(ckpt)
+	b[len(b)/2]++
+	if err := ioutil.WriteFile(ckpt, b, 0666); err != nil {
+		t.Fatalf("corrupt checkpoint fail: %v", err)
+	}
+	loaded = NewCache(0)
+	loader = NewCacheLoader(files)
+	if err := loader.LoadWithCheckpoint(loaded, ckpt); err != nil {
+		t.Fatalf("failed to load cache: %v", err)
+	}
+	if loader.Stats.Entries != 21 {
+		t.Fatalf("replayed entries error: %d", loader.Stats.Entries)
+	}
+	checkValues(loaded)
+}
+
+// 只在快照中的数据也要写入checkpoint
+func TestCache_WriteCheckpointSnapshot(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	ckpt := filepath.Join(dir, "cache.ckpt")
+
+	c := NewCache(0)
+	if err := c.Write(1, []int64{1, 2}, []byte{10, 20}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if _, err := c.Snapshot(); err != nil {
+		t.Fatalf("snapshot fail: %v", err)
+	}
+	if err := c.Write(2, []int64{3}, []byte{30}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	if err := c.WriteCheckpoint(ckpt, wal.Position{SegmentID: 1}); err != nil {
+		t.Fatalf("write checkpoint fail: %v", err)
+	}
+
+	loaded := NewCache(0)
+	pos, err := loaded.ReadCheckpoint(ckpt)
+	if err != nil {
+		t.Fatalf("read checkpoint fail: %v", err)
+	}
+	if pos.SegmentID != 1 {
+		t.Fatalf("checkpoint position error: %v", pos)
+	}
+	v1, v2 := loaded.Values(1), loaded.Values(2)
+	if len(v1) != 2 || v1[1].UnixNano != 2 || v1[1].Value != 20 || len(v2) != 1 || v2[0].Value != 30 {
+		t.Fatalf("values error: %v, %v", v1, v2)
+	}
+}
@@ -2,54 +2,66 @@ package cache
 
 import (
 	"sync"
+	"unsafe"
 
 	"github.com/hooone/datacc/store/coder"
 )
 
+const (
+	// coder.Value在内存中的实际大小(含对齐)
+	valueMemSize = int(unsafe.Sizeof(coder.Value{}))
+	// 每个key的固定开销：entry结构体、指针以及map桶中的key/value/tophash
+	entryMemOverhead = int(unsafe.Sizeof(entry{})) + 8 + 4 + 8 + 1
+)
+
 type entry struct {
 	mu     sync.RWMutex
 	values coder.Values
 }
 
-func newEntryValues(values []coder.Value) (*entry, error) {
+// 新建entry，同时返回其占用的内存大小
+func newEntryValues(values []coder.Value) (*entry, int, error) {
 	e := &entry{}
 	e.values = make([]coder.Value, 0, len(values))
 	e.values = append(e.values, values...)
 
-	// No values, don't check types and ordering
-	if len(values) == 0 {
-		return e, nil
-	}
-
-	return e, nil
+	return e, entryMemOverhead + cap(e.values)*valueMemSize, nil
 }
 
-func (e *entry) add(values []coder.Value) error {
+// 添加数据，返回新增占用的内存大小
+func (e *entry) add(values []coder.Value) (int, error) {
 	if len(values) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	e.mu.Lock()
+	before := cap(e.values)
 	if len(e.values) == 0 {
 		e.values = values
-		e.mu.Unlock()
-		return nil
+	} else {
+		e.values = append(e.values, values...)
 	}
-
-	e.values = append(e.values, values...)
+	grown := (cap(e.values) - before) * valueMemSize
 	e.mu.Unlock()
-	return nil
+	return grown, nil
 }
 
-// 加锁调用去重
-func (e *entry) deduplicate() {
+// 加锁调用去重，有重复数据被丢弃时收缩切片，返回释放的内存大小
+func (e *entry) deduplicate() int {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	if len(e.values) <= 1 {
-		return
+		return 0
+	}
+	before := cap(e.values)
+	values := e.values.Deduplicate()
+	if len(values) == len(e.values) {
+		e.values = values
+		return 0
 	}
-	e.values = e.values.Deduplicate()
+	e.values = append(make(coder.Values, 0, len(values)), values...)
+	return (before - cap(e.values)) * valueMemSize
 }
 
 func (e *entry) count() int {
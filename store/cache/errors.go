@@ -5,3 +5,11 @@ import "fmt"
 func ErrCacheMemorySizeLimitExceeded(n, limit uint64) error {
 	return fmt.Errorf("cache-max-memory-size exceeded: (%d/%d)", n, limit)
 }
+
+// checkpoint文件损坏
+var ErrCheckpointCorrupt = fmt.Errorf("cache checkpoint corrupt")
+
+// 超过全局内存预算
+func ErrMemoryBudgetExceeded(n, limit uint64) error {
+	return fmt.Errorf("cache memory budget exceeded: (%d/%d)", n, limit)
+}
@@ -14,8 +14,8 @@ type partition struct {
 	store map[uint32]*entry
 }
 
-// 区块写入- 线程安全
-func (p *partition) write(key uint32, values []coder.Value) (bool, error) {
+// 区块写入- 线程安全，返回是否新建了key以及新增占用的内存大小
+func (p *partition) write(key uint32, values []coder.Value) (bool, int, error) {
 	// 通过key获得entry
 	p.mu.RLock()
 	e := p.store[key]
@@ -23,7 +23,8 @@ func (p *partition) write(key uint32, values []coder.Value) (bool, error) {
 
 	// 在已有的entry中添加数据
 	if e != nil {
-		return false, e.add(values)
+		mem, err := e.add(values)
+		return false, mem, err
 	}
 
 	// key未存在，创建新的entry
@@ -32,17 +33,18 @@ func (p *partition) write(key uint32, values []coder.Value) (bool, error) {
 
 	// 再次确认当前key没有对应的entry
 	if e = p.store[key]; e != nil {
-		return false, e.add(values)
+		mem, err := e.add(values)
+		return false, mem, err
 	}
 
 	// 创建entry
-	e, err := newEntryValues(values)
+	e, mem, err := newEntryValues(values)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 
 	p.store[key] = e
-	return true, nil
+	return true, mem, nil
 }
 
 // reset 数据清空
@@ -38,7 +38,7 @@ func (r *ring) getPartition(key uint32) *partition {
 }
 
 // 数据写入
-func (r *ring) write(key uint32, ts []int64, values []byte) (bool, error) {
+func (r *ring) write(key uint32, ts []int64, values []byte) (bool, int, error) {
 	// 把数据封装成values
 	vls := make([]coder.Value, len(ts))
 	for i := 0; i < len(ts); i++ {
@@ -51,7 +51,7 @@ func (r *ring) write(key uint32, ts []int64, values []byte) (bool, error) {
 	return r.getPartition(key).write(key, vls)
 }
 
-func (r *ring) writeValues(key uint32, values []coder.Value) (bool, error) {
+func (r *ring) writeValues(key uint32, values []coder.Value) (bool, int, error) {
 	return r.getPartition(key).write(key, values)
 }
 
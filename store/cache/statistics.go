@@ -1,5 +1,7 @@
 package cache
 
+import "sync/atomic"
+
 // CacheStatistics 工作状态统计
 type CacheStatistics struct {
 	// 当前Cache占内存的总大小
@@ -9,4 +11,17 @@ type CacheStatistics struct {
 	WriteOK int64
 	// 写入失败计数
 	WriteErr int64
+
+	// 因订阅者消费过慢而丢弃的数据批次计数
+	SubscribeDropped int64
+}
+
+// 状态统计
+func (c *Cache) Statistics() CacheStatistics {
+	return CacheStatistics{
+		MemSizeBytes:     atomic.LoadInt64(&c.stats.MemSizeBytes),
+		WriteOK:          atomic.LoadInt64(&c.stats.WriteOK),
+		WriteErr:         atomic.LoadInt64(&c.stats.WriteErr),
+		SubscribeDropped: atomic.LoadInt64(&c.stats.SubscribeDropped),
+	}
 }
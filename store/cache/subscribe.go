@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 订阅者消费过慢时的处理策略
+type SubscribePolicy int
+
+const (
+	// 订阅通道已满时丢弃本次数据，并计入CacheStatistics.SubscribeDropped
+	SubscribeDrop SubscribePolicy = iota
+	// 订阅通道已满时断开订阅，关闭通道
+	SubscribeDisconnect
+)
+
+// 推送给订阅者的一批数据
+type SubscribeBatch struct {
+	Key    uint32
+	Values coder.Values
+}
+
+// 一个订阅，通过C接收所订阅key的新数据
+type Subscription struct {
+	// 数据接收通道，订阅被取消或断开后关闭
+	C <-chan SubscribeBatch
+
+	c      chan SubscribeBatch
+	keys   []uint32
+	closed bool
+	// 被丢弃的数据批次计数
+	dropped int64
+}
+
+// 被丢弃的数据批次数量
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// 订阅者容器
+type subscribers struct {
+	mu sync.RWMutex
+	// 订阅者的数量，用于无订阅时快速跳过
+	n int64
+	// 每个key的订阅者
+	byKey  map[uint32][]*Subscription
+	policy SubscribePolicy
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{
+		byKey: make(map[uint32][]*Subscription),
+	}
+}
+
+// SetSubscribePolicy 设置订阅者消费过慢时的处理策略
+func (c *Cache) SetSubscribePolicy(p SubscribePolicy) {
+	c.subs.mu.Lock()
+	c.subs.policy = p
+	c.subs.mu.Unlock()
+}
+
+// Subscribe 订阅keys的新数据。数据写入Cache后立即推送，buffer为通道的缓冲批次数
+func (c *Cache) Subscribe(keys []uint32, buffer int) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+	// 重复的key只订阅一次，否则同一数据会推送多次
+	uniq := make([]uint32, 0, len(keys))
+	seen := make(map[uint32]bool, len(keys))
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			uniq = append(uniq, k)
+		}
+	}
+	ch := make(chan SubscribeBatch, buffer)
+	s := &Subscription{
+		C:    ch,
+		c:    ch,
+		keys: uniq,
+	}
+
+	c.subs.mu.Lock()
+	for _, k := range s.keys {
+		c.subs.byKey[k] = append(c.subs.byKey[k], s)
+	}
+	atomic.AddInt64(&c.subs.n, 1)
+	c.subs.mu.Unlock()
+	return s
+}
+
+// Unsubscribe 取消订阅并关闭通道，重复调用是安全的
+func (c *Cache) Unsubscribe(s *Subscription) {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	// 从每个key的订阅列表中移除
+	for _, k := range s.keys {
+		list := c.subs.byKey[k]
+		for i := range list {
+			if list[i] == s {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(c.subs.byKey, k)
+		} else {
+			c.subs.byKey[k] = list
+		}
+	}
+	atomic.AddInt64(&c.subs.n, -1)
+	close(s.c)
+}
+
+// 把写入成功的数据推送给订阅者，不会阻塞写入
+func (c *Cache) publish(key uint32, fn func() coder.Values) {
+	if c.subs == nil || atomic.LoadInt64(&c.subs.n) == 0 {
+		return
+	}
+
+	var slow []*Subscription
+	c.subs.mu.RLock()
+	list := c.subs.byKey[key]
+	if len(list) == 0 {
+		c.subs.mu.RUnlock()
+		return
+	}
+
+	// 数据副本，多个订阅者共享
+	batch := SubscribeBatch{Key: key, Values: fn()}
+	for _, s := range list {
+		select {
+		case s.c <- batch:
+		default:
+			// 通道已满，按策略处理
+			atomic.AddInt64(&s.dropped, 1)
+			atomic.AddInt64(&c.stats.SubscribeDropped, 1)
+			if c.subs.policy == SubscribeDisconnect {
+				slow = append(slow, s)
+			}
+		}
+	}
+	c.subs.mu.RUnlock()
+
+	// 断开过慢的订阅者
+	for _, s := range slow {
+		c.Unsubscribe(s)
+	}
+}
@@ -0,0 +1,80 @@
+package coder
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// 数据块的编码方式
+func ByteEncoding(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0] >> 4
+}
+
+// DecodeBytes 把ByteEncoder编码的数据块解码后追加到dst
+func DecodeBytes(dst []byte, b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return dst, nil
+	}
+
+	switch b[0] >> 4 {
+	case byteCompressedRLE:
+		if len(b) < 4 {
+			return nil, fmt.Errorf("ByteDecoder: not enough data to decode RLE value")
+		}
+		// 第一个值和固定差值，编码时都加上了128
+		v := b[1] - 128
+		delta := b[2] - 128
+		count, n := binary.Uvarint(b[3:])
+		if n <= 0 {
+			return nil, fmt.Errorf("ByteDecoder: invalid RLE repeat value")
+		}
+		dst = append(dst, v)
+		for i := uint64(0); i < count; i++ {
+			v += delta
+			dst = append(dst, v)
+		}
+		return dst, nil
+
+	case byteCompressedSimple:
+		if len(b) < 3 || (len(b)-3)%4 != 0 {
+			return nil, fmt.Errorf("ByteDecoder: invalid packed block length %d", len(b))
+		}
+		// 差值的最小值和第一个值
+		min := b[1]
+		v := b[2] + min - 128
+		dst = append(dst, v)
+
+		// 主体数据为 差值+128-最小值
+		var buf [240]byte
+		for i := 3; i < len(b); i += 4 {
+			n, err := Decompress(&buf, binary.LittleEndian.Uint32(b[i:i+4]))
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range buf[:n] {
+				v += d + min - 128
+				dst = append(dst, v)
+			}
+		}
+		return dst, nil
+
+	default:
+		return nil, fmt.Errorf("ByteDecoder: unknown encoding %v", b[0]>>4)
+	}
+}
+
+// ByteRun 不展开地读取RLE编码的数据块: 第i个值为first+i*delta，共count个值。
+// 数据块不是RLE编码时ok为false
+func ByteRun(b []byte) (first, delta byte, count int, ok bool) {
+	if len(b) < 4 || b[0]>>4 != byteCompressedRLE {
+		return 0, 0, 0, false
+	}
+	n, i := binary.Uvarint(b[3:])
+	if i <= 0 {
+		return 0, 0, 0, false
+	}
+	return b[1] - 128, b[2] - 128, int(n) + 1, true
+}
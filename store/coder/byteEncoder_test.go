@@ -69,3 +69,68 @@ func TestByteCoder_EncodeRLE(t *testing.T) {
 		t.Fatalf("byte encode method error: except %d,actual %d", byteCompressedRLE, bts[0]>>4)
 	}
 }
+
+// 编码解码往返测试
+func TestByteCoder_Decode(t *testing.T) {
+	cases := [][]byte{
+		{7},
+		{10, 20},
+		{10, 20, 30, 40, 50, 60},
+		{20, 30, 40, 70, 80, 90},
+		{255, 0, 128, 1, 254, 3, 3, 3, 3},
+	}
+	// 大量重复数据
+	long := make([]byte, 1000)
+	for i := range long {
+		long[i] = byte(i / 300)
+	}
+	cases = append(cases, long)
+
+	for _, src := range cases {
+		en := NewByteEncoder(len(src))
+		for _, v := range src {
+			en.Write(v)
+		}
+		bts, err := en.Bytes()
+		if err != nil {
+			t.Fatalf("byte encode fail: %v", err)
+		}
+		got, err := DecodeBytes(nil, bts)
+		if err != nil {
+			t.Fatalf("byte decode fail: %v", err)
+		}
+		if len(got) != len(src) {
+			t.Fatalf("byte decode count error: except %d, actual %d", len(src), len(got))
+		}
+		for i := range src {
+			if got[i] != src[i] {
+				t.Fatalf("byte decode error. index: %d, except %d, actual %d", i, src[i], got[i])
+			}
+		}
+	}
+}
+
+// RLE编码的数据块不展开读取
+func TestByteRun(t *testing.T) {
+	enc := NewByteEncoder(10)
+	for i := 0; i < 10; i++ {
+		enc.Write(byte(250 + i*3))
+	}
+	b, err := enc.Bytes()
+	if err != nil {
+		t.Fatalf("encode fail: %v", err)
+	}
+	first, delta, count, ok := ByteRun(b)
+	if !ok || first != 250 || delta != 3 || count != 10 {
+		t.Fatalf("byte run error: %d %d %d %v", first, delta, count, ok)
+	}
+	values, err := DecodeBytes(nil, b)
+	if err != nil {
+		t.Fatalf("decode fail: %v", err)
+	}
+	for i, v := range values {
+		if v != first+byte(i)*delta {
+			t.Fatalf("value error. index %d: except %d, actual %d", i, first+byte(i)*delta, v)
+		}
+	}
+}
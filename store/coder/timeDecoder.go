@@ -0,0 +1,181 @@
+package coder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/jwilder/encoding/simple8b"
+)
+
+// 时间戳数据块的编码方式
+func TimeEncoding(b []byte) byte {
+	if len(b) == 0 {
+		return timeUncompressed
+	}
+	return b[0] >> 4
+}
+
+// CountTimestamps 不解码直接获得时间戳数据块中的时间戳个数
+func CountTimestamps(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	switch b[0] >> 4 {
+	case timeUncompressed:
+		return (len(b) - 1) / 8, nil
+	case timeCompressedRLE:
+		// 跳过第一个时间戳和步长
+		i := 9
+		if len(b) < i {
+			return 0, fmt.Errorf("TimeDecoder: not enough data to decode RLE starting value")
+		}
+		_, n := binary.Uvarint(b[i:])
+		if n <= 0 {
+			return 0, fmt.Errorf("TimeDecoder: invalid RLE delta value")
+		}
+		i += n
+		count, n := binary.Uvarint(b[i:])
+		if n <= 0 {
+			return 0, fmt.Errorf("TimeDecoder: invalid RLE repeat value")
+		}
+		return int(count), nil
+	case timeCompressedPackedSimple:
+		if len(b) < 17 {
+			return 0, fmt.Errorf("TimeDecoder: not enough data to decode packed timestamps")
+		}
+		count, err := simple8b.CountBytes(b[17:])
+		if err != nil {
+			return 0, err
+		}
+		return count + 1, nil
+	default:
+		return 0, fmt.Errorf("TimeDecoder: unknown encoding %v", b[0]>>4)
+	}
+}
+
+// DecodeTimestamps 把时间戳数据块解码后追加到dst
+func DecodeTimestamps(dst []int64, b []byte) ([]int64, error) {
+	if len(b) == 0 {
+		return dst, nil
+	}
+
+	// byte 0 的低位代表末尾为0的个数
+	div := uint64(math.Pow10(int(b[0] & 0x0F)))
+
+	switch b[0] >> 4 {
+	case timeUncompressed:
+		// 第一个时间戳之后存放的是时间戳差值
+		if (len(b)-1)%8 != 0 {
+			return nil, fmt.Errorf("TimeDecoder: invalid uncompressed block length %d", len(b))
+		}
+		var prev uint64
+		for i := 1; i < len(b); i += 8 {
+			v := binary.LittleEndian.Uint64(b[i : i+8])
+			if i > 1 {
+				v += prev
+			}
+			dst = append(dst, int64(v))
+			prev = v
+		}
+		return dst, nil
+
+	case timeCompressedRLE:
+		if len(b) < 9 {
+			return nil, fmt.Errorf("TimeDecoder: not enough data to decode RLE starting value")
+		}
+		// 第一个时间戳
+		first := binary.LittleEndian.Uint64(b[1:9])
+		i := 9
+		// 时间戳步长
+		delta, n := binary.Uvarint(b[i:])
+		if n <= 0 {
+			return nil, fmt.Errorf("TimeDecoder: invalid RLE delta value")
+		}
+		i += n
+		delta *= div
+		// 时间戳数量
+		count, n := binary.Uvarint(b[i:])
+		if n <= 0 {
+			return nil, fmt.Errorf("TimeDecoder: invalid RLE repeat value")
+		}
+		for j := uint64(0); j < count; j++ {
+			dst = append(dst, int64(first+delta*j))
+		}
+		return dst, nil
+
+	case timeCompressedPackedSimple:
+		if len(b) < 17 {
+			return nil, fmt.Errorf("TimeDecoder: not enough data to decode packed timestamps")
+		}
+		// 第一个时间戳和差值的最小值
+		prev := binary.LittleEndian.Uint64(b[1:9])
+		min := binary.LittleEndian.Uint64(b[9:17])
+		dst = append(dst, int64(prev))
+
+		// 主体数据为 (差值-最小值)/div
+		dec := simple8b.NewDecoder(b[17:])
+		for dec.Next() {
+			prev += dec.Read()*div + min
+			dst = append(dst, int64(prev))
+		}
+		return dst, nil
+
+	default:
+		return nil, fmt.Errorf("TimeDecoder: unknown encoding %v", b[0]>>4)
+	}
+}
+
+// DecodeTimeDeltas 不累加时间戳，直接取出编码时计算的相邻时间戳差值，追加到dst。
+// RLE编码的数据块只有一个差值，重复count-1次
+func DecodeTimeDeltas(dst []int64, b []byte) ([]int64, error) {
+	if len(b) == 0 {
+		return dst, nil
+	}
+	div := uint64(math.Pow10(int(b[0] & 0x0F)))
+
+	switch b[0] >> 4 {
+	case timeUncompressed:
+		if (len(b)-1)%8 != 0 {
+			return nil, fmt.Errorf("TimeDecoder: invalid uncompressed block length %d", len(b))
+		}
+		for i := 9; i < len(b); i += 8 {
+			dst = append(dst, int64(binary.LittleEndian.Uint64(b[i:i+8])))
+		}
+		return dst, nil
+
+	case timeCompressedRLE:
+		if len(b) < 9 {
+			return nil, fmt.Errorf("TimeDecoder: not enough data to decode RLE starting value")
+		}
+		i := 9
+		delta, n := binary.Uvarint(b[i:])
+		if n <= 0 {
+			return nil, fmt.Errorf("TimeDecoder: invalid RLE delta value")
+		}
+		i += n
+		count, n := binary.Uvarint(b[i:])
+		if n <= 0 {
+			return nil, fmt.Errorf("TimeDecoder: invalid RLE repeat value")
+		}
+		for j := uint64(1); j < count; j++ {
+			dst = append(dst, int64(delta*div))
+		}
+		return dst, nil
+
+	case timeCompressedPackedSimple:
+		if len(b) < 17 {
+			return nil, fmt.Errorf("TimeDecoder: not enough data to decode packed timestamps")
+		}
+		min := binary.LittleEndian.Uint64(b[9:17])
+		dec := simple8b.NewDecoder(b[17:])
+		for dec.Next() {
+			dst = append(dst, int64(dec.Read()*div+min))
+		}
+		return dst, nil
+
+	default:
+		return nil, fmt.Errorf("TimeDecoder: unknown encoding %v", b[0]>>4)
+	}
+}
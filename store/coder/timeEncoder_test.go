@@ -77,3 +77,54 @@ func TestTimeCoder_EncodeRLE(t *testing.T) {
 		t.Fatalf("timestamps encode div error: except 3,actual %d", bts[0]>>4)
 	}
 }
+
+// 编码解码往返测试
+func TestTimeCoder_Decode(t *testing.T) {
+	cases := [][]int64{
+		{1000},
+		{1000, 2000, 3000, 4000, 5000, 6000},
+		{1000, 2000, 4000, 6000, 7000, 8000},
+		{1, 5, 6, 100, 101, 1 << 40},
+		{0, 1 << 62},
+	}
+	for ci, src := range cases {
+		en := NewTimeEncoder(len(src))
+		for _, v := range src {
+			en.Write(v)
+		}
+		bts, err := en.Bytes()
+		if err != nil {
+			t.Fatalf("timestamps encode fail: %v", err)
+		}
+		n, err := CountTimestamps(bts)
+		if err != nil || n != len(src) {
+			t.Fatalf("timestamps count error. case %d: except %d, actual %d, %v", ci, len(src), n, err)
+		}
+		got, err := DecodeTimestamps(nil, bts)
+		if err != nil {
+			t.Fatalf("timestamps decode fail: %v", err)
+		}
+		if len(got) != len(src) {
+			t.Fatalf("timestamps decode count error. case %d: except %d, actual %d", ci, len(src), len(got))
+		}
+		for i := range src {
+			if got[i] != src[i] {
+				t.Fatalf("timestamps decode error. case %d, index: %d, except %d, actual %d", ci, i, src[i], got[i])
+			}
+		}
+
+		// 差值流
+		deltas, err := DecodeTimeDeltas(nil, bts)
+		if err != nil {
+			t.Fatalf("time deltas decode fail: %v", err)
+		}
+		if len(deltas) != len(src)-1 {
+			t.Fatalf("time deltas count error. case %d: except %d, actual %d", ci, len(src)-1, len(deltas))
+		}
+		for i, d := range deltas {
+			if d != src[i+1]-src[i] {
+				t.Fatalf("time delta error. case %d, index: %d, except %d, actual %d", ci, i, src[i+1]-src[i], d)
+			}
+		}
+	}
+}
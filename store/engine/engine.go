@@ -0,0 +1,312 @@
+// engine 组合WAL、Cache和TSM文件的存储引擎。
+// 写入先进入Cache和WAL，Cache超过大小或长时间没有写入时写成TSM文件，并删除对应的WAL文件
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hooone/datacc/dlog"
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/lsm"
+	"github.com/hooone/datacc/store/wal"
+)
+
+const (
+	// Cache数据量超过该值时写入TSM文件
+	DefaultCacheSnapshotMemorySize = 25 * 1024 * 1024
+	// Cache超过该时长没有写入时写入TSM文件
+	DefaultCacheSnapshotWriteColdDuration = 10 * time.Minute
+	// Cache的数据量上限
+	DefaultCacheMaxMemorySize = 1024 * 1024 * 1024
+
+	// 检查是否需要写快照的间隔
+	snapshotCheckInterval = time.Second
+	// WAL文件所在的子目录
+	walDir = "wal"
+	// 关闭时写入的Cache checkpoint文件
+	checkpointFile = "cache.ckpt"
+)
+
+// Engine 存储引擎
+type Engine struct {
+	path string
+
+	WAL       *wal.WAL
+	Cache     *cache.Cache
+	FileStore *lsm.FileStore
+	Compactor *lsm.Compactor
+
+	// 写快照的条件
+	CacheSnapshotMemorySize        uint64
+	CacheSnapshotWriteColdDuration time.Duration
+
+	// 写入时合并存储的布尔key组，在Open之前设置。打开时从合并key最新的已存储值恢复各组状态
+	BitGroups []*cache.BitGroup
+
+	// 进程级的Cache内存预算，可由多个引擎共享。为nil时只受Cache自身的上限限制
+	MemoryBudget *cache.MemoryBudget
+
+	Logger dlog.Logger
+
+	// 最近一次打开时的WAL回放统计
+	loadStats cache.LoadStatistics
+
+	// 写入持读锁，切换WAL文件和Cache快照时持写锁，保证快照与WAL文件对应
+	mu sync.RWMutex
+	// 同一时间只写一个快照
+	snapshotMu sync.Mutex
+	// 最近写入时间，UnixNano
+	lastWrite int64
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// 状态统计
+type Statistics struct {
+	Load      cache.LoadStatistics
+	WAL       wal.WALStatistics
+	Cache     cache.CacheStatistics
+	FileStore lsm.FileStoreStatistics
+}
+
+// NewEngine 新建path目录下的存储引擎，TSM文件在path下，WAL文件在path/wal下
+func NewEngine(path string) *Engine {
+	fs := lsm.NewFileStore(path)
+	compactor := lsm.NewCompactor()
+	compactor.Dir = path
+	compactor.FileStore = fs
+	// WAL刷盘变慢时降低TSM文件的写入速度
+	w := wal.NewWAL(filepath.Join(path, walDir))
+	w.SetSyncObserver(compactor.ObserveWALSync)
+	return &Engine{
+		path:      path,
+		WAL:       w,
+		Cache:     cache.NewCache(DefaultCacheMaxMemorySize),
+		FileStore: fs,
+		Compactor: compactor,
+
+		CacheSnapshotMemorySize:        DefaultCacheSnapshotMemorySize,
+		CacheSnapshotWriteColdDuration: DefaultCacheSnapshotWriteColdDuration,
+
+		Logger: dlog.NewNop(),
+	}
+}
+
+// 数据目录
+func (e *Engine) Path() string {
+	return e.path
+}
+
+// Open 打开TSM文件，读取checkpoint并回放之后的WAL恢复Cache，然后开始定时写快照
+func (e *Engine) Open() error {
+	if err := os.MkdirAll(e.path, 0777); err != nil {
+		return err
+	}
+	if err := e.FileStore.Open(); err != nil {
+		return err
+	}
+	e.Compactor.Open()
+
+	if err := e.WAL.Open(); err != nil {
+		return err
+	}
+	segments, err := wal.SegmentFileNames(filepath.Join(e.path, walDir))
+	if err != nil {
+		return err
+	}
+	loader := cache.NewCacheLoader(segments)
+	loader.Logger = e.Logger
+	if err := loader.LoadWithCheckpoint(e.Cache, filepath.Join(e.path, checkpointFile)); err != nil {
+		return err
+	}
+	e.loadStats = loader.Stats
+	if err := e.seedBitGroups(); err != nil {
+		return err
+	}
+	if e.MemoryBudget != nil {
+		e.MemoryBudget.Register(e.path, e.Cache)
+	}
+
+	atomic.StoreInt64(&e.lastWrite, time.Now().UnixNano())
+	e.closing = make(chan struct{})
+	e.wg.Add(1)
+	go e.snapshotLoop()
+	return nil
+}
+
+// 用合并key在Cache和TSM文件中最新的值恢复各组的位状态
+func (e *Engine) seedBitGroups() error {
+	for _, g := range e.BitGroups {
+		last, ok, err := e.FileStore.LastValue(g.Key())
+		if err != nil {
+			return err
+		}
+		if values := e.Cache.Values(g.Key()); len(values) > 0 {
+			if v := values[len(values)-1]; !ok || v.UnixNano >= last.UnixNano {
+				last, ok = v, true
+			}
+		}
+		if ok {
+			g.Seed(last.Value)
+		}
+	}
+	return nil
+}
+
+// Close 停止写快照并关闭所有文件。Cache中的数据保留在WAL中，并写入checkpoint以便下次打开时少回放WAL
+func (e *Engine) Close() error {
+	if e.closing != nil {
+		close(e.closing)
+		e.wg.Wait()
+		e.closing = nil
+	}
+	e.Compactor.Close()
+	if e.MemoryBudget != nil {
+		e.MemoryBudget.Unregister(e.path)
+	}
+
+	// 阻塞写入，checkpoint包含WAL关闭位置之前的所有数据
+	e.snapshotMu.Lock()
+	e.mu.Lock()
+	err := e.WAL.Close()
+	if err == nil {
+		err = e.Cache.WriteCheckpoint(filepath.Join(e.path, checkpointFile), e.WAL.Position())
+	}
+	e.mu.Unlock()
+	e.snapshotMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return e.FileStore.Close()
+}
+
+// WritePoints 写入多个key的数据。先写入WAL并等待刷盘，成功后再写入Cache
+func (e *Engine) WritePoints(values map[uint32][]coder.Value) error {
+	return e.WritePointsContext(context.Background(), values)
+}
+
+// WritePointsContext 与WritePoints相同，但开始写入前ctx已结束时不写入，返回ctx.Err()。
+// 开始写入后不再检查ctx，因此返回ctx.Err()时数据一定没有写入，返回nil时一定已写入
+func (e *Engine) WritePointsContext(ctx context.Context, values map[uint32][]coder.Value) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, g := range e.BitGroups {
+		values = g.Pack(values)
+	}
+
+	// WAL写入失败时数据不进入Cache，不会被查询到或写入快照
+	if _, err := e.WAL.WriteMulti(values); err != nil {
+		return err
+	}
+	if err := e.Cache.WriteMulti(values); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&e.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// WriteSnapshot 把Cache写成TSM文件，成功后删除已关闭的WAL文件
+func (e *Engine) WriteSnapshot() error {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	// 切换WAL文件和Cache快照时阻塞写入，已关闭的WAL文件中的数据都在快照中
+	e.mu.Lock()
+	segments, snapshot, err := func() ([]string, *cache.Cache, error) {
+		if err := e.WAL.CloseSegment(); err != nil {
+			return nil, nil, err
+		}
+		segments, err := e.WAL.ClosedSegments()
+		if err != nil {
+			return nil, nil, err
+		}
+		snapshot, err := e.Cache.Snapshot()
+		if err != nil {
+			return nil, nil, err
+		}
+		return segments, snapshot, nil
+	}()
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if snapshot.Size() > 0 {
+		snapshot.Deduplicate()
+		files, err := e.Compactor.WriteSnapshot(snapshot)
+		if err == nil {
+			err = e.FileStore.Replace(nil, files)
+		}
+		if err != nil {
+			e.Cache.ClearSnapshot(false)
+			return fmt.Errorf("write snapshot: %v", err)
+		}
+	}
+	e.Cache.ClearSnapshot(true)
+	return e.WAL.Remove(segments)
+}
+
+// 是否需要写快照: Cache超过大小，或有数据且长时间没有写入
+func (e *Engine) shouldSnapshot() bool {
+	size := e.Cache.Size()
+	if size == 0 {
+		return false
+	}
+	lastWrite := time.Unix(0, atomic.LoadInt64(&e.lastWrite))
+	return size > e.CacheSnapshotMemorySize || time.Since(lastWrite) > e.CacheSnapshotWriteColdDuration
+}
+
+// 定时检查并写快照
+func (e *Engine) snapshotLoop() {
+	defer e.wg.Done()
+	t := time.NewTicker(snapshotCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-e.closing:
+			return
+		case <-t.C:
+			if !e.shouldSnapshot() {
+				continue
+			}
+			if err := e.WriteSnapshot(); err != nil {
+				e.Logger.Error(fmt.Sprintf("engine: %v", err))
+			}
+		}
+	}
+}
+
+// 状态统计
+func (e *Engine) Statistics() Statistics {
+	return Statistics{
+		Load:      e.loadStats,
+		WAL:       e.WAL.Statistics(),
+		Cache:     e.Cache.Statistics(),
+		FileStore: fileStoreStatistics(e.FileStore.Statistics()),
+	}
+}
+
+// 原子地复制文件统计
+func fileStoreStatistics(s *lsm.FileStoreStatistics) lsm.FileStoreStatistics {
+	return lsm.FileStoreStatistics{
+		Files:               atomic.LoadInt64(&s.Files),
+		KeyRangeSkips:       atomic.LoadInt64(&s.KeyRangeSkips),
+		BloomSkips:          atomic.LoadInt64(&s.BloomSkips),
+		BloomFalsePositives: atomic.LoadInt64(&s.BloomFalsePositives),
+		BlockCacheHits:      atomic.LoadInt64(&s.BlockCacheHits),
+		BlockCacheMisses:    atomic.LoadInt64(&s.BlockCacheMisses),
+		BlockCacheSize:      atomic.LoadInt64(&s.BlockCacheSize),
+	}
+}
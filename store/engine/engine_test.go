@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hooone/datacc/common/limiter"
+	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/coder"
+	"github.com/hooone/datacc/store/wal"
+)
+
+// 写快照后数据在TSM文件中，之后的写入在重新打开时从WAL恢复
+func TestEngine_SnapshotAndRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine-")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := NewEngine(dir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("open engine fail: %v", err)
+	}
+	if err := e.WritePoints(map[uint32][]coder.Value{
+		1: {coder.NewValue(10, 1), coder.NewValue(20, 2)},
+		2: {coder.NewValue(10, 3)},
+	}); err != nil {
+		t.Fatalf("write points fail: %v", err)
+	}
+	// WAL刷盘耗时反馈给压缩限流
+	if rate, ok := e.Compactor.RateLimit.(*limiter.AdaptiveRate); !ok || rate.Latency() == 0 {
+		t.Fatalf("wal sync latency not observed by compactor rate limit")
+	}
+	if err := e.WriteSnapshot(); err != nil {
+		t.Fatalf("write snapshot fail: %v", err)
+	}
+	if n := len(e.FileStore.Files()); n != 1 {
+		t.Fatalf("tsm files error: %d", n)
+	}
+	if e.Cache.Size() != 0 {
+		t.Fatalf("cache not empty after snapshot: %d", e.Cache.Size())
+	}
+	if segments, _ := wal.SegmentFileNames(filepath.Join(dir, walDir)); len(segments) != 1 {
+		t.Fatalf("wal segments after snapshot error: %v", segments)
+	}
+
+	if err := e.WritePoints(map[uint32][]coder.Value{1: {coder.NewValue(30, 4)}}); err != nil {
+		t.Fatalf("write points fail: %v", err)
+	}
+	stats := e.Statistics()
+	if stats.WAL.WriteOK != 2 || stats.Cache.WriteOK != 2 || stats.FileStore.Files != 1 {
+		t.Fatalf("statistics error: %+v", stats)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("close engine fail: %v", err)
+	}
+
+	e = NewEngine(dir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("reopen engine fail: %v", err)
+	}
+	defer e.Close()
+	if values := e.Cache.Values(1); len(values) != 1 || values[0].UnixNano != 30 || values[0].Value != 4 {
+		t.Fatalf("recovered cache error: %v", values)
+	}
+	values, err := e.FileStore.Files()[0].ReadAll(1)
+	if err != nil || len(values) != 2 {
+		t.Fatalf("tsm values error: %v %v", values, err)
+	}
+}
+
+// 关闭时写入checkpoint，重新打开时只回放checkpoint位置之后的WAL
+func TestEngine_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine-")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := NewEngine(dir)
+	budget := cache.NewMemoryBudget(0, 0)
+	e.MemoryBudget = budget
+	if err := e.Open(); err != nil {
+		t.Fatalf("open engine fail: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := e.WritePoints(map[uint32][]coder.Value{1: {coder.NewValue(int64(i), byte(i))}}); err != nil {
+			t.Fatalf("write points fail: %v", err)
+		}
+	}
+	if budget.Used() == 0 {
+		t.Fatalf("cache not registered to memory budget")
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("close engine fail: %v", err)
+	}
+	if len(budget.Usage()) != 0 {
+		t.Fatalf("cache not unregistered from memory budget")
+	}
+	if _, err := os.Stat(filepath.Join(dir, checkpointFile)); err != nil {
+		t.Fatalf("checkpoint not written: %v", err)
+	}
+
+	// checkpoint之后写入新的WAL文件
+	l := wal.NewWAL(filepath.Join(dir, walDir))
+	if err := l.Open(); err != nil {
+		t.Fatalf("open WAL fail: %v", err)
+	}
+	if _, err := l.WriteMulti(map[uint32][]coder.Value{2: {coder.NewValue(100, 1)}}); err != nil {
+		t.Fatalf("write WAL fail: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("close WAL fail: %v", err)
+	}
+
+	e = NewEngine(dir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("reopen engine fail: %v", err)
+	}
+	defer e.Close()
+	if stats := e.Statistics().Load; stats.Entries != 1 {
+		t.Fatalf("replayed entries error: %+v", stats)
+	}
+	if v1, v2 := e.Cache.Values(1), e.Cache.Values(2); len(v1) != 10 || len(v2) != 1 {
+		t.Fatalf("recovered cache error: %v, %v", v1, v2)
+	}
+}
+
+// WAL写入失败时数据不进入Cache
+func TestEngine_WriteWALFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine-")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := NewEngine(dir)
+	if err := e.Open(); err != nil {
+		t.Fatalf("open engine fail: %v", err)
+	}
+	defer e.Close()
+
+	if err := e.WAL.Close(); err != nil {
+		t.Fatalf("close wal fail: %v", err)
+	}
+	if err := e.WritePoints(map[uint32][]coder.Value{1: {coder.NewValue(10, 1)}}); err != wal.ErrWALClosed {
+		t.Fatalf("expected wal closed, got %v", err)
+	}
+	if n := e.Cache.Size(); n != 0 {
+		t.Fatalf("failed write should not be in cache: %d", n)
+	}
+}
+
+// 布尔key组合并存储，重新打开后从TSM文件和Cache中最新的值恢复状态
+func TestEngine_BitGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "engine-")
+	if err != nil {
+		t.Fatalf("create temp dir fail: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func() *Engine {
+		g, err := cache.NewBitGroup(100, [8]uint32{1, 2})
+		if err != nil {
+			t.Fatalf("new bit group fail: %v", err)
+		}
+		e := NewEngine(dir)
+		e.BitGroups = []*cache.BitGroup{g}
+		if err := e.Open(); err != nil {
+			t.Fatalf("open engine fail: %v", err)
+		}
+		return e
+	}
+	last := func(e *Engine) coder.Value {
+		values := e.Cache.Values(100)
+		if len(values) == 0 || len(e.Cache.Values(1)) != 0 {
+			t.Fatalf("packed values error: %v", values)
+		}
+		return values[len(values)-1]
+	}
+
+	e := open()
+	if err := e.WritePoints(map[uint32][]coder.Value{1: {coder.NewValue(10, 1)}}); err != nil {
+		t.Fatalf("write points fail: %v", err)
+	}
+	if err := e.WriteSnapshot(); err != nil {
+		t.Fatalf("write snapshot fail: %v", err)
+	}
+	e.Close()
+
+	// 状态从TSM文件恢复
+	e = open()
+	if err := e.WritePoints(map[uint32][]coder.Value{2: {coder.NewValue(20, 1)}}); err != nil {
+		t.Fatalf("write points fail: %v", err)
+	}
+	if v := last(e); v != coder.NewValue(20, 0x03) {
+		t.Fatalf("state from tsm error: %v", v)
+	}
+	e.Close()
+
+	// 状态从Cache恢复
+	e = open()
+	defer e.Close()
+	if err := e.WritePoints(map[uint32][]coder.Value{1: {coder.NewValue(30, 0)}}); err != nil {
+		t.Fatalf("write points fail: %v", err)
+	}
+	if v := last(e); v != coder.NewValue(30, 0x02) {
+		t.Fatalf("state from cache error: %v", v)
+	}
+}
@@ -0,0 +1,91 @@
+package lsm
+
+// 一组数据的聚合结果
+type BlockAggregate struct {
+	Count    uint64
+	Min, Max byte
+	Sum      uint64
+	// 第一个和最后一个数据
+	First, Last         byte
+	FirstTime, LastTime int64
+
+	// 直接使用Index统计值的block数量和需要解码的block数量
+	StatsBlocks, DecodedBlocks int
+}
+
+// 平均值
+func (a *BlockAggregate) Mean() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return float64(a.Sum) / float64(a.Count)
+}
+
+// 合并一个block的统计值
+func (a *BlockAggregate) addEntry(e *IndexEntry) {
+	if e.Count == 0 {
+		return
+	}
+	if a.Count == 0 || e.MinValue < a.Min {
+		a.Min = e.MinValue
+	}
+	if a.Count == 0 || e.MaxValue > a.Max {
+		a.Max = e.MaxValue
+	}
+	if a.Count == 0 || e.MinTime < a.FirstTime {
+		a.First, a.FirstTime = e.FirstValue, e.MinTime
+	}
+	if a.Count == 0 || e.MaxTime >= a.LastTime {
+		a.Last, a.LastTime = e.LastValue, e.MaxTime
+	}
+	a.Count += uint64(e.Count)
+	a.Sum += e.Sum
+}
+
+// 合并一个数据点
+func (a *BlockAggregate) addValue(t int64, v byte) {
+	a.addEntry(&IndexEntry{
+		MinTime:    t,
+		MaxTime:    t,
+		Count:      1,
+		MinValue:   v,
+		MaxValue:   v,
+		FirstValue: v,
+		LastValue:  v,
+		Sum:        uint64(v),
+	})
+}
+
+// Aggregate 计算key在本文件时间范围[min, max]内的count、min、max、sum、first和last。
+// 完全处于时间范围内的block直接使用Index中的统计值，不解码数据。
+// 只统计本文件，不处理更新的文件和Cache中相同时间戳的数据，查询时使用query.Aggregate
+func (r *TSMReader) Aggregate(key uint32, min, max int64) (BlockAggregate, error) {
+	var agg BlockAggregate
+	entries := r.Entries(key)
+	for i := range entries {
+		e := &entries[i]
+		if !e.OverlapsTimeRange(min, max) {
+			continue
+		}
+
+		// 使用block统计值
+		if r.HasStats() && e.Within(min, max) {
+			agg.addEntry(e)
+			agg.StatsBlocks++
+			continue
+		}
+
+		// 解码部分重叠的block
+		values, err := r.ReadValues(key, e, nil)
+		if err != nil {
+			return agg, err
+		}
+		for _, v := range values {
+			if v.UnixNano >= min && v.UnixNano <= max {
+				agg.addValue(v.UnixNano, v.Value)
+			}
+		}
+		agg.DecodedBlocks++
+	}
+	return agg, nil
+}
@@ -0,0 +1,160 @@
+package lsm
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 默认的解码block缓存大小
+const DefaultBlockCacheSize = 64 * 1024 * 1024
+
+// 每个解码后的值占用的内存
+const blockCacheValueSize = int(unsafe.Sizeof(coder.Value{}))
+
+// 缓存的block由文件、位置和大小确定
+type blockCacheKey struct {
+	path   string
+	offset int64
+	size   uint32
+}
+
+type blockCacheItem struct {
+	key    blockCacheKey
+	values []coder.Value
+}
+
+// BlockCache 按大小限制的LRU缓存，保存解码后的block数据
+type BlockCache struct {
+	mu sync.Mutex
+
+	// 缓存大小上限，为0时不缓存
+	limit int
+	// 当前缓存的大小
+	size int
+
+	// 最近使用的在前
+	ll    *list.List
+	items map[blockCacheKey]*list.Element
+
+	// 状态统计
+	stats BlockCacheStatistics
+}
+
+// BlockCacheStatistics 缓存的状态统计
+type BlockCacheStatistics struct {
+	// 命中次数
+	Hits int64
+	// 未命中次数
+	Misses int64
+	// 因超过大小被淘汰的block数
+	Evictions int64
+	// 缓存的大小
+	Size int64
+	// 缓存的block数
+	Blocks int64
+}
+
+func NewBlockCache(limit int) *BlockCache {
+	return &BlockCache{
+		limit: limit,
+		ll:    list.New(),
+		items: make(map[blockCacheKey]*list.Element),
+	}
+}
+
+// 取出缓存的block，结果不可修改
+func (c *BlockCache) Get(path string, offset int64, size uint32) ([]coder.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[blockCacheKey{path, offset, size}]; ok {
+		c.ll.MoveToFront(e)
+		atomic.AddInt64(&c.stats.Hits, 1)
+		return e.Value.(*blockCacheItem).values, true
+	}
+	atomic.AddInt64(&c.stats.Misses, 1)
+	return nil, false
+}
+
+// 缓存一个解码后的block，缓存后values不可再修改
+func (c *BlockCache) Put(path string, offset int64, size uint32, values []coder.Value) {
+	n := len(values) * blockCacheValueSize
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > c.limit {
+		return
+	}
+	key := blockCacheKey{path, offset, size}
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&blockCacheItem{key: key, values: values})
+	c.size += n
+	c.evict()
+}
+
+// 移除文件的所有block，在文件被替换或删除时调用
+func (c *BlockCache) RemoveFile(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if key.path == path {
+			c.remove(e)
+		}
+	}
+	c.updateSize()
+}
+
+// 修改缓存大小上限
+func (c *BlockCache) SetLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit = limit
+	c.evict()
+}
+
+// 缓存的大小
+func (c *BlockCache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// 状态统计
+func (c *BlockCache) Statistics() BlockCacheStatistics {
+	return BlockCacheStatistics{
+		Hits:      atomic.LoadInt64(&c.stats.Hits),
+		Misses:    atomic.LoadInt64(&c.stats.Misses),
+		Evictions: atomic.LoadInt64(&c.stats.Evictions),
+		Size:      atomic.LoadInt64(&c.stats.Size),
+		Blocks:    atomic.LoadInt64(&c.stats.Blocks),
+	}
+}
+
+// 淘汰最久未使用的block直到不超过上限
+func (c *BlockCache) evict() {
+	for c.size > c.limit {
+		e := c.ll.Back()
+		if e == nil {
+			break
+		}
+		c.remove(e)
+		atomic.AddInt64(&c.stats.Evictions, 1)
+	}
+	c.updateSize()
+}
+
+func (c *BlockCache) remove(e *list.Element) {
+	item := c.ll.Remove(e).(*blockCacheItem)
+	delete(c.items, item.key)
+	c.size -= len(item.values) * blockCacheValueSize
+}
+
+func (c *BlockCache) updateSize() {
+	atomic.StoreInt64(&c.stats.Size, int64(c.size))
+	atomic.StoreInt64(&c.stats.Blocks, int64(len(c.items)))
+}
@@ -0,0 +1,39 @@
+package lsm
+
+import (
+	"testing"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 超过大小时淘汰最久未使用的block
+func TestBlockCache_Evict(t *testing.T) {
+	values := make([]coder.Value, 10)
+	c := NewBlockCache(2 * len(values) * blockCacheValueSize)
+
+	c.Put("a", 5, 100, values)
+	c.Put("a", 105, 100, values)
+	if _, ok := c.Get("a", 5, 100); !ok {
+		t.Fatalf("expected block cached")
+	}
+	// 第二个block最久未使用，被淘汰
+	c.Put("b", 5, 100, values)
+	if _, ok := c.Get("a", 105, 100); ok {
+		t.Fatalf("expected block evicted")
+	}
+	if _, ok := c.Get("a", 5, 100); !ok {
+		t.Fatalf("expected block cached")
+	}
+
+	c.RemoveFile("a")
+	if _, ok := c.Get("a", 5, 100); ok {
+		t.Fatalf("expected file blocks removed")
+	}
+	stats := c.Statistics()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Evictions != 1 || stats.Blocks != 1 {
+		t.Fatalf("statistics error: %+v", stats)
+	}
+	if c.Size() != len(values)*blockCacheValueSize {
+		t.Fatalf("size error: %d", c.Size())
+	}
+}
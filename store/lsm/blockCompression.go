@@ -0,0 +1,90 @@
+package lsm
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+)
+
+// 数据块在packBlock之后的通用压缩方式，记录在v5文件头中
+type BlockCompression byte
+
+const (
+	// 不压缩
+	CompressionNone BlockCompression = 0
+	// snappy压缩，速度快
+	CompressionSnappy BlockCompression = 1
+	// DEFLATE压缩，压缩率高
+	CompressionDeflate BlockCompression = 2
+)
+
+func (c BlockCompression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionDeflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(c))
+}
+
+// 按名称获得压缩方式
+func ParseBlockCompression(s string) (BlockCompression, error) {
+	switch s {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "deflate":
+		return CompressionDeflate, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown block compression: %s", s)
+}
+
+func (c BlockCompression) valid() bool {
+	return c <= CompressionDeflate
+}
+
+// 压缩一个block的数据
+func compressBlock(c BlockCompression, block []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return block, nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, block), nil
+	case CompressionDeflate:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(block); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown block compression: %d", byte(c))
+}
+
+// 解压一个block的数据
+func decompressBlock(c BlockCompression, b []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return b, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, b)
+	case CompressionDeflate:
+		r := flate.NewReader(bytes.NewReader(b))
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return nil, fmt.Errorf("unknown block compression: %d", byte(c))
+}
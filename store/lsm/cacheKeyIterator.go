@@ -88,7 +88,7 @@ func (c *cacheKeyIterator) Read() (uint32, int64, int64, []byte, error) {
 	// 状态检查，优雅退出
 	select {
 	case <-c.interrupt:
-		return 0, 0, 0, nil, c.err
+		return 0, 0, 0, nil, errCompactionAborted{}
 	default:
 	}
 
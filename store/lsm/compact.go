@@ -6,19 +6,33 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/hooone/datacc/common/limiter"
 	"github.com/hooone/datacc/store/cache"
 )
 
 const DefaultMaxPointsPerBlock = 240 * 8
-const maxTSMFileSize = uint32(2048 * 1024 * 1024) // 2GB
+
+// 单个TSM文件的最大大小，超过后切换到下一个文件
+var maxTSMFileSize = uint64(2048 * 1024 * 1024) // 2GB
 
 const (
 	CompactionTempExtension = "tmp"
 	TSMFileExtension        = "tsm"
 )
 
+const (
+	// 默认的写入限流速度，每秒字节数
+	DefaultCompactThroughput = 48 * 1024 * 1024
+	// 默认的限流桶容量
+	DefaultCompactThroughputBurst = 48 * 1024 * 1024
+	// WAL平均刷盘延时超过该值时降低写入速度
+	DefaultCompactSyncLatencyThreshold = 10 * time.Millisecond
+	// 降速后的最低写入速度，每秒字节数
+	DefaultCompactMinThroughput = 4 * 1024 * 1024
+)
+
 type Compactor struct {
 	// 目标文件目录
 	Dir string
@@ -26,21 +40,40 @@ type Compactor struct {
 	mu sync.RWMutex
 	// 写入限流器
 	RateLimit limiter.Rate
+	// 新文件中数据块的压缩方式
+	Compression BlockCompression
 
 	// 获得文件版本号，用于生成文件名
 	FileStore interface {
 		NextGeneration() int
 	}
 
-	// 文件层级压缩状态控制
-	compactionsEnabled bool
+	// 文件层级压缩状态控制。关闭时写入中的文件在下一个block前退出
+	compactionsEnabled   bool
+	compactionsInterrupt chan struct{}
 	// 快照状态控制
 	snapshotsEnabled   bool
 	snapshotsInterrupt chan struct{}
+
+	// 进行中的快照和压缩写入，用于关闭时等待其退出
+	snapshotsWG   sync.WaitGroup
+	compactionsWG sync.WaitGroup
 }
 
+// NewCompactor 默认使用自适应限流，WAL刷盘延时升高时降低写入速度
 func NewCompactor() *Compactor {
-	return &Compactor{}
+	return &Compactor{
+		RateLimit: limiter.NewAdaptiveRate(DefaultCompactThroughput, DefaultCompactThroughputBurst,
+			DefaultCompactMinThroughput, DefaultCompactSyncLatencyThreshold),
+	}
+}
+
+// ObserveWALSync 记录一次WAL刷盘耗时，RateLimit为自适应限流器时据此调整写入速度。
+// 用于WAL.SetSyncObserver
+func (c *Compactor) ObserveWALSync(d time.Duration) {
+	if r, ok := c.RateLimit.(interface{ Observe(time.Duration) }); ok {
+		r.Observe(d)
+	}
 }
 func (c *Compactor) Open() {
 	c.mu.Lock()
@@ -52,6 +85,112 @@ func (c *Compactor) Open() {
 	c.snapshotsEnabled = true
 	c.compactionsEnabled = true
 	c.snapshotsInterrupt = make(chan struct{})
+	c.compactionsInterrupt = make(chan struct{})
+}
+
+// 关闭快照和文件层级压缩，等待进行中的写入退出
+func (c *Compactor) Close() {
+	c.mu.Lock()
+	if !(c.snapshotsEnabled || c.compactionsEnabled) {
+		c.mu.Unlock()
+		return
+	}
+	c.disableSnapshots()
+	c.disableCompactions()
+	c.mu.Unlock()
+
+	c.snapshotsWG.Wait()
+	c.compactionsWG.Wait()
+}
+
+// DisableSnapshots 关闭快照，中断进行中的快照写入并等待其清理临时文件后返回
+func (c *Compactor) DisableSnapshots() {
+	c.mu.Lock()
+	c.disableSnapshots()
+	c.mu.Unlock()
+
+	c.snapshotsWG.Wait()
+}
+
+func (c *Compactor) disableSnapshots() {
+	c.snapshotsEnabled = false
+	if c.snapshotsInterrupt != nil {
+		close(c.snapshotsInterrupt)
+		c.snapshotsInterrupt = nil
+	}
+}
+
+// EnableSnapshots 重新开启快照
+func (c *Compactor) EnableSnapshots() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.snapshotsEnabled {
+		return
+	}
+	c.snapshotsEnabled = true
+	c.snapshotsInterrupt = make(chan struct{})
+}
+
+// DisableCompactions 关闭文件层级压缩，中断进行中的压缩并等待其清理临时文件后返回
+func (c *Compactor) DisableCompactions() {
+	c.mu.Lock()
+	c.disableCompactions()
+	c.mu.Unlock()
+
+	c.compactionsWG.Wait()
+}
+
+func (c *Compactor) disableCompactions() {
+	c.compactionsEnabled = false
+	if c.compactionsInterrupt != nil {
+		close(c.compactionsInterrupt)
+		c.compactionsInterrupt = nil
+	}
+}
+
+// EnableCompactions 重新开启文件层级压缩
+func (c *Compactor) EnableCompactions() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.compactionsEnabled {
+		return
+	}
+	c.compactionsEnabled = true
+	c.compactionsInterrupt = make(chan struct{})
+}
+
+// WriteCompaction 文件层级压缩，把iter中合并后的src文件数据写入新文件
+func (c *Compactor) WriteCompaction(src []string, iter KeyIterator) ([]string, error) {
+	c.mu.RLock()
+	enabled := c.compactionsEnabled
+	intC := c.compactionsInterrupt
+	if enabled {
+		c.compactionsWG.Add(1)
+	}
+	c.mu.RUnlock()
+	if !enabled {
+		return nil, errCompactionsDisabled
+	}
+	defer c.compactionsWG.Done()
+
+	files, err := c.writeNewFiles(c.FileStore.NextGeneration(), 0, src, iter, true, intC)
+	if err != nil {
+		return nil, err
+	}
+
+	// 写入完成后压缩被关闭时移除已写入的文件
+	c.mu.RLock()
+	enabled = c.compactionsEnabled
+	c.mu.RUnlock()
+	if !enabled {
+		for _, f := range files {
+			if err := os.RemoveAll(f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, errCompactionsDisabled
+	}
+	return files, nil
 }
 
 // 将Cache快照写入TSM文件.
@@ -60,10 +199,14 @@ func (c *Compactor) WriteSnapshot(che *cache.Cache) ([]string, error) {
 	c.mu.RLock()
 	enabled := c.snapshotsEnabled
 	intC := c.snapshotsInterrupt
+	if enabled {
+		c.snapshotsWG.Add(1)
+	}
 	c.mu.RUnlock()
 	if !enabled {
 		return nil, errSnapshotsDisabled
 	}
+	defer c.snapshotsWG.Done()
 
 	// 并发方式参数预留
 	concurrency := 1
@@ -81,7 +224,7 @@ func (c *Compactor) WriteSnapshot(che *cache.Cache) ([]string, error) {
 	for i := 0; i < concurrency; i++ {
 		go func(sp *cache.Cache) {
 			iter := NewCacheKeyIterator(sp, DefaultMaxPointsPerBlock, intC)
-			files, err := c.writeNewFiles(c.FileStore.NextGeneration(), 0, nil, iter, throttle)
+			files, err := c.writeNewFiles(c.FileStore.NextGeneration(), 0, nil, iter, throttle, intC)
 			resC <- res{files: files, err: err}
 		}(splits[i])
 	}
@@ -97,19 +240,24 @@ func (c *Compactor) WriteSnapshot(che *cache.Cache) ([]string, error) {
 		files = append(files, result.files...)
 	}
 
-	// 再次检查快照功能是否被关闭
-	c.mu.Lock()
+	// 再次检查快照功能是否被关闭，关闭时移除已写入的文件
+	c.mu.RLock()
 	enabled = c.snapshotsEnabled
-	c.mu.Unlock()
+	c.mu.RUnlock()
 	if !enabled {
+		for _, f := range files {
+			if err := os.RemoveAll(f); err != nil {
+				return nil, err
+			}
+		}
 		return nil, errSnapshotsDisabled
 	}
 
 	return files, err
 }
 
-// 把KeyIterator中的所有数据写入文件
-func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter KeyIterator, throttle bool) ([]string, error) {
+// 把KeyIterator中的所有数据写入文件，intC关闭时中断写入
+func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter KeyIterator, throttle bool, intC chan struct{}) ([]string, error) {
 	var files []string
 	for {
 		sequence++
@@ -118,7 +266,7 @@ func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter K
 		fileName := filepath.Join(c.Dir, formatFileName(generation, sequence))
 
 		// 尽可能多的写入
-		err := c.write(fileName, iter, throttle)
+		err := c.write(fileName, iter, throttle, intC)
 
 		// 当把文件写满时，切换到下一个文件，sequence++
 		if err == errMaxFileExceeded || err == ErrMaxBlocksExceeded {
@@ -153,7 +301,7 @@ func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter K
 }
 
 // 把数据写入文件，最多写满一个文件后返回
-func (c *Compactor) write(path string, iter KeyIterator, throttle bool) (err error) {
+func (c *Compactor) write(path string, iter KeyIterator, throttle bool, intC chan struct{}) (err error) {
 	// 创建文件
 	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
 	if err != nil {
@@ -174,7 +322,7 @@ func (c *Compactor) write(path string, iter KeyIterator, throttle bool) (err err
 	if c.RateLimit != nil && throttle {
 		limitWriter = limiter.NewWriterWithRate(fd, c.RateLimit)
 	}
-	w, err = NewTSMWriter(limitWriter)
+	w, err = NewTSMWriterWithCompression(limitWriter, c.Compression)
 	if err != nil {
 		return err
 	}
@@ -197,12 +345,11 @@ func (c *Compactor) write(path string, iter KeyIterator, throttle bool) (err err
 	}()
 
 	for iter.Next() {
-		// 判断当前工作状态
-		c.mu.RLock()
-		enabled := c.snapshotsEnabled || c.compactionsEnabled
-		c.mu.RUnlock()
-		if !enabled {
+		// 快照或压缩被关闭时中断
+		select {
+		case <-intC:
 			return errCompactionAborted{}
+		default:
 		}
 
 		// 读取一个完整block的数据，或读取一个key的所有数据
@@ -216,7 +363,7 @@ func (c *Compactor) write(path string, iter KeyIterator, throttle bool) (err err
 
 		// 把一个block的数据写入TSM文件
 		if err := w.WriteBlock(key, minTime, maxTime, block); err == ErrMaxBlocksExceeded {
-			// 数据写满后返回ErrMaxBlocksExceeded，此时补入Index区
+			// 当前key的block数量写满后返回ErrMaxBlocksExceeded，此时block已写入，补入Index区结束文件
 			if err := w.WriteIndex(); err != nil {
 				return err
 			}
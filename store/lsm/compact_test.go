@@ -3,9 +3,12 @@ package lsm
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"testing"
 
+	"github.com/hooone/datacc/common/limiter"
 	"github.com/hooone/datacc/store/cache"
+	"github.com/hooone/datacc/store/coder"
 )
 
 func TestCompact_WriteSnapshot(t *testing.T) {
@@ -43,6 +46,250 @@ func TestCompact_WriteSnapshot(t *testing.T) {
 	fmt.Println(files)
 }
 
+// 关闭快照测试
+func TestCompact_DisableSnapshots(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	c := cache.NewCache(0)
+	if err := c.Write(1, []int64{1, 2, 3}, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+
+	compactor := NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = &fakeFileStore{}
+	compactor.Open()
+
+	// 关闭后拒绝快照
+	compactor.DisableSnapshots()
+	if _, err := compactor.WriteSnapshot(c); err != errSnapshotsDisabled {
+		t.Fatalf("expected snapshots disabled, got %v", err)
+	}
+
+	// 重新开启后可以快照
+	compactor.EnableSnapshots()
+	files, err := compactor.WriteSnapshot(c)
+	if err != nil {
+		t.Fatalf("unexpected error writing snapshot: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("files count error: %d", len(files))
+	}
+	compactor.Close()
+}
+
+// 中断写入测试
+func TestCompact_Interrupt(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	compactor := NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = &fakeFileStore{}
+	compactor.Open()
+
+	// 写入第一个block后关闭快照
+	intC := compactor.snapshotsInterrupt
+	iter := &fakeKeyIterator{keys: []uint32{1, 2, 3}, onNext: func(i int) {
+		if i == 1 {
+			compactor.DisableSnapshots()
+		}
+	}}
+	_, err := compactor.writeNewFiles(1, 0, nil, iter, false, intC)
+	if _, ok := err.(errCompactionAborted); !ok {
+		t.Fatalf("expected compaction aborted, got %v", err)
+	}
+	checkEmptyDir(t, dir)
+}
+
+// 只关闭文件层级压缩时中断进行中的压缩，快照不受影响
+func TestCompact_DisableCompactions(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	compactor := NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = &fakeFileStore{}
+	compactor.Open()
+	defer compactor.Close()
+
+	// 写入第一个block后关闭压缩，DisableCompactions等待压缩退出
+	intC, done := compactor.compactionsInterrupt, make(chan struct{})
+	iter := &fakeKeyIterator{keys: []uint32{1, 2, 3}, onNext: func(i int) {
+		if i == 1 {
+			go func() {
+				compactor.DisableCompactions()
+				close(done)
+			}()
+			<-intC
+		}
+	}}
+	if _, err := compactor.WriteCompaction(nil, iter); err != (errCompactionAborted{}) {
+		t.Fatalf("expected compaction aborted, got %v", err)
+	}
+	<-done
+	checkEmptyDir(t, dir)
+
+	// 关闭后拒绝压缩
+	if _, err := compactor.WriteCompaction(nil, &fakeKeyIterator{keys: []uint32{1}}); err != errCompactionsDisabled {
+		t.Fatalf("expected compactions disabled, got %v", err)
+	}
+
+	c := cache.NewCache(0)
+	if err := c.Write(1, []int64{1, 2, 3}, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write cache fail: %v", err)
+	}
+	files, err := compactor.WriteSnapshot(c)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("snapshot error: %v %v", files, err)
+	}
+	for _, f := range files {
+		os.RemoveAll(f)
+	}
+
+	// 重新开启后可以压缩
+	compactor.EnableCompactions()
+	files, err = compactor.WriteCompaction(nil, &fakeKeyIterator{keys: []uint32{1, 2}})
+	if err != nil || len(files) != 1 {
+		t.Fatalf("compaction error: %v %v", files, err)
+	}
+}
+
+// 目录中没有残留的临时文件
+func checkEmptyDir(t *testing.T, dir string) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir fail: %v", err)
+	}
+	if len(fis) != 0 {
+		t.Fatalf("expected tmp files removed, got %d", len(fis))
+	}
+}
+
+// 单个key的block数量超过Index区上限时切换到下一个文件
+func TestCompact_MaxBlocksRollover(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	compactor := NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = &fakeFileStore{}
+	compactor.Open()
+
+	keys := make([]uint32, maxIndexEntries+10)
+	for i := range keys {
+		keys[i] = 1
+	}
+	keys = append(keys, 2)
+	files, err := compactor.writeNewFiles(1, 0, nil, &fakeKeyIterator{keys: keys}, false, nil)
+	if err != nil {
+		t.Fatalf("write files fail: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("files count error: except 2, actual %d", len(files))
+	}
+
+	// 两个文件的序列号连续，第一个文件写满block上限，剩余block全部写入第二个文件
+	except := []struct {
+		sequence int
+		blocks   map[uint32]int
+	}{
+		{1, map[uint32]int{1: maxIndexEntries}},
+		{2, map[uint32]int{1: 10, 2: 1}},
+	}
+	for i, f := range files {
+		_, sequence, err := parseFileName(f)
+		if err != nil {
+			t.Fatalf("parse file name fail: %v", err)
+		}
+		if sequence != except[i].sequence {
+			t.Fatalf("sequence error: except %d, actual %d", except[i].sequence, sequence)
+		}
+		r, err := OpenTSMReader(f)
+		if err != nil {
+			t.Fatalf("open reader fail: %v", err)
+		}
+		for key, n := range except[i].blocks {
+			if entries := r.Entries(key); len(entries) != n {
+				t.Fatalf("blocks count error. file %d, key %d: except %d, actual %d", i, key, n, len(entries))
+			}
+		}
+		r.Close()
+	}
+}
+
+// 文件大小超过上限时切换到下一个文件
+func TestCompact_MaxFileSizeRollover(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	defer func(n uint64) { maxTSMFileSize = n }(maxTSMFileSize)
+	maxTSMFileSize = 512
+
+	compactor := NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = &fakeFileStore{}
+	compactor.Open()
+
+	keys := make([]uint32, 40)
+	for i := range keys {
+		keys[i] = uint32(i + 1)
+	}
+	files, err := compactor.writeNewFiles(1, 0, nil, &fakeKeyIterator{keys: keys}, false, nil)
+	if err != nil {
+		t.Fatalf("write files fail: %v", err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("files count error: except more than 1, actual %d", len(files))
+	}
+
+	total := 0
+	for i, f := range files {
+		_, sequence, err := parseFileName(f)
+		if err != nil {
+			t.Fatalf("parse file name fail: %v", err)
+		}
+		if sequence != i+1 {
+			t.Fatalf("sequence error: except %d, actual %d", i+1, sequence)
+		}
+		r, err := OpenTSMReader(f)
+		if err != nil {
+			t.Fatalf("open reader fail: %v", err)
+		}
+		total += len(r.Keys())
+		r.Close()
+	}
+	if total != len(keys) {
+		t.Fatalf("keys count error: except %d, actual %d", len(keys), total)
+	}
+}
+
+// 按key逐个返回一个block的迭代器
+type fakeKeyIterator struct {
+	keys   []uint32
+	i      int
+	onNext func(i int)
+}
+
+func (f *fakeKeyIterator) Next() bool {
+	if f.onNext != nil {
+		f.onNext(f.i)
+	}
+	f.i++
+	return f.i <= len(f.keys)
+}
+
+func (f *fakeKeyIterator) Read() (uint32, int64, int64, []byte, error) {
+	values := []coder.Value{coder.NewValue(1, 1), coder.NewValue(2, 2)}
+	b, err := encodeByteBlockUsing(nil, values, coder.NewTimeEncoder(2), coder.NewByteEncoder(2))
+	return f.keys[f.i-1], 1, 2, b, err
+}
+
+func (f *fakeKeyIterator) Close() error { return nil }
+
+func (f *fakeKeyIterator) Err() error { return nil }
+
 type fakeFileStore struct {
 }
 
@@ -57,3 +304,22 @@ func MustTempDir() string {
 	}
 	return dir
 }
+
+// WAL刷盘延时升高时降低写入速度
+func TestCompactor_ObserveWALSync(t *testing.T) {
+	compactor := NewCompactor()
+	rate, ok := compactor.RateLimit.(*limiter.AdaptiveRate)
+	if !ok {
+		t.Fatalf("default rate limit is not adaptive: %T", compactor.RateLimit)
+	}
+	compactor.ObserveWALSync(DefaultCompactSyncLatencyThreshold / 2)
+	if rate.Limit() != DefaultCompactThroughput {
+		t.Fatalf("rate error under threshold: %d", rate.Limit())
+	}
+	for i := 0; i < 20; i++ {
+		compactor.ObserveWALSync(DefaultCompactSyncLatencyThreshold * 4)
+	}
+	if l := rate.Limit(); l >= DefaultCompactThroughput || l < DefaultCompactMinThroughput {
+		t.Fatalf("rate error over threshold: %d", l)
+	}
+}
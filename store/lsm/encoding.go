@@ -2,6 +2,7 @@ package lsm
 
 import (
 	"encoding/binary"
+	"fmt"
 	"runtime"
 
 	"github.com/hooone/datacc/common/pool"
@@ -101,3 +102,63 @@ func packBlock(buf []byte, ts []byte, values []byte) []byte {
 	copy(b[i+len(ts):], values)
 	return b[:i+len(ts)+len(values)]
 }
+
+// 拆分数据块，得到时间戳切片和内容数据切片
+func UnpackBlock(buf []byte) (ts, values []byte, err error) {
+	// 数据块头部是时间戳的长度
+	tsLen, i := binary.Uvarint(buf)
+	if i <= 0 {
+		return nil, nil, fmt.Errorf("UnpackBlock: unable to read timestamp block length")
+	}
+
+	// 时间戳数据
+	tsIdx := i + int(tsLen)
+	if tsIdx > len(buf) {
+		return nil, nil, fmt.Errorf("UnpackBlock: not enough data for timestamp")
+	}
+	ts = buf[i:tsIdx]
+
+	// 内容数据
+	values = buf[tsIdx:]
+	return
+}
+
+// 获得数据块的编码方式和数据点数量
+func blockInfo(block []byte) (timeEnc, valueEnc byte, count int, err error) {
+	tb, vb, err := UnpackBlock(block)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	count, err = coder.CountTimestamps(tb)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return coder.TimeEncoding(tb), coder.ByteEncoding(vb), count, nil
+}
+
+// DecodeByteBlock 把数据块解码为明码数据，结果追加到dst
+func DecodeByteBlock(block []byte, dst []coder.Value) ([]coder.Value, error) {
+	tb, vb, err := UnpackBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// 分别解码时间戳和内容
+	ts, err := coder.DecodeTimestamps(make([]int64, 0, DefaultMaxPointsPerBlock), tb)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := coder.DecodeBytes(make([]byte, 0, len(ts)), vb)
+	if err != nil {
+		return nil, err
+	}
+	if len(ts) != len(vs) {
+		return nil, fmt.Errorf("decode block: timestamp count %d not equal value count %d", len(ts), len(vs))
+	}
+
+	// 组合成明码数据
+	for i := range ts {
+		dst = append(dst, coder.NewValue(ts[i], vs[i]))
+	}
+	return dst, nil
+}
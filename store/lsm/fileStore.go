@@ -1,11 +1,130 @@
 package lsm
 
-import "sync"
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 管理目录下的所有TSM文件
 type FileStore struct {
 	mu sync.RWMutex
 
+	// 文件目录
+	dir string
+
 	currentGeneration int
+
+	// 按文件名排序的TSM文件，越靠后的文件数据越新
+	files []*TSMReader
+
+	// 解码后的block缓存
+	blockCache *BlockCache
+
+	// 状态统计
+	stats *FileStoreStatistics
+}
+
+// FileStoreStatistics 工作状态统计
+type FileStoreStatistics struct {
+	// 打开的文件数量
+	Files int64
+	// 查找key时，因key范围不符跳过的文件数
+	KeyRangeSkips int64
+	// 查找key时，因布隆过滤器跳过的文件数
+	BloomSkips int64
+	// 布隆过滤器误判的次数
+	BloomFalsePositives int64
+	// block缓存的命中和未命中次数
+	BlockCacheHits   int64
+	BlockCacheMisses int64
+	// block缓存的大小
+	BlockCacheSize int64
+}
+
+// 一个block在文件中的位置
+type BlockLocation struct {
+	Reader *TSMReader
+	Entry  IndexEntry
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{
+		dir:        dir,
+		blockCache: NewBlockCache(DefaultBlockCacheSize),
+		stats:      &FileStoreStatistics{},
+	}
+}
+
+// Open 打开目录下的所有TSM文件，并从最大的文件版本号之后继续生成版本号
+func (f *FileStore) Open() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(f.dir, 0777); err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range fis {
+		name := fi.Name()
+		// 移除未完成的临时文件
+		if strings.HasSuffix(name, "."+CompactionTempExtension) {
+			if err := os.RemoveAll(filepath.Join(f.dir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if fi.IsDir() || !strings.HasSuffix(name, "."+TSMFileExtension) {
+			continue
+		}
+
+		generation, _, err := parseFileName(name)
+		if err != nil {
+			return err
+		}
+		if generation > f.currentGeneration {
+			f.currentGeneration = generation
+		}
+
+		r, err := OpenTSMReader(filepath.Join(f.dir, name))
+		if err != nil {
+			return fmt.Errorf("open tsm file %s: %v", name, err)
+		}
+		r.SetBlockCache(f.blockCache)
+		f.files = append(f.files, r)
+	}
+	f.sortFiles()
+	atomic.StoreInt64(&f.statistics().Files, int64(len(f.files)))
+	return nil
+}
+
+// 关闭所有文件
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.files {
+		if f.blockCache != nil {
+			f.blockCache.RemoveFile(r.Path())
+		}
+		if err := r.Close(); err != nil {
+			return err
+		}
+	}
+	f.files = nil
+	atomic.StoreInt64(&f.statistics().Files, 0)
+	return nil
 }
 
 func (f *FileStore) NextGeneration() int {
@@ -14,3 +133,175 @@ func (f *FileStore) NextGeneration() int {
 	f.currentGeneration++
 	return f.currentGeneration
 }
+
+// 所有打开的文件
+func (f *FileStore) Files() []*TSMReader {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]*TSMReader(nil), f.files...)
+}
+
+// Acquire 返回所有打开的文件并增加引用，使用完后对每个文件调用Unref。
+// 引用期间文件被Replace替换后延迟删除
+func (f *FileStore) Acquire() []*TSMReader {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, r := range f.files {
+		r.Ref()
+	}
+	return append([]*TSMReader(nil), f.files...)
+}
+
+// 解码后的block缓存
+func (f *FileStore) BlockCache() *BlockCache {
+	return f.blockCache
+}
+
+// 状态统计
+func (f *FileStore) Statistics() *FileStoreStatistics {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := f.statistics()
+	if f.blockCache != nil {
+		bs := f.blockCache.Statistics()
+		atomic.StoreInt64(&stats.BlockCacheHits, bs.Hits)
+		atomic.StoreInt64(&stats.BlockCacheMisses, bs.Misses)
+		atomic.StoreInt64(&stats.BlockCacheSize, bs.Size)
+	}
+	return stats
+}
+
+func (f *FileStore) statistics() *FileStoreStatistics {
+	if f.stats == nil {
+		f.stats = &FileStoreStatistics{}
+	}
+	return f.stats
+}
+
+// Replace 把压缩生成的.tmp文件改名后加入，并移除被替换的旧文件。
+// 仍被查询引用的旧文件在引用全部释放后删除
+func (f *FileStore) Replace(oldFiles, newFiles []string) error {
+	// 先打开新文件
+	var readers []*TSMReader
+	for _, fn := range newFiles {
+		path := fn
+		if strings.HasSuffix(fn, "."+CompactionTempExtension) {
+			path = strings.TrimSuffix(fn, "."+CompactionTempExtension)
+			if err := os.Rename(fn, path); err != nil {
+				return err
+			}
+		}
+		r, err := OpenTSMReader(path)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return err
+		}
+		r.SetBlockCache(f.blockCache)
+		readers = append(readers, r)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// 移除旧文件
+	remove := make(map[string]bool, len(oldFiles))
+	for _, fn := range oldFiles {
+		remove[fn] = true
+	}
+	files := make([]*TSMReader, 0, len(f.files)+len(readers))
+	for _, r := range f.files {
+		if remove[r.Path()] {
+			if f.blockCache != nil {
+				f.blockCache.RemoveFile(r.Path())
+			}
+			if err := r.Remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		files = append(files, r)
+	}
+	f.files = append(files, readers...)
+	f.sortFiles()
+	atomic.StoreInt64(&f.statistics().Files, int64(len(f.files)))
+	return nil
+}
+
+// Locations 找出key在时间范围[min, max]内的所有block，按文件从旧到新排列。
+// 先用key范围和布隆过滤器跳过不包含key的文件，不读取其Index区。
+// 返回的block所在的文件都已增加引用，使用完后调用ReleaseLocations
+func (f *FileStore) Locations(key uint32, min, max int64) []BlockLocation {
+	files := f.Acquire()
+	f.mu.RLock()
+	stats := f.stats
+	f.mu.RUnlock()
+
+	var locs []BlockLocation
+	for _, r := range files {
+		n := len(locs)
+		if minKey, maxKey := r.KeyRange(); key < minKey || key > maxKey {
+			if stats != nil {
+				atomic.AddInt64(&stats.KeyRangeSkips, 1)
+			}
+		} else if !r.MayContain(key) {
+			if stats != nil {
+				atomic.AddInt64(&stats.BloomSkips, 1)
+			}
+		} else {
+			entries := r.Entries(key)
+			if len(entries) == 0 && stats != nil {
+				atomic.AddInt64(&stats.BloomFalsePositives, 1)
+			}
+			for _, e := range entries {
+				if e.OverlapsTimeRange(min, max) {
+					locs = append(locs, BlockLocation{Reader: r, Entry: e})
+				}
+			}
+		}
+		// 只保留有block的文件的引用
+		if len(locs) == n {
+			r.Unref()
+		}
+	}
+	return locs
+}
+
+// LastValue 返回key在所有文件中时间最新的值，同一时间以较新的文件为准
+func (f *FileStore) LastValue(key uint32) (coder.Value, bool, error) {
+	locs := f.Locations(key, math.MinInt64, math.MaxInt64)
+	defer ReleaseLocations(locs)
+	last := -1
+	for i, loc := range locs {
+		if last < 0 || loc.Entry.MaxTime >= locs[last].Entry.MaxTime {
+			last = i
+		}
+	}
+	if last < 0 {
+		return coder.Value{}, false, nil
+	}
+	values, err := locs[last].Reader.ReadValues(key, &locs[last].Entry, nil)
+	if err != nil || len(values) == 0 {
+		return coder.Value{}, false, err
+	}
+	return values[len(values)-1], true, nil
+}
+
+// ReleaseLocations 释放Locations增加的文件引用
+func ReleaseLocations(locs []BlockLocation) {
+	var last *TSMReader
+	for _, loc := range locs {
+		if loc.Reader != last {
+			loc.Reader.Unref()
+			last = loc.Reader
+		}
+	}
+}
+
+// 按文件名排序，即按版本号和序列号排序
+func (f *FileStore) sortFiles() {
+	sort.Slice(f.files, func(i, j int) bool {
+		return filepath.Base(f.files[i].Path()) < filepath.Base(f.files[j].Path())
+	})
+}
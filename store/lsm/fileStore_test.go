@@ -0,0 +1,164 @@
+package lsm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 通过key范围和布隆过滤器跳过不包含key的文件
+func TestFileStore_Locations(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	mustWriteTSM(t, filepath.Join(dir, "000000001-000000001.tsm"), Version4, []uint32{1, 3, 5})
+	mustWriteTSM(t, filepath.Join(dir, "000000002-000000001.tsm"), Version4, []uint32{2, 4, 6})
+	mustWriteTSM(t, filepath.Join(dir, "000000003-000000001.tsm"), Version4, []uint32{100, 200})
+
+	fs := NewFileStore(dir)
+	if err := fs.Open(); err != nil {
+		t.Fatalf("open file store fail: %v", err)
+	}
+	defer fs.Close()
+
+	if n := fs.NextGeneration(); n != 4 {
+		t.Fatalf("next generation error: except 4, actual %d", n)
+	}
+
+	locs := fs.Locations(3, 0, 2000)
+	if len(locs) != 2 {
+		t.Fatalf("locations count error: except 2, actual %d", len(locs))
+	}
+	if filepath.Base(locs[0].Reader.Path()) != "000000001-000000001.tsm" {
+		t.Fatalf("location file error: %s", locs[0].Reader.Path())
+	}
+	locs = fs.Locations(3, 1500, 2000)
+	if len(locs) != 1 {
+		t.Fatalf("locations count error: except 1, actual %d", len(locs))
+	}
+
+	files := fs.Files()
+	// 第三个文件的key范围不包含3，不需要加载Index区
+	if files[2].indexLoaded() {
+		t.Fatalf("index of %s should not be loaded", files[2].Path())
+	}
+	stats := fs.Statistics()
+	if stats.KeyRangeSkips != 2 {
+		t.Fatalf("key range skips error: except 2, actual %d", stats.KeyRangeSkips)
+	}
+	if stats.BloomSkips+stats.BloomFalsePositives != 2 {
+		t.Fatalf("bloom skips error: %d skips, %d false positives", stats.BloomSkips, stats.BloomFalsePositives)
+	}
+	if stats.BloomSkips > 0 && files[1].indexLoaded() {
+		t.Fatalf("index of %s should not be loaded", files[1].Path())
+	}
+}
+
+// 替换文件
+func TestFileStore_Replace(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "000000001-000000001.tsm")
+	mustWriteTSM(t, old, Version4, []uint32{1})
+	fs := NewFileStore(dir)
+	if err := fs.Open(); err != nil {
+		t.Fatalf("open file store fail: %v", err)
+	}
+	defer fs.Close()
+
+	tmp := filepath.Join(dir, "000000002-000000001.tsm.tmp")
+	data := mustWriteTSM(t, tmp, Version4, []uint32{1, 2})
+	if err := fs.Replace([]string{old}, []string{tmp}); err != nil {
+		t.Fatalf("replace fail: %v", err)
+	}
+
+	files := fs.Files()
+	if len(files) != 1 {
+		t.Fatalf("files count error: except 1, actual %d", len(files))
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("old file should be removed")
+	}
+	checkTSM(t, files[0].Path(), data).Close()
+}
+
+// 查询引用的文件被替换后仍可读取，引用释放后删除
+func TestFileStore_ReplaceInUse(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "000000001-000000001.tsm")
+	data := mustWriteTSM(t, old, Version4, []uint32{1})
+	fs := NewFileStore(dir)
+	if err := fs.Open(); err != nil {
+		t.Fatalf("open file store fail: %v", err)
+	}
+	defer fs.Close()
+
+	locs := fs.Locations(1, 0, 2000)
+	if len(locs) == 0 || !locs[0].Reader.InUse() {
+		t.Fatalf("locations should reference file: %d", len(locs))
+	}
+	tmp := filepath.Join(dir, "000000002-000000001.tsm.tmp")
+	mustWriteTSM(t, tmp, Version4, []uint32{2})
+	if err := fs.Replace([]string{old}, []string{tmp}); err != nil {
+		t.Fatalf("replace fail: %v", err)
+	}
+	if _, err := os.Stat(old); err != nil {
+		t.Fatalf("file in use should not be removed: %v", err)
+	}
+	var n int
+	for _, loc := range locs {
+		values, err := loc.Reader.ReadValues(1, &loc.Entry, nil)
+		if err != nil {
+			t.Fatalf("read replaced file fail: %v", err)
+		}
+		n += len(values)
+	}
+	if n != len(data[1]) {
+		t.Fatalf("values count error: except %d, actual %d", len(data[1]), n)
+	}
+
+	ReleaseLocations(locs)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("old file should be removed after release")
+	}
+}
+
+// 重复读取时从block缓存中获得数据，替换文件后缓存被清除
+func TestFileStore_BlockCache(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "000000001-000000001.tsm")
+	data := mustWriteTSM(t, old, Version, []uint32{1})
+	fs := NewFileStore(dir)
+	if err := fs.Open(); err != nil {
+		t.Fatalf("open file store fail: %v", err)
+	}
+	defer fs.Close()
+
+	for i := 0; i < 2; i++ {
+		values, err := fs.Files()[0].ReadAll(1)
+		if err != nil {
+			t.Fatalf("read fail: %v", err)
+		}
+		if len(values) != len(data[1]) {
+			t.Fatalf("values count error: except %d, actual %d", len(data[1]), len(values))
+		}
+	}
+	stats := fs.Statistics()
+	if stats.BlockCacheMisses != 2 || stats.BlockCacheHits != 2 || stats.BlockCacheSize == 0 {
+		t.Fatalf("block cache statistics error: %+v", stats)
+	}
+
+	tmp := filepath.Join(dir, "000000002-000000001.tsm.tmp")
+	mustWriteTSM(t, tmp, Version, []uint32{2})
+	if err := fs.Replace([]string{old}, []string{tmp}); err != nil {
+		t.Fatalf("replace fail: %v", err)
+	}
+	if n := fs.BlockCache().Size(); n != 0 {
+		t.Fatalf("expected block cache dropped, size %d", n)
+	}
+}
@@ -1,7 +1,32 @@
 package lsm
 
-import "fmt"
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
 
 func formatFileName(generation, sequence int) string {
 	return fmt.Sprintf("%09d-%09d", generation, sequence) + "." + TSMFileExtension + "." + CompactionTempExtension
 }
+
+// 从文件名中解析文件版本号和序列号
+func parseFileName(name string) (int, int, error) {
+	base := filepath.Base(name)
+	base = strings.TrimSuffix(base, "."+CompactionTempExtension)
+	base = strings.TrimSuffix(base, "."+TSMFileExtension)
+	parts := strings.Split(base, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid tsm file name: %s", name)
+	}
+	generation, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid tsm file name: %s", name)
+	}
+	sequence, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid tsm file name: %s", name)
+	}
+	return generation, sequence, nil
+}
@@ -0,0 +1,159 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// Index重建结果
+type RebuildResult struct {
+	// 重建的key和block数量
+	Keys   int
+	Blocks int
+	// 数据块区的结束位置，之后的数据被截断
+	DataSize int64
+	// 被截断的字节数
+	Truncated int64
+}
+
+// RebuildIndex 按顺序扫描v2格式TSM文件中的数据块，重建Index区和文件尾。
+// 扫描到不完整或校验失败的数据块时停止，之后的数据被截断
+func RebuildIndex(path string) (RebuildResult, error) {
+	var res RebuildResult
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return res, err
+	}
+
+	// 校验文件头
+	var hdr [headerSizeV5]byte
+	if _, err := io.ReadFull(f, hdr[:headerSize]); err != nil {
+		return res, fmt.Errorf("read tsm header: %v", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != MagicNumber {
+		return res, fmt.Errorf("not a tsm file: %s", path)
+	}
+	if hdr[4] < Version2 || hdr[4] > Version {
+		return res, fmt.Errorf("tsm version %d has no block header, can not rebuild: %s", hdr[4], path)
+	}
+	compression := CompressionNone
+	if hdr[4] >= Version5 {
+		if _, err := io.ReadFull(f, hdr[headerSize:]); err != nil {
+			return res, fmt.Errorf("read tsm header: %v", err)
+		}
+		compression = BlockCompression(hdr[headerSize])
+		if !compression.valid() {
+			return res, fmt.Errorf("unknown block compression %d: %s", hdr[headerSize], path)
+		}
+	}
+
+	// 扫描数据块
+	entries := make(map[uint32][]IndexEntry)
+	offset := int64(headerSizeOf(hdr[4]))
+	var lb [blockLengthSize]byte
+	var ts []int64
+	for {
+		if _, err := f.ReadAt(lb[:], offset); err != nil {
+			break
+		}
+		length := int64(binary.LittleEndian.Uint32(lb[:]))
+		if length < crc32.Size+blockHeaderSize || offset+blockLengthSize+length > stat.Size() {
+			break
+		}
+		b := make([]byte, length)
+		if _, err := f.ReadAt(b, offset+blockLengthSize); err != nil {
+			break
+		}
+
+		// CRC校验
+		if binary.LittleEndian.Uint32(b[:crc32.Size]) != crc32.ChecksumIEEE(b[crc32.Size:]) {
+			break
+		}
+		key, typ, _, _, _, err := decodeBlockHeader(b[crc32.Size:])
+		if err != nil || typ != BlockByte {
+			break
+		}
+
+		// 从时间戳中获得时间范围
+		data, err := decompressBlock(compression, b[crc32.Size+blockHeaderSize:])
+		if err != nil {
+			break
+		}
+		tb, _, err := UnpackBlock(data)
+		if err != nil {
+			break
+		}
+		if ts, err = coder.DecodeTimestamps(ts[:0], tb); err != nil || len(ts) == 0 {
+			break
+		}
+
+		size := blockLengthSize + length
+		entry := IndexEntry{
+			MinTime: ts[0],
+			MaxTime: ts[len(ts)-1],
+			Offset:  offset,
+			Size:    uint32(size),
+		}
+		if hdr[4] >= Version3 {
+			if err := entry.setStats(data); err != nil {
+				break
+			}
+		}
+		entries[key] = append(entries[key], entry)
+		res.Blocks++
+		offset += size
+	}
+	if res.Blocks == 0 {
+		return res, ErrNoValues
+	}
+	res.Keys = len(entries)
+	res.DataSize = offset
+	res.Truncated = stat.Size() - offset
+
+	// 截断损坏的数据，写入新的Index区
+	if err := f.Truncate(offset); err != nil {
+		return res, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return res, err
+	}
+	keys := make([]uint32, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Sort(uint32Slice(keys))
+	index := newIndexWriterVersion(hdr[4])
+	index.(*directIndex).f = f
+	for _, k := range keys {
+		for _, e := range entries[k] {
+			index.Add(k, e)
+		}
+	}
+	if _, err := index.WriteTo(f); err != nil {
+		return res, err
+	}
+	if hdr[4] >= Version4 {
+		if err := writeKeyFilter(f, keys); err != nil {
+			return res, err
+		}
+	}
+
+	// 写入文件尾
+	var buf [footerSize]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(offset))
+	if _, err := f.Write(buf[:]); err != nil {
+		return res, err
+	}
+	return res, f.Sync()
+}
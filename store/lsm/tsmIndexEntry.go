@@ -3,6 +3,8 @@ package lsm
 import (
 	"encoding/binary"
 	"io"
+
+	"github.com/hooone/datacc/store/coder"
 )
 
 // 每个block的索引信息，记录在TSM文件末尾的Index区
@@ -10,10 +12,20 @@ type IndexEntry struct {
 	MinTime, MaxTime int64
 	Offset           int64
 	Size             uint32
+
+	// v3格式起记录的block统计值，用于不解码数据块直接聚合
+	Count      uint32
+	MinValue   byte
+	MaxValue   byte
+	FirstValue byte
+	LastValue  byte
+	Sum        uint64
 }
 
 // 同一个key的多个index
 type indexEntries struct {
+	// 文件格式版本，决定每个IndexEntry的编码长度
+	version byte
 	entries []IndexEntry
 }
 
@@ -24,12 +36,16 @@ func (a *indexEntries) Less(i, j int) bool {
 }
 
 func (a *indexEntries) WriteTo(w io.Writer) (total int64, err error) {
-	var buf [indexEntrySize]byte
+	var buf [indexEntrySizeV3]byte
 	var n int
+	size := indexEntrySizeOf(a.version)
 
 	for _, entry := range a.entries {
 		entry.AppendTo(buf[:])
-		n, err = w.Write(buf[:])
+		if a.version >= Version3 {
+			entry.appendStatsTo(buf[indexEntrySize:])
+		}
+		n, err = w.Write(buf[:size])
 		total += int64(n)
 		if err != nil {
 			return total, err
@@ -38,6 +54,15 @@ func (a *indexEntries) WriteTo(w io.Writer) (total int64, err error) {
 
 	return total, nil
 }
+
+// 各版本中每个IndexEntry的编码长度
+func indexEntrySizeOf(version byte) int {
+	if version >= Version3 {
+		return indexEntrySizeV3
+	}
+	return indexEntrySize
+}
+
 func (e *IndexEntry) AppendTo(b []byte) []byte {
 	if len(b) < indexEntrySize {
 		if cap(b) < indexEntrySize {
@@ -52,3 +77,78 @@ func (e *IndexEntry) AppendTo(b []byte) []byte {
 	binary.LittleEndian.PutUint32(b[24:28], uint32(e.Size))
 	return b
 }
+
+// 编码block统计值
+func (e *IndexEntry) appendStatsTo(b []byte) {
+	binary.LittleEndian.PutUint32(b[0:4], e.Count)
+	b[4] = e.MinValue
+	b[5] = e.MaxValue
+	b[6] = e.FirstValue
+	b[7] = e.LastValue
+	binary.LittleEndian.PutUint64(b[8:16], e.Sum)
+}
+
+// 从Index区解析IndexEntry
+func (e *IndexEntry) UnmarshalBinary(b []byte) error {
+	if len(b) < indexEntrySize {
+		return io.ErrShortBuffer
+	}
+	e.MinTime = int64(binary.LittleEndian.Uint64(b[:8]))
+	e.MaxTime = int64(binary.LittleEndian.Uint64(b[8:16]))
+	e.Offset = int64(binary.LittleEndian.Uint64(b[16:24]))
+	e.Size = binary.LittleEndian.Uint32(b[24:28])
+	return nil
+}
+
+// 解析block统计值
+func (e *IndexEntry) unmarshalStats(b []byte) error {
+	if len(b) < indexEntryStatsSize {
+		return io.ErrShortBuffer
+	}
+	e.Count = binary.LittleEndian.Uint32(b[0:4])
+	e.MinValue = b[4]
+	e.MaxValue = b[5]
+	e.FirstValue = b[6]
+	e.LastValue = b[7]
+	e.Sum = binary.LittleEndian.Uint64(b[8:16])
+	return nil
+}
+
+// 由block数据计算统计值
+func (e *IndexEntry) setStats(block []byte) error {
+	_, vb, err := UnpackBlock(block)
+	if err != nil {
+		return err
+	}
+	values, err := coder.DecodeBytes(make([]byte, 0, DefaultMaxPointsPerBlock), vb)
+	if err != nil {
+		return err
+	}
+	e.Count = uint32(len(values))
+	e.Sum = 0
+	if len(values) == 0 {
+		return nil
+	}
+	e.MinValue, e.MaxValue = values[0], values[0]
+	e.FirstValue, e.LastValue = values[0], values[len(values)-1]
+	for _, v := range values {
+		if v < e.MinValue {
+			e.MinValue = v
+		}
+		if v > e.MaxValue {
+			e.MaxValue = v
+		}
+		e.Sum += uint64(v)
+	}
+	return nil
+}
+
+// 时间范围[min, max]是否完全包含该block
+func (e *IndexEntry) Within(min, max int64) bool {
+	return e.MinTime >= min && e.MaxTime <= max
+}
+
+// 时间范围[min, max]是否与该block重叠
+func (e *IndexEntry) OverlapsTimeRange(min, max int64) bool {
+	return e.MinTime <= max && e.MaxTime >= min
+}
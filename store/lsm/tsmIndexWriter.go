@@ -10,16 +10,22 @@ import (
 )
 
 const (
-	// 一个文件中每个index最多支持有65536个block，所以数量位占2个byte
+	// 一个文件中每个key的block数量占2个byte
 	indexCountSize = 2
+	// 一个文件中每个key最多的block数量，超过后由writer返回ErrMaxBlocksExceeded切换到下一个文件
+	maxIndexEntries = 1<<16 - 1
 	// 每个block会有一个IndexEntry，指示IndexEntry的大小
 	indexEntrySize = 28
+	// v3格式在IndexEntry后追加的统计值大小: Count、Min、Max、First、Last、Sum
+	indexEntryStatsSize = 4 + 1 + 1 + 1 + 1 + 8
+	// v3格式IndexEntry的大小
+	indexEntrySizeV3 = indexEntrySize + indexEntryStatsSize
 )
 
 type IndexWriter interface {
-	Add(key uint32, minTime, maxTime int64, offset int64, size uint32)
+	Add(key uint32, entry IndexEntry)
 	KeyCount() int
-	Size() uint32
+	Size() uint64
 	WriteTo(w io.Writer) (int64, error)
 	Remove() error
 	Close() error
@@ -37,38 +43,41 @@ type directIndex struct {
 	// 当前key的index数据缓存
 	indexEntries *indexEntries
 
+	// 文件格式版本
+	version byte
+
 	// 写入的key的数量
 	keyCount int
 	// 写入的key的大小
-	size uint32
+	size uint64
 }
 
 func NewIndexWriter() IndexWriter {
+	return newIndexWriterVersion(Version)
+}
+
+// 以指定的文件格式版本新建index缓存器
+func newIndexWriterVersion(version byte) IndexWriter {
 	buf := bytes.NewBuffer(make([]byte, 0, 1024*1024))
-	return &directIndex{buf: buf, w: bufio.NewWriter(buf)}
+	return &directIndex{buf: buf, w: bufio.NewWriter(buf), version: version}
 }
 
-func (d *directIndex) Add(key uint32, minTime, maxTime int64, offset int64, size uint32) {
+func (d *directIndex) Add(key uint32, entry IndexEntry) {
 	// 数据第一次写入
 	if d.key == 0 {
 		// 初始化index缓存
 		d.key = key
 		if d.indexEntries == nil {
-			d.indexEntries = &indexEntries{}
+			d.indexEntries = &indexEntries{version: d.version}
 		}
 
 		// 填入当前block的属性
-		d.indexEntries.entries = append(d.indexEntries.entries, IndexEntry{
-			MinTime: minTime,
-			MaxTime: maxTime,
-			Offset:  offset,
-			Size:    size,
-		})
+		d.indexEntries.entries = append(d.indexEntries.entries, entry)
 
 		// 数量统计
-		d.size += uint32(4)
+		d.size += uint64(keyLength)
 		d.size += indexCountSize
-		d.size += indexEntrySize
+		d.size += d.entrySize()
 		d.keyCount++
 		return
 	}
@@ -76,15 +85,10 @@ func (d *directIndex) Add(key uint32, minTime, maxTime int64, offset int64, size
 	// 相同key的第二次写入
 	if d.key == key {
 		// 填入当前block的属性
-		d.indexEntries.entries = append(d.indexEntries.entries, IndexEntry{
-			MinTime: minTime,
-			MaxTime: maxTime,
-			Offset:  offset,
-			Size:    size,
-		})
+		d.indexEntries.entries = append(d.indexEntries.entries, entry)
 
 		// 数量统计
-		d.size += indexEntrySize
+		d.size += d.entrySize()
 	} else {
 		// key切换
 		// 把当前key进行编码，并写入buffer
@@ -92,19 +96,21 @@ func (d *directIndex) Add(key uint32, minTime, maxTime int64, offset int64, size
 		d.key = key
 
 		// 填入当前block的属性
-		d.indexEntries.entries = append(d.indexEntries.entries, IndexEntry{
-			MinTime: minTime,
-			MaxTime: maxTime,
-			Offset:  offset,
-			Size:    size,
-		})
+		d.indexEntries.entries = append(d.indexEntries.entries, entry)
 
 		// 数量统计
+		d.size += uint64(keyLength)
 		d.size += indexCountSize
-		d.size += indexEntrySize
+		d.size += d.entrySize()
 		d.keyCount++
 	}
 }
+
+// 每个IndexEntry的编码长度
+func (d *directIndex) entrySize() uint64 {
+	return uint64(indexEntrySizeOf(d.version))
+}
+
 func (d *directIndex) WriteTo(w io.Writer) (int64, error) {
 	// 把当前key所对应的index缓存写入buffer
 	if _, err := d.encode(d.w); err != nil {
@@ -121,7 +127,7 @@ func (d *directIndex) WriteTo(w io.Writer) (int64, error) {
 func (d *directIndex) KeyCount() int {
 	return d.keyCount
 }
-func (d *directIndex) Size() uint32 {
+func (d *directIndex) Size() uint64 {
 	return d.size
 }
 
@@ -153,6 +159,9 @@ func (d *directIndex) encode(w io.Writer) (int64, error) {
 	N += int64(n)
 
 	// 写入block数量
+	if entries.Len() > maxIndexEntries {
+		return N, ErrMaxBlocksExceeded
+	}
 	binary.LittleEndian.PutUint16(buf[0:2], uint16(entries.Len()))
 	if n, err = w.Write(buf[0:2]); err != nil {
 		return int64(n) + N, fmt.Errorf("write: writer block type and count error: %v", err)
@@ -0,0 +1,489 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hooone/datacc/common/bloom"
+	"github.com/hooone/datacc/store/coder"
+)
+
+// TSM文件的读取，支持v1到v5格式
+type TSMReader struct {
+	mu sync.RWMutex
+
+	// 文件
+	f    *os.File
+	path string
+	size int64
+
+	// 文件格式版本
+	version byte
+	// 文件头大小，即数据块区的起始位置
+	dataOffset int64
+	// 数据块的压缩方式
+	compression BlockCompression
+	// Index区的起始位置和结束位置
+	indexOffset int64
+	indexEnd    int64
+
+	// v4格式文件尾中key的布隆过滤器和key范围
+	bloom          *bloom.Filter
+	minKey, maxKey uint32
+
+	// Index区在第一次使用时读取
+	indexOnce sync.Once
+	indexErr  error
+	loaded    uint32
+	// 排序后的key
+	keys []uint32
+	// 每个key的block索引
+	index map[uint32][]IndexEntry
+
+	// 文件中数据的时间范围
+	minTime, maxTime int64
+
+	// 解码后的block缓存，由FileStore设置
+	cache *BlockCache
+
+	// 正在使用文件的查询数量。Remove时文件仍在使用则标记removing，最后一次Unref时删除
+	refMu    sync.Mutex
+	refs     int
+	removing bool
+}
+
+// 打开TSM文件
+func OpenTSMReader(path string) (*TSMReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewTSMReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// 读取文件头和Index区
+func NewTSMReader(f *os.File) (*TSMReader, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &TSMReader{
+		f:       f,
+		path:    f.Name(),
+		size:    stat.Size(),
+		index:   make(map[uint32][]IndexEntry),
+		minTime: math.MaxInt64,
+		maxTime: math.MinInt64,
+	}
+
+	// 文件头: 识别码和版本号，v5格式还有数据块的压缩方式
+	if r.size < headerSize+footerSize {
+		return nil, fmt.Errorf("tsm file too small: %s", r.path)
+	}
+	var hdr [headerSizeV5]byte
+	if _, err := f.ReadAt(hdr[:headerSize], 0); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(hdr[0:4]) != MagicNumber {
+		return nil, fmt.Errorf("not a tsm file: %s", r.path)
+	}
+	r.version = hdr[4]
+	if r.version < Version1 || r.version > Version {
+		return nil, fmt.Errorf("unsupported tsm version %d: %s", r.version, r.path)
+	}
+	r.dataOffset = int64(headerSizeOf(r.version))
+	if r.version >= Version5 {
+		if r.size < r.dataOffset+footerSize {
+			return nil, fmt.Errorf("tsm file too small: %s", r.path)
+		}
+		if _, err := f.ReadAt(hdr[headerSize:], headerSize); err != nil {
+			return nil, err
+		}
+		r.compression = BlockCompression(hdr[headerSize])
+		if !r.compression.valid() {
+			return nil, fmt.Errorf("unknown block compression %d: %s", hdr[headerSize], r.path)
+		}
+	}
+
+	// 文件尾: Index区的位置
+	var ftr [footerSize]byte
+	if _, err := f.ReadAt(ftr[:], r.size-footerSize); err != nil {
+		return nil, err
+	}
+	r.indexOffset = int64(binary.LittleEndian.Uint64(ftr[:]))
+	r.indexEnd = r.size - footerSize
+	if r.indexOffset < r.dataOffset || r.indexOffset > r.indexEnd {
+		return nil, fmt.Errorf("invalid index offset %d: %s", r.indexOffset, r.path)
+	}
+
+	// v4格式读取布隆过滤器和key范围，Index区延迟到使用时读取
+	if r.version >= Version4 {
+		if err := r.readKeyFilter(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	// 旧版本格式直接读取Index区
+	if err := r.loadIndex(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 读取文件尾的布隆过滤器和key范围
+func (r *TSMReader) readKeyFilter() error {
+	if r.indexEnd-footerKeyRangeSize < r.indexOffset {
+		return fmt.Errorf("invalid key filter: %s", r.path)
+	}
+	var buf [footerKeyRangeSize]byte
+	if _, err := r.f.ReadAt(buf[:], r.indexEnd-footerKeyRangeSize); err != nil {
+		return err
+	}
+	r.minKey = binary.LittleEndian.Uint32(buf[0:4])
+	r.maxKey = binary.LittleEndian.Uint32(buf[4:8])
+	bloomLen := int64(binary.LittleEndian.Uint32(buf[8:12]))
+	k := uint64(buf[12])
+
+	// 布隆过滤器在key范围之前，Index区在布隆过滤器之前
+	bloomOffset := r.indexEnd - footerKeyRangeSize - bloomLen
+	if bloomLen == 0 || bloomOffset < r.indexOffset || r.minKey > r.maxKey {
+		return fmt.Errorf("invalid key filter: %s", r.path)
+	}
+	b := make([]byte, bloomLen)
+	if _, err := r.f.ReadAt(b, bloomOffset); err != nil {
+		return err
+	}
+	f, err := bloom.NewFilterBuffer(b, k)
+	if err != nil {
+		return fmt.Errorf("invalid key filter: %s: %v", r.path, err)
+	}
+	r.bloom = f
+	r.indexEnd = bloomOffset
+	return nil
+}
+
+// 读取Index区，只读取一次
+func (r *TSMReader) loadIndex() error {
+	r.indexOnce.Do(func() {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if r.f == nil {
+			r.indexErr = ErrTSMClosed
+			return
+		}
+		b := make([]byte, r.indexEnd-r.indexOffset)
+		if _, err := r.f.ReadAt(b, r.indexOffset); err != nil {
+			r.indexErr = err
+			return
+		}
+		r.indexErr = r.readIndex(b)
+		atomic.StoreUint32(&r.loaded, 1)
+	})
+	return r.indexErr
+}
+
+// Index区是否已经读取
+func (r *TSMReader) indexLoaded() bool {
+	return atomic.LoadUint32(&r.loaded) == 1
+}
+
+// 解析Index区
+func (r *TSMReader) readIndex(b []byte) error {
+	i := 0
+	for i < len(b) {
+		// key和block数量
+		if i+keyLength+indexCountSize > len(b) {
+			return fmt.Errorf("index corrupt: %s", r.path)
+		}
+		key := binary.LittleEndian.Uint32(b[i : i+keyLength])
+		i += keyLength
+		count := int(binary.LittleEndian.Uint16(b[i : i+indexCountSize]))
+		i += indexCountSize
+
+		// 每个block的信息
+		size := indexEntrySizeOf(r.version)
+		if i+count*size > len(b) {
+			return fmt.Errorf("index corrupt: %s", r.path)
+		}
+		entries := make([]IndexEntry, count)
+		for j := range entries {
+			entries[j].UnmarshalBinary(b[i : i+indexEntrySize])
+			if r.version >= Version3 {
+				entries[j].unmarshalStats(b[i+indexEntrySize : i+size])
+			}
+			i += size
+			if entries[j].MinTime < r.minTime {
+				r.minTime = entries[j].MinTime
+			}
+			if entries[j].MaxTime > r.maxTime {
+				r.maxTime = entries[j].MaxTime
+			}
+		}
+
+		if _, ok := r.index[key]; !ok {
+			r.keys = append(r.keys, key)
+		}
+		r.index[key] = append(r.index[key], entries...)
+	}
+	sort.Sort(uint32Slice(r.keys))
+	return nil
+}
+
+// 文件格式版本
+func (r *TSMReader) Version() byte {
+	return r.version
+}
+
+// 文件路径
+func (r *TSMReader) Path() string {
+	return r.path
+}
+
+// 文件大小
+func (r *TSMReader) Size() int64 {
+	return r.size
+}
+
+// 文件中数据的时间范围
+func (r *TSMReader) TimeRange() (int64, int64) {
+	if r.loadIndex() != nil {
+		return math.MaxInt64, math.MinInt64
+	}
+	return r.minTime, r.maxTime
+}
+
+// 排序后的所有key
+func (r *TSMReader) Keys() []uint32 {
+	if r.loadIndex() != nil {
+		return nil
+	}
+	return r.keys
+}
+
+// key的范围
+func (r *TSMReader) KeyRange() (uint32, uint32) {
+	if r.version >= Version4 {
+		return r.minKey, r.maxKey
+	}
+	keys := r.Keys()
+	if len(keys) == 0 {
+		return 0, 0
+	}
+	return keys[0], keys[len(keys)-1]
+}
+
+// MayContain 不读取Index区判断文件中是否可能包含key。返回false时一定不包含
+func (r *TSMReader) MayContain(key uint32) bool {
+	if r.bloom == nil {
+		return r.Contains(key)
+	}
+	if key < r.minKey || key > r.maxKey {
+		return false
+	}
+	var kb [keyLength]byte
+	binary.LittleEndian.PutUint32(kb[:], key)
+	return r.bloom.Contains(kb[:])
+}
+
+// 数据块的压缩方式
+func (r *TSMReader) Compression() BlockCompression {
+	return r.compression
+}
+
+// IndexEntry中是否带有block统计值
+func (r *TSMReader) HasStats() bool {
+	return r.version >= Version3
+}
+
+// 是否包含key
+func (r *TSMReader) Contains(key uint32) bool {
+	if r.loadIndex() != nil {
+		return false
+	}
+	_, ok := r.index[key]
+	return ok
+}
+
+// key的所有block索引，按时间排序
+func (r *TSMReader) Entries(key uint32) []IndexEntry {
+	if r.loadIndex() != nil {
+		return nil
+	}
+	return r.index[key]
+}
+
+// 读取一个block并校验CRC，返回解压后、解码前的数据
+func (r *TSMReader) ReadBlock(key uint32, e *IndexEntry) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.f == nil {
+		return nil, ErrTSMClosed
+	}
+	if e.Offset < r.dataOffset || e.Offset+int64(e.Size) > r.indexOffset {
+		return nil, fmt.Errorf("invalid block offset %d, size %d: %s", e.Offset, e.Size, r.path)
+	}
+
+	b := make([]byte, e.Size)
+	if _, err := r.f.ReadAt(b, e.Offset); err != nil {
+		return nil, err
+	}
+	return r.unpackEntry(key, b)
+}
+
+// 校验并去掉block的CRC和头部，然后解压数据
+func (r *TSMReader) unpackEntry(key uint32, b []byte) ([]byte, error) {
+	if r.version >= Version2 {
+		if len(b) < blockLengthSize+crc32.Size+blockHeaderSize {
+			return nil, fmt.Errorf("block too short: %s", r.path)
+		}
+		b = b[blockLengthSize:]
+	} else if len(b) < crc32.Size {
+		return nil, fmt.Errorf("block too short: %s", r.path)
+	}
+
+	// CRC校验
+	if binary.LittleEndian.Uint32(b[:crc32.Size]) != crc32.ChecksumIEEE(b[crc32.Size:]) {
+		return nil, fmt.Errorf("block checksum mismatch: %s", r.path)
+	}
+	b = b[crc32.Size:]
+
+	// v2格式的块头部
+	if r.version >= Version2 {
+		k, typ, _, _, _, err := decodeBlockHeader(b)
+		if err != nil {
+			return nil, err
+		}
+		if k != key || typ != BlockByte {
+			return nil, fmt.Errorf("block header mismatch: key %d, type %d: %s", k, typ, r.path)
+		}
+		b = b[blockHeaderSize:]
+	}
+	if r.compression != CompressionNone {
+		return decompressBlock(r.compression, b)
+	}
+	return b, nil
+}
+
+// 读取并解码一个block，结果追加到dst。设置了缓存时优先从缓存中读取
+func (r *TSMReader) ReadValues(key uint32, e *IndexEntry, dst []coder.Value) ([]coder.Value, error) {
+	if r.cache != nil {
+		if values, ok := r.cache.Get(r.path, e.Offset, e.Size); ok {
+			return append(dst, values...), nil
+		}
+	}
+
+	b, err := r.ReadBlock(key, e)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache == nil {
+		return DecodeByteBlock(b, dst)
+	}
+
+	values, err := DecodeByteBlock(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Put(r.path, e.Offset, e.Size, values)
+	return append(dst, values...), nil
+}
+
+// 设置解码后的block缓存
+func (r *TSMReader) SetBlockCache(c *BlockCache) {
+	r.cache = c
+}
+
+// 读取key的所有数据
+func (r *TSMReader) ReadAll(key uint32) (coder.Values, error) {
+	if err := r.loadIndex(); err != nil {
+		return nil, err
+	}
+	var values []coder.Value
+	entries := r.Entries(key)
+	for i := range entries {
+		var err error
+		if values, err = r.ReadValues(key, &entries[i], values); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// 关闭文件
+func (r *TSMReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Ref 增加引用，使用中的文件在Remove后不会被关闭
+func (r *TSMReader) Ref() {
+	r.refMu.Lock()
+	r.refs++
+	r.refMu.Unlock()
+}
+
+// Unref 释放引用。已被Remove的文件在最后一个引用释放时关闭并删除
+func (r *TSMReader) Unref() error {
+	r.refMu.Lock()
+	r.refs--
+	remove := r.refs == 0 && r.removing
+	r.refMu.Unlock()
+	if remove {
+		return r.remove()
+	}
+	return nil
+}
+
+// 是否有查询正在使用文件
+func (r *TSMReader) InUse() bool {
+	r.refMu.Lock()
+	defer r.refMu.Unlock()
+	return r.refs > 0
+}
+
+// 关闭并删除文件。文件正在使用时延迟到引用全部释放后删除
+func (r *TSMReader) Remove() error {
+	r.refMu.Lock()
+	if r.refs > 0 {
+		r.removing = true
+		r.refMu.Unlock()
+		return nil
+	}
+	r.refMu.Unlock()
+	return r.remove()
+}
+
+func (r *TSMReader) remove() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	// 使用期间可能又缓存了该文件的block
+	if r.cache != nil {
+		r.cache.RemoveFile(r.path)
+	}
+	return os.Remove(r.path)
+}
+
+type uint32Slice []uint32
+
+func (a uint32Slice) Len() int           { return len(a) }
+func (a uint32Slice) Less(i, j int) bool { return a[i] < a[j] }
+func (a uint32Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,225 @@
+package lsm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hooone/datacc/store/coder"
+)
+
+// 写入测试用的TSM文件，每个key两个block
+func mustWriteTSM(t *testing.T, path string, version byte, keys []uint32) map[uint32]coder.Values {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
+	if err != nil {
+		t.Fatalf("create file fail: %v", err)
+	}
+	w, err := newTSMWriterVersion(f, version)
+	if err != nil {
+		t.Fatalf("new writer fail: %v", err)
+	}
+	return writeTSMBlocks(t, w, keys)
+}
+
+// 把测试数据写入writer，每个key两个block
+func writeTSMBlocks(t *testing.T, w TSMWriter, keys []uint32) map[uint32]coder.Values {
+	data := make(map[uint32]coder.Values)
+	for _, k := range keys {
+		for blk := 0; blk < 2; blk++ {
+			values := make([]coder.Value, 100)
+			for i := range values {
+				ts := int64(blk*1000+i*10) + int64(k)
+				values[i] = coder.NewValue(ts, byte(i*int(k)))
+			}
+			b, err := encodeByteBlockUsing(nil, values, coder.NewTimeEncoder(100), coder.NewByteEncoder(100))
+			if err != nil {
+				t.Fatalf("encode block fail: %v", err)
+			}
+			if err := w.WriteBlock(k, values[0].UnixNano, values[99].UnixNano, b); err != nil {
+				t.Fatalf("write block fail: %v", err)
+			}
+			data[k] = append(data[k], values...)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		t.Fatalf("write index fail: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close writer fail: %v", err)
+	}
+	return data
+}
+
+// 校验文件中的数据
+func checkTSM(t *testing.T, path string, data map[uint32]coder.Values) *TSMReader {
+	r, err := OpenTSMReader(path)
+	if err != nil {
+		t.Fatalf("open reader fail: %v", err)
+	}
+	if len(r.Keys()) != len(data) {
+		t.Fatalf("keys count error: except %d, actual %d", len(data), len(r.Keys()))
+	}
+	for k, exp := range data {
+		values, err := r.ReadAll(k)
+		if err != nil {
+			t.Fatalf("read key %d fail: %v", k, err)
+		}
+		if len(values) != len(exp) {
+			t.Fatalf("values count error. key %d: except %d, actual %d", k, len(exp), len(values))
+		}
+		for i := range exp {
+			if values[i] != exp[i] {
+				t.Fatalf("value error. key %d, index %d: except %v, actual %v", k, i, exp[i], values[i])
+			}
+		}
+	}
+	return r
+}
+
+// v1和v2格式读取测试
+func TestTSMReader_ReadAll(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, version := range []byte{Version1, Version2} {
+		path := filepath.Join(dir, formatFileName(int(version), 1))
+		data := mustWriteTSM(t, path, version, []uint32{1, 2, 3})
+		r := checkTSM(t, path, data)
+		if r.Version() != version {
+			t.Fatalf("version error: except %d, actual %d", version, r.Version())
+		}
+		if min, max := r.TimeRange(); min != 1 || max != 1993 {
+			t.Fatalf("time range error: %d-%d", min, max)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("close reader fail: %v", err)
+		}
+	}
+}
+
+// 布隆过滤器长度为0的损坏文件返回错误，不在查询时panic
+func TestTSMReader_EmptyKeyFilter(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, formatFileName(1, 1))
+	mustWriteTSM(t, path, Version, []uint32{1, 2, 3})
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("open file fail: %v", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		t.Fatalf("stat file fail: %v", err)
+	}
+	// 文件尾之前依次为Min Key、Max Key、Bloom Len、Bloom K
+	if _, err := f.WriteAt(make([]byte, 4), stat.Size()-footerSize-footerKeyRangeSize+8); err != nil {
+		t.Fatalf("write file fail: %v", err)
+	}
+	f.Close()
+
+	if r, err := OpenTSMReader(path); err == nil {
+		r.Close()
+		t.Fatalf("expected invalid key filter error")
+	}
+}
+
+// 截断文件后重建Index测试
+func TestRebuildIndex(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, formatFileName(1, 1))
+	data := mustWriteTSM(t, path, Version2, []uint32{1, 2, 3})
+
+	// 取得最后一个block的位置后截断到其中间
+	r, err := OpenTSMReader(path)
+	if err != nil {
+		t.Fatalf("open reader fail: %v", err)
+	}
+	last := r.Entries(3)[1]
+	r.Close()
+	if err := os.Truncate(path, last.Offset+int64(last.Size)/2); err != nil {
+		t.Fatalf("truncate fail: %v", err)
+	}
+	if _, err := OpenTSMReader(path); err == nil {
+		t.Fatalf("expected open truncated file fail")
+	}
+
+	// 重建后丢失最后一个block
+	res, err := RebuildIndex(path)
+	if err != nil {
+		t.Fatalf("rebuild fail: %v", err)
+	}
+	if res.Keys != 3 || res.Blocks != 5 || res.DataSize != last.Offset {
+		t.Fatalf("rebuild result error: %+v", res)
+	}
+	data[3] = data[3][:100]
+	checkTSM(t, path, data).Close()
+}
+
+// block统计值聚合测试
+func TestTSMReader_Aggregate(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for _, version := range []byte{Version2, Version3} {
+		path := filepath.Join(dir, formatFileName(int(version), 1))
+		data := mustWriteTSM(t, path, version, []uint32{3})
+		r := checkTSM(t, path, data)
+
+		// 第一个block完整，第二个block部分重叠
+		min, max := int64(0), int64(1500)
+		agg, err := r.Aggregate(3, min, max)
+		if err != nil {
+			t.Fatalf("aggregate fail: %v", err)
+		}
+		var exp BlockAggregate
+		for _, v := range data[3] {
+			if v.UnixNano >= min && v.UnixNano <= max {
+				exp.addValue(v.UnixNano, v.Value)
+			}
+		}
+		if agg.Count != exp.Count || agg.Sum != exp.Sum || agg.Min != exp.Min || agg.Max != exp.Max ||
+			agg.First != exp.First || agg.Last != exp.Last || agg.FirstTime != exp.FirstTime || agg.LastTime != exp.LastTime {
+			t.Fatalf("aggregate error. version %d: except %+v, actual %+v", version, exp, agg)
+		}
+		if version == Version3 && (agg.StatsBlocks != 1 || agg.DecodedBlocks != 1) {
+			t.Fatalf("expected first block from index stats: %+v", agg)
+		}
+		if version == Version2 && agg.DecodedBlocks != 2 {
+			t.Fatalf("expected all blocks decoded: %+v", agg)
+		}
+		r.Close()
+	}
+}
+
+// 数据块压缩后的读取和重建测试
+func TestTSMReader_Compression(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+
+	for i, compression := range []BlockCompression{CompressionNone, CompressionSnappy, CompressionDeflate} {
+		path := filepath.Join(dir, formatFileName(i+1, 1))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
+		if err != nil {
+			t.Fatalf("create file fail: %v", err)
+		}
+		w, err := NewTSMWriterWithCompression(f, compression)
+		if err != nil {
+			t.Fatalf("new writer fail: %v", err)
+		}
+		data := writeTSMBlocks(t, w, []uint32{1, 2, 3})
+
+		r := checkTSM(t, path, data)
+		if r.Compression() != compression {
+			t.Fatalf("compression error: except %v, actual %v", compression, r.Compression())
+		}
+		r.Close()
+
+		// 重建Index时解压数据块
+		if _, err := RebuildIndex(path); err != nil {
+			t.Fatalf("rebuild %v fail: %v", compression, err)
+		}
+		checkTSM(t, path, data).Close()
+	}
+}
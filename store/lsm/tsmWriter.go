@@ -3,9 +3,12 @@ package lsm
 import (
 	"bufio"
 	"encoding/binary"
+	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
+
+	"github.com/hooone/datacc/common/bloom"
 )
 
 /*
@@ -15,26 +18,54 @@ import (
 │  Magic  │ Version │
 │ 4 bytes │ 1 byte  │
 └─────────┴─────────┘
+┌─────────────────────────────────┐
+│           Header (v5)           │
+├─────────┬─────────┬─────────────┤
+│  Magic  │ Version │ Compression │
+│ 4 bytes │ 1 byte  │   1 byte    │
+└─────────┴─────────┴─────────────┘
+v5格式的数据块Data在packBlock之后按Compression压缩，CRC校验压缩后的数据
 ┌───────────────────────────────────────────────────────────┐
-│                          Blocks                           │
+│                        Blocks (v1)                        │
 ├───────────────────┬───────────────────┬───────────────────┤
 │      Block 1      │      Block 2      │      Block N      │
 ├─────────┬─────────┼─────────┬─────────┼─────────┬─────────┤
 │  CRC    │  Data   │  CRC    │  Data   │  CRC    │  Data   │
 │ 4 bytes │ N bytes │ 4 bytes │ N bytes │ 4 bytes │ N bytes │
 └─────────┴─────────┴─────────┴─────────┴─────────┴─────────┘
+┌─────────────────────────────────────────────────────────────────────────────────┐
+│                                   Block (v2)                                    │
+├─────────┬─────────┬─────────┬────────┬──────────┬───────────┬─────────┬─────────┤
+│  Length │   CRC   │   Key   │  Type  │ Time Enc │ Value Enc │  Count  │   Data  │
+│ 4 bytes │ 4 bytes │ 4 bytes │ 1 byte │  1 byte  │   1 byte  │ 4 bytes │ N bytes │
+└─────────┴─────────┴─────────┴────────┴──────────┴───────────┴─────────┴─────────┘
+Length为CRC及之后的字节数，CRC校验Key及之后的所有数据
 ┌───────────────────────────────────────────────────────────┐
 │                         Index                             │
 ├─────────┬───────┬─────────┬─────────┬────────┬────────┬───┤
 │   Key   │ Count │Min Time │Max Time │ Offset │  Size  │...│
 │ 4 bytes │2 bytes│ 8 bytes │ 8 bytes │8 bytes │4 bytes │   │
 └─────────┴───────┴─────────┴─────────┴────────┴────────┴───┘
+┌───────────────────────────────────────────────────────┐
+│                Index Entry Stats (v3)                 │
+├─────────┬────────┬────────┬────────┬────────┬─────────┤
+│  Count  │  Min   │  Max   │ First  │  Last  │   Sum   │
+│ 4 bytes │ 1 byte │ 1 byte │ 1 byte │ 1 byte │ 8 bytes │
+└─────────┴────────┴────────┴────────┴────────┴─────────┘
+v3格式在每个IndexEntry的Size之后追加block统计值
 ┌─────────┐
 │ Footer  │
 ├─────────┤
 │Index Ofs│
 │ 8 bytes │
 └─────────┘
+┌──────────────────────────────────────────────────────────────────────┐
+│                             Footer (v4)                              │
+├─────────┬─────────┬─────────┬───────────┬──────────┬─────────────────┤
+│  Bloom  │ Min Key │ Max Key │ Bloom Len │ Bloom K  │    Index Ofs    │
+│ N bytes │ 4 bytes │ 4 bytes │  4 bytes  │  1 byte  │     8 bytes     │
+└─────────┴─────────┴─────────┴───────────┴──────────┴─────────────────┘
+v4格式在Index区之后写入key的布隆过滤器和key的范围，用于不读取Index区判断key是否存在
 */
 
 const (
@@ -42,7 +73,35 @@ const (
 	MagicNumber uint32 = 0x16D116D0
 
 	// 版本号
-	Version byte = 1
+	Version1 byte = 1
+	// 数据块带有key、类型和编码信息的版本，可以在Index损坏时重建
+	Version2 byte = 2
+	// IndexEntry带有block统计值的版本
+	Version3 byte = 3
+	// 文件尾带有key的布隆过滤器和key范围的版本
+	Version4 byte = 4
+	// 文件头带有数据块压缩方式的版本
+	Version5 byte = 5
+	// 当前写入的版本号
+	Version = Version5
+
+	// 文件头大小
+	headerSize = 5
+	// v5文件头大小
+	headerSizeV5 = headerSize + 1
+	// 文件尾大小
+	footerSize = 8
+	// v4文件尾中Index位置之前的定长部分: Min Key、Max Key、Bloom Len、Bloom K
+	footerKeyRangeSize = keyLength + keyLength + 4 + 1
+	// 布隆过滤器的期望误判率
+	bloomFalsePositiveRate = 0.01
+
+	// v2数据块的数据类型
+	BlockByte byte = 1
+	// v2数据块的长度字段大小
+	blockLengthSize = 4
+	// v2数据块在CRC之后的头部大小: Key、类型、时间编码、内容编码、数量
+	blockHeaderSize = keyLength + 1 + 1 + 1 + 4
 
 	// Key的长度
 	keyLength = 4
@@ -55,7 +114,7 @@ const (
 type TSMWriter interface {
 	WriteBlock(key uint32, minTime, maxTime int64, block []byte) error
 	WriteIndex() error
-	Size() uint32
+	Size() uint64
 	Remove() error
 	Close() error
 }
@@ -72,6 +131,17 @@ type tsmWriter struct {
 
 	// 最近一次刷盘时的文件大小
 	lastSync int64
+
+	// 写入的文件格式版本
+	version byte
+
+	// 写入的所有key，用于生成布隆过滤器
+	keys []uint32
+	// 当前key已写入的block数量
+	keyBlocks int
+
+	// 数据块的压缩方式
+	compression BlockCompression
 }
 
 type syncer interface {
@@ -80,8 +150,29 @@ type syncer interface {
 }
 
 func NewTSMWriter(w io.Writer) (TSMWriter, error) {
-	index := NewIndexWriter()
-	return &tsmWriter{wrapped: w, bufw: bufio.NewWriterSize(w, 1024*1024), index: index}, nil
+	return newTSMWriterVersion(w, Version)
+}
+
+// 新建数据块按compression压缩的writer
+func NewTSMWriterWithCompression(w io.Writer, compression BlockCompression) (TSMWriter, error) {
+	if !compression.valid() {
+		return nil, fmt.Errorf("unknown block compression: %d", byte(compression))
+	}
+	tw, err := newTSMWriterVersion(w, Version)
+	if err != nil {
+		return nil, err
+	}
+	tw.(*tsmWriter).compression = compression
+	return tw, nil
+}
+
+// 以指定的文件格式版本新建writer
+func newTSMWriterVersion(w io.Writer, version byte) (TSMWriter, error) {
+	if version < Version1 || version > Version {
+		return nil, fmt.Errorf("unsupported tsm version: %d", version)
+	}
+	index := newIndexWriterVersion(version)
+	return &tsmWriter{wrapped: w, bufw: bufio.NewWriterSize(w, 1024*1024), index: index, version: version}, nil
 }
 
 // 把一个block的数据写入文件，并缓存key等属性
@@ -98,23 +189,69 @@ func (t *tsmWriter) WriteBlock(key uint32, minTime, maxTime int64, block []byte)
 		}
 	}
 
+	// v2格式在数据前写入长度和块头部，头部信息取自压缩前的数据
+	var n int
+	var header []byte
+	data := block
+	if t.version >= Version2 {
+		var err error
+		if header, err = encodeBlockHeader(key, block); err != nil {
+			return err
+		}
+		if data, err = compressBlock(t.compression, block); err != nil {
+			return err
+		}
+		var length [blockLengthSize]byte
+		binary.LittleEndian.PutUint32(length[:], uint32(crc32.Size+len(header)+len(data)))
+		if _, err := t.bufw.Write(length[:]); err != nil {
+			return err
+		}
+		n += len(length)
+	}
+
 	// 写入每个块头部的CRC校验码
 	var checksum [crc32.Size]byte
-	binary.LittleEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(block))
+	crc := crc32.NewIEEE()
+	crc.Write(header)
+	crc.Write(data)
+	binary.LittleEndian.PutUint32(checksum[:], crc.Sum32())
 	_, err := t.bufw.Write(checksum[:])
 	if err != nil {
 		return err
 	}
+	n += len(checksum)
+
+	// 写入块头部
+	if _, err := t.bufw.Write(header); err != nil {
+		return err
+	}
+	n += len(header)
 
 	// 写入文件块数据
-	n, err := t.bufw.Write(block)
+	nb, err := t.bufw.Write(data)
 	if err != nil {
 		return err
 	}
-	n += len(checksum)
+	n += nb
 
 	// 把数据块的属性缓存到index缓存器中
-	t.index.Add(key, minTime, maxTime, t.n, uint32(n))
+	entry := IndexEntry{
+		MinTime: minTime,
+		MaxTime: maxTime,
+		Offset:  t.n,
+		Size:    uint32(n),
+	}
+	if t.version >= Version3 {
+		if err := entry.setStats(block); err != nil {
+			return err
+		}
+	}
+	t.index.Add(key, entry)
+	if len(t.keys) == 0 || t.keys[len(t.keys)-1] != key {
+		t.keys = append(t.keys, key)
+		t.keyBlocks = 0
+	}
+	t.keyBlocks++
 
 	// 累计写入数量
 	t.n += int64(n)
@@ -127,6 +264,11 @@ func (t *tsmWriter) WriteBlock(key uint32, minTime, maxTime int64, block []byte)
 		t.lastSync = t.n
 	}
 
+	// Index区中每个key的block数量只有2个byte，写满后由调用方结束当前文件，剩余的block写入下一个文件
+	if t.keyBlocks >= maxIndexEntries {
+		return ErrMaxBlocksExceeded
+	}
+
 	return nil
 }
 
@@ -147,20 +289,83 @@ func (t *tsmWriter) WriteIndex() error {
 		return err
 	}
 
+	// v4格式写入布隆过滤器和key范围
+	if t.version >= Version4 {
+		if err := writeKeyFilter(t.bufw, t.keys); err != nil {
+			return err
+		}
+	}
+
 	// 写Index的位置(Foot部分)
-	var buf [8]byte
+	var buf [footerSize]byte
 	indexPos := t.n
 	binary.LittleEndian.PutUint64(buf[:], uint64(indexPos))
 	_, err := t.bufw.Write(buf[:])
 	return err
 }
 
-// 写入文件头: 识别码和版本号
+// 写入key的布隆过滤器和key范围
+func writeKeyFilter(w io.Writer, keys []uint32) error {
+	m, k := bloom.Estimate(uint64(len(keys)), bloomFalsePositiveRate)
+	filter := bloom.NewFilter(m, k)
+	minKey, maxKey := keys[0], keys[0]
+	var kb [keyLength]byte
+	for _, key := range keys {
+		binary.LittleEndian.PutUint32(kb[:], key)
+		filter.Insert(kb[:])
+		if key < minKey {
+			minKey = key
+		}
+		if key > maxKey {
+			maxKey = key
+		}
+	}
+
+	if _, err := w.Write(filter.Bytes()); err != nil {
+		return err
+	}
+	var buf [footerKeyRangeSize]byte
+	binary.LittleEndian.PutUint32(buf[0:4], minKey)
+	binary.LittleEndian.PutUint32(buf[4:8], maxKey)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(filter.Bytes())))
+	buf[12] = byte(filter.K())
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// 生成v2数据块的头部
+func encodeBlockHeader(key uint32, block []byte) ([]byte, error) {
+	timeEnc, valueEnc, count, err := blockInfo(block)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, blockHeaderSize)
+	binary.LittleEndian.PutUint32(b[0:4], key)
+	b[4] = BlockByte
+	b[5] = timeEnc
+	b[6] = valueEnc
+	binary.LittleEndian.PutUint32(b[7:11], uint32(count))
+	return b, nil
+}
+
+// 解析v2数据块的头部
+func decodeBlockHeader(b []byte) (key uint32, typ, timeEnc, valueEnc byte, count int, err error) {
+	if len(b) < blockHeaderSize {
+		return 0, 0, 0, 0, 0, fmt.Errorf("block header too short: %d", len(b))
+	}
+	key = binary.LittleEndian.Uint32(b[0:4])
+	typ, timeEnc, valueEnc = b[4], b[5], b[6]
+	count = int(binary.LittleEndian.Uint32(b[7:11]))
+	return
+}
+
+// 写入文件头: 识别码和版本号，v5格式还有数据块的压缩方式
 func (t *tsmWriter) writeHeader() error {
-	var buf [5]byte
+	var buf [headerSizeV5]byte
 	binary.LittleEndian.PutUint32(buf[0:4], MagicNumber)
-	buf[4] = Version
-	n, err := t.bufw.Write(buf[:])
+	buf[4] = t.version
+	buf[5] = byte(t.compression)
+	n, err := t.bufw.Write(buf[:headerSizeOf(t.version)])
 	if err != nil {
 		return err
 	}
@@ -168,9 +373,17 @@ func (t *tsmWriter) writeHeader() error {
 	return nil
 }
 
+// 文件头大小
+func headerSizeOf(version byte) int {
+	if version >= Version5 {
+		return headerSizeV5
+	}
+	return headerSize
+}
+
 // 获得当前写入的文件大小
-func (t *tsmWriter) Size() uint32 {
-	return uint32(t.n) + t.index.Size()
+func (t *tsmWriter) Size() uint64 {
+	return uint64(t.n) + t.index.Size()
 }
 
 // Remove 移除当前writer使用的文件
@@ -2,8 +2,12 @@ package wal
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -55,6 +59,8 @@ type WAL struct {
 	syncDelay time.Duration
 	// 用于传递刷写硬盘的结果，把结果传递给所有在等待的协程
 	syncWaiters chan chan error
+	// 每次刷盘后回调刷盘耗时，可用于自适应限流
+	syncObserver func(time.Duration)
 }
 
 func NewWAL(path string) *WAL {
@@ -67,6 +73,102 @@ func NewWAL(path string) *WAL {
 	}
 }
 
+// WAL中的写入位置
+type Position struct {
+	// WAL文件的序列号
+	SegmentID int
+	// 文件内的字节偏移
+	Offset int64
+}
+
+// 打开WAL目录，从已有文件的最大序列号之后继续写入
+func (l *WAL) Open() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(l.path, 0777); err != nil {
+		return err
+	}
+	names, err := SegmentFileNames(l.path)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		id, err := ParseSegmentID(names[len(names)-1])
+		if err != nil {
+			return err
+		}
+		l.currentSegmentID = id
+	}
+	return nil
+}
+
+// 刷盘并关闭当前文件
+func (l *WAL) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.closing:
+		return nil
+	default:
+		close(l.closing)
+	}
+
+	if l.currentSegmentWriter == nil {
+		return nil
+	}
+	l.sync()
+	return l.currentSegmentWriter.close()
+}
+
+// 当前已写入的位置。打开后还没有写入时为下一个文件的开头，已有的文件都在该位置之前
+func (l *WAL) Position() Position {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.currentSegmentWriter == nil {
+		return Position{SegmentID: l.currentSegmentID + 1}
+	}
+	return Position{SegmentID: l.currentSegmentID, Offset: int64(l.currentSegmentWriter.getSize())}
+}
+
+// 目录下的所有WAL文件，按序列号排序
+func SegmentFileNames(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// 序列号超过5位后文件名不再等长，按解析出的序列号排序
+	var names []string
+	ids := make(map[string]int)
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasPrefix(name, WALFilePrefix) || !strings.HasSuffix(name, "."+WALFileExtension) {
+			continue
+		}
+		id, err := ParseSegmentID(name)
+		if err != nil {
+			continue
+		}
+		name = filepath.Join(dir, name)
+		ids[name] = id
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return ids[names[i]] < ids[names[j]] })
+	return names, nil
+}
+
+// 从WAL文件名中解析序列号
+func ParseSegmentID(name string) (int, error) {
+	base := filepath.Base(name)
+	idStr := strings.TrimSuffix(strings.TrimPrefix(base, WALFilePrefix), "."+WALFileExtension)
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid WAL file name: %s", name)
+	}
+	return id, nil
+}
+
 // 把数据写入WAL并计数
 func (l *WAL) WriteMulti(values map[uint32][]coder.Value) (int, error) {
 	entry := &WriteWALEntry{
@@ -168,8 +270,7 @@ func (l *WAL) newSegmentFile() error {
 	}
 
 	// 新建文件并打开
-	fileName := filepath.Join(l.path, fmt.Sprintf("%s%05d.%s", WALFilePrefix, l.currentSegmentID, WALFileExtension))
-	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0666)
+	fd, err := os.OpenFile(l.segmentFileName(l.currentSegmentID), os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		return err
 	}
@@ -181,6 +282,67 @@ func (l *WAL) newSegmentFile() error {
 	return nil
 }
 
+// 序列号对应的WAL文件路径
+func (l *WAL) segmentFileName(id int) string {
+	return filepath.Join(l.path, fmt.Sprintf("%s%05d.%s", WALFilePrefix, id, WALFileExtension))
+}
+
+// CloseSegment 关闭当前文件，之后的写入使用新文件
+func (l *WAL) CloseSegment() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.currentSegmentWriter == nil {
+		return nil
+	}
+	return l.newSegmentFile()
+}
+
+// ClosedSegments 除当前写入的文件外的所有WAL文件，按序列号排序
+func (l *WAL) ClosedSegments() ([]string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	names, err := SegmentFileNames(l.path)
+	if err != nil {
+		return nil, err
+	}
+	if l.currentSegmentWriter == nil {
+		return names, nil
+	}
+	current := l.segmentFileName(l.currentSegmentID)
+	closed := names[:0]
+	for _, name := range names {
+		if name != current {
+			closed = append(closed, name)
+		}
+	}
+	return closed, nil
+}
+
+// Remove 删除数据已经写入TSM文件的WAL文件
+func (l *WAL) Remove(files []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, fn := range files {
+		if err := os.RemoveAll(fn); err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt64(&l.stats.OldBytes, 0)
+	return nil
+}
+
+// 状态统计
+func (l *WAL) Statistics() WALStatistics {
+	return WALStatistics{
+		OldBytes:       atomic.LoadInt64(&l.stats.OldBytes),
+		CurrentBytes:   atomic.LoadInt64(&l.stats.CurrentBytes),
+		WriteOK:        atomic.LoadInt64(&l.stats.WriteOK),
+		WriteErr:       atomic.LoadInt64(&l.stats.WriteErr),
+		SyncDurationNs: atomic.LoadInt64(&l.stats.SyncDurationNs),
+	}
+}
+
 // 检查是否需要切换到下一个文件
 func (l *WAL) rollSegment() error {
 	if l.currentSegmentWriter == nil || l.currentSegmentWriter.getSize() > DefaultSegmentSize {
@@ -193,9 +355,22 @@ func (l *WAL) rollSegment() error {
 	return nil
 }
 
+// 设置刷盘耗时的回调，需要在写入前调用
+func (l *WAL) SetSyncObserver(fn func(time.Duration)) {
+	l.mu.Lock()
+	l.syncObserver = fn
+	l.mu.Unlock()
+}
+
 // 刷盘并反馈结果
 func (l *WAL) sync() {
+	start := time.Now()
 	err := l.currentSegmentWriter.sync()
+	d := time.Since(start)
+	atomic.StoreInt64(&l.stats.SyncDurationNs, int64(d))
+	if l.syncObserver != nil {
+		l.syncObserver(d)
+	}
 	for len(l.syncWaiters) > 0 {
 		errC := <-l.syncWaiters
 		errC <- err
@@ -5,4 +5,6 @@ type WALStatistics struct {
 	CurrentBytes int64
 	WriteOK      int64
 	WriteErr     int64
+	// 最近一次刷盘的耗时
+	SyncDurationNs int64
 }
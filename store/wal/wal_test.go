@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/hooone/datacc/store/coder"
@@ -31,6 +32,66 @@ func TestWAL_Write(t *testing.T) {
 		t.Fatalf("write WAL fail: %v", err)
 	}
 }
+
+// 关闭当前文件后，已关闭的文件可以删除，写入继续使用新文件
+func TestWAL_CloseSegment(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	wal := NewWAL(dir)
+	if err := wal.Open(); err != nil {
+		t.Fatalf("open WAL fail: %v", err)
+	}
+	defer wal.Close()
+
+	values := map[uint32][]coder.Value{1: {coder.NewValue(1, 2)}}
+	if _, err := wal.WriteMulti(values); err != nil {
+		t.Fatalf("write WAL fail: %v", err)
+	}
+	if err := wal.CloseSegment(); err != nil {
+		t.Fatalf("close segment fail: %v", err)
+	}
+	closed, err := wal.ClosedSegments()
+	if err != nil || len(closed) != 1 {
+		t.Fatalf("closed segments error: %v %v", closed, err)
+	}
+	if id, err := wal.WriteMulti(values); err != nil || id != 2 {
+		t.Fatalf("write new segment error: %d %v", id, err)
+	}
+	if err := wal.Remove(closed); err != nil {
+		t.Fatalf("remove segments fail: %v", err)
+	}
+	if names, _ := SegmentFileNames(dir); len(names) != 1 {
+		t.Fatalf("segments after remove error: %v", names)
+	}
+	if stats := wal.Statistics(); stats.WriteOK != 2 || stats.CurrentBytes == 0 {
+		t.Fatalf("statistics error: %+v", stats)
+	}
+}
+
+// 序列号超过5位时仍按序列号排序
+func TestSegmentFileNames(t *testing.T) {
+	dir := MustTempDir()
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"_100000.wal", "_99999.wal", "_00002.wal", "_1.wal.tmp"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatalf("create file fail: %v", err)
+		}
+	}
+	names, err := SegmentFileNames(dir)
+	if err != nil {
+		t.Fatalf("list segments fail: %v", err)
+	}
+	except := []string{"_00002.wal", "_99999.wal", "_100000.wal"}
+	if len(names) != len(except) {
+		t.Fatalf("except %v, actual %v", except, names)
+	}
+	for i, name := range names {
+		if filepath.Base(name) != except[i] {
+			t.Fatalf("except %v, actual %v", except, names)
+		}
+	}
+}
+
 func TestWAL_ReadWrite(t *testing.T) {
 	// 准备文件
 	dir := MustTempDir()
@@ -120,3 +181,32 @@ func MustTempFile(dir string) *os.File {
 	}
 	return f
 }
+
+func TestWriteWALEntry_UnmarshalBinary(t *testing.T) {
+	values := map[uint32][]coder.Value{
+		1: {coder.NewValue(11, 23), coder.NewValue(12, 24)},
+		2: {coder.NewValue(17, 29)},
+	}
+	b, err := (&WriteWALEntry{Values: values}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("encode fail: %v", err)
+	}
+	entry := &WriteWALEntry{Values: make(map[uint32][]coder.Value)}
+	if err := entry.UnmarshalBinary(b); err != nil {
+		t.Fatalf("decode fail: %v", err)
+	}
+	if len(entry.Values) != len(values) {
+		t.Fatalf("except %d keys, actual %d", len(values), len(entry.Values))
+	}
+	for k, except := range values {
+		actual := entry.Values[k]
+		if len(actual) != len(except) {
+			t.Fatalf("key %d: except %v, actual %v", k, except, actual)
+		}
+		for i := range except {
+			if actual[i].UnixNano != except[i].UnixNano || actual[i].Value != except[i].Value {
+				t.Fatalf("key %d: except %v, actual %v", k, except, actual)
+			}
+		}
+	}
+}
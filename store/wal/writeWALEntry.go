@@ -58,7 +58,7 @@ func (w *WriteWALEntry) Encode(dst []byte) ([]byte, error) {
 func (w *WriteWALEntry) UnmarshalBinary(b []byte) error {
 	var i int
 	lastKey := uint32(0)
-	values := make([]coder.Value, 1)
+	values := make([]coder.Value, 0)
 	for i < len(b) {
 		// 长度确认
 		if i+9 > len(b) {